@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const header = "Code generated by ble-gen from protocol.yaml. DO NOT EDIT."
+
+// GenerateGo returns the ble package source of the spec: the message type
+// and subtype constants, the firmware names, the enums and the registered
+// fields.
+func GenerateGo(spec *Spec) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %s\n\npackage ble\n\n", header)
+
+	b.WriteString("// Message types\nconst (\n")
+	for _, t := range spec.Types {
+		fmt.Fprintf(&b, "\tType%s MessageType = 0x%04X // %s%s", t.Go, t.value, spec.Prefix, t.Name)
+		if t.Parent != "" {
+			fmt.Fprintf(&b, ", %s + %d", t.Parent, t.Offset)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(")\n\n")
+
+	// Subtypes of a Go type of their own get a const block each
+	blocks := map[string][]*Type{}
+	var order []string
+	for _, t := range spec.Types {
+		goType := t.SubtypeType
+		if goType == "" {
+			goType = "SubType"
+		}
+		if _, ok := blocks[goType]; !ok {
+			order = append(order, goType)
+		}
+		blocks[goType] = append(blocks[goType], t)
+	}
+	for _, goType := range order {
+		if goType == "SubType" {
+			b.WriteString("// Subtypes, relative to their message type\n")
+		} else {
+			fmt.Fprintf(&b, "// %s subtypes\n", goType)
+		}
+		b.WriteString("const (\n")
+		first := true
+		for _, t := range blocks[goType] {
+			var consts []*Subtype
+			for _, s := range t.Subtypes {
+				if s.HasConst() {
+					consts = append(consts, s)
+				}
+			}
+			if len(consts) == 0 {
+				continue
+			}
+			if !first {
+				b.WriteString("\n")
+			}
+			first = false
+			if goType == "SubType" {
+				fmt.Fprintf(&b, "\t// %s\n", t.Name)
+			}
+			for _, s := range consts {
+				fmt.Fprintf(&b, "\t%s %s = %d // %s%s", s.ConstName(t), goType, s.Value, spec.Prefix, s.FirmwareName(t))
+				if s.Doc != "" {
+					fmt.Fprintf(&b, ", %s", s.Doc)
+				}
+				b.WriteString("\n")
+			}
+		}
+		b.WriteString(")\n\n")
+	}
+
+	fmt.Fprintf(&b, "// typeNames are the names of the message types in the nRF52 firmware,\n// without the %s prefix\n", spec.Prefix)
+	b.WriteString("var typeNames = map[MessageType]string{\n")
+	for _, t := range spec.Types {
+		fmt.Fprintf(&b, "\tType%s: %q,\n", t.Go, t.Name)
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// Enums mapping wire values to Redis strings\nvar (\n")
+	for _, name := range enumNames(spec) {
+		fmt.Fprintf(&b, "\t%s = Enum{", enumVar(name))
+		for i, v := range spec.Enums[name] {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "{%d, %q}", v.Value, v.Name)
+		}
+		b.WriteString("}\n")
+	}
+	b.WriteString(")\n\n")
+
+	b.WriteString("// enums are the enums by their name in protocol.yaml\nvar enums = map[string]Enum{\n")
+	for _, name := range enumNames(spec) {
+		fmt.Fprintf(&b, "\t%q: %s,\n", name, enumVar(name))
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// Fields exchanged with the nRF52, registered in this order\nvar (\n")
+	first := true
+	for _, t := range spec.Types {
+		var fields []*Subtype
+		for _, s := range t.Subtypes {
+			if s.Field != "" {
+				fields = append(fields, s)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if !first {
+			b.WriteString("\n")
+		}
+		first = false
+		if t.Doc != "" {
+			fmt.Fprintf(&b, "\t// %s\n", t.Doc)
+		}
+		for _, s := range fields {
+			fmt.Fprintf(&b, "\t%s = Register(%s)\n", s.FieldName(t), fieldLiteral(t, s))
+		}
+	}
+	b.WriteString(")\n")
+
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %v", err)
+	}
+	return src, nil
+}
+
+// fieldLiteral returns the Field composite literal of a subtype
+func fieldLiteral(t *Type, s *Subtype) string {
+	parts := []string{"Type: Type" + t.Go}
+	switch {
+	case !s.HasConst():
+		if s.Value != 0 {
+			parts = append(parts, fmt.Sprintf("SubType: %d", s.Value))
+		}
+	case t.SubtypeType != "":
+		parts = append(parts, fmt.Sprintf("SubType: SubType(%s)", s.ConstName(t)))
+	default:
+		parts = append(parts, "SubType: "+s.ConstName(t))
+	}
+	parts = append(parts, "Name: "+strconv.Quote(s.Field), "Kind: "+kinds[s.Kind])
+	if s.Wire != "" && s.Wire != "uint16" {
+		parts = append(parts, "Wire: "+wires[s.Wire])
+	}
+	parts = append(parts, "Direction: "+directions[s.Direction])
+	if len(s.Redis) == 2 {
+		parts = append(parts, "RedisKey: "+strconv.Quote(s.Redis[0]), "RedisField: "+strconv.Quote(s.Redis[1]))
+	}
+	if s.Publish {
+		parts = append(parts, "Publish: true")
+	}
+	if s.Default != "" {
+		parts = append(parts, "Default: "+strconv.Quote(s.Default))
+	}
+	if s.Enum != "" {
+		parts = append(parts, "Enum: "+enumVar(s.Enum))
+	}
+	if s.Fallback != "" {
+		parts = append(parts, "EnumFallback: "+strconv.Quote(s.Fallback))
+	}
+	if s.AnySubType {
+		parts = append(parts, "AnySubType: true")
+	}
+	return "Field{" + strings.Join(parts, ", ") + "}"
+}
+
+// enumNames returns the enum names in alphabetical order
+func enumNames(spec *Spec) []string {
+	names := make([]string, 0, len(spec.Enums))
+	for name := range spec.Enums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// enumVar returns the Go variable of an enum, e.g. vehicleStateEnum for vehicle-state
+func enumVar(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' })
+	for i := 1; i < len(words); i++ {
+		words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+	}
+	return strings.Join(words, "") + "Enum"
+}
+
+// GenerateMarkdown returns the protocol reference of the spec
+func GenerateMarkdown(spec *Spec) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!-- %s -->\n\n", header)
+	b.WriteString("# USOCK protocol reference\n\n")
+	b.WriteString("Every frame payload is a CBOR map `{type: {absolute subtype: value, ...}, ...}`. ")
+	b.WriteString("The absolute subtype is the message type plus the subtype and is unique across all message types. ")
+	fmt.Fprintf(&b, "Firmware names carry the `%s` prefix. ", spec.Prefix)
+	b.WriteString("Integers are sent in as few bytes as their value needs but must fit the wire type of their field.\n\n")
+	b.WriteString("This file is generated from `pkg/ble/protocol.yaml` by `go generate ./pkg/ble`.\n\n")
+
+	b.WriteString("## Message types\n\n| Type | Name | Description |\n| --- | --- | --- |\n")
+	for _, t := range spec.Types {
+		doc := t.Doc
+		if t.Parent != "" {
+			doc = strings.TrimSpace(fmt.Sprintf("%s (%s + %d)", doc, t.Parent, t.Offset))
+		}
+		fmt.Fprintf(&b, "| `0x%04X` | [%s](#%s) | %s |\n", t.value, t.Name, anchor(t.Name), doc)
+	}
+
+	for _, t := range spec.Types {
+		fmt.Fprintf(&b, "\n## %s\n\n`0x%04X`", t.Name, t.value)
+		if t.Doc != "" {
+			fmt.Fprintf(&b, ": %s", t.Doc)
+		}
+		b.WriteString("\n")
+		if len(t.Subtypes) == 0 {
+			continue
+		}
+		b.WriteString("\n| Key | Subtype | Name | Field | Value | Direction | Redis |\n| --- | --- | --- | --- | --- | --- | --- |\n")
+		for _, s := range t.Subtypes {
+			key := fmt.Sprintf("`0x%04X`", s.Key(t))
+			if s.AnySubType {
+				key = "any"
+			}
+			field, value, direction, redis := "", "", "", ""
+			switch {
+			case s.Reserved:
+				field = "reserved"
+			case s.Field != "":
+				field = "`" + s.Field + "`"
+				value = s.Kind
+				if s.Kind == "int" || s.Kind == "bool" {
+					wire := s.Wire
+					if wire == "" {
+						wire = "uint16"
+					}
+					value += " (" + wire + ")"
+				}
+				if s.Enum != "" {
+					value += fmt.Sprintf(", [%s](#%s)", s.Enum, anchor(s.Enum))
+				}
+				if s.Fallback != "" {
+					value += fmt.Sprintf(", others as `%s`", s.Fallback)
+				}
+				direction = s.Direction
+				if len(s.Redis) == 2 {
+					redis = fmt.Sprintf("`%s` `%s`", s.Redis[0], s.Redis[1])
+					if s.Publish {
+						redis += ", published"
+					}
+				}
+				if s.Default != "" {
+					redis += fmt.Sprintf(", default `%s`", s.Default)
+				}
+				redis = strings.TrimPrefix(redis, ", ")
+			}
+			if s.Doc != "" {
+				field = strings.TrimPrefix(field+", "+strings.ReplaceAll(s.Doc, "|", `\|`), ", ")
+			}
+			fmt.Fprintf(&b, "| %s | %d | %s | %s | %s | %s | %s |\n", key, s.Value, s.FirmwareName(t), field, value, direction, redis)
+		}
+	}
+
+	b.WriteString("\n## Enums\n")
+	for _, name := range enumNames(spec) {
+		fmt.Fprintf(&b, "\n### %s\n\n| Value | Name |\n| --- | --- |\n", name)
+		for _, v := range spec.Enums[name] {
+			fmt.Fprintf(&b, "| %d | `%s` |\n", v.Value, v.Name)
+		}
+	}
+	return []byte(b.String())
+}
+
+// anchor returns the GitHub anchor of a heading
+func anchor(heading string) string {
+	return strings.ToLower(strings.ReplaceAll(heading, " ", "-"))
+}
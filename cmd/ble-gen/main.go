@@ -0,0 +1,52 @@
+// Command ble-gen generates the message types, subtypes and fields of the
+// ble package and the Markdown protocol reference from the protocol spec.
+// It fails on collisions in the spec. Run it with go generate ./pkg/ble.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var (
+	specPath = flag.String("spec", "protocol.yaml", "Protocol spec")
+	goPath   = flag.String("go", "protocol_gen.go", "Go output, empty to skip")
+	mdPath   = flag.String("md", "", "Markdown output, empty to skip")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Generates the ble package protocol code and reference from the protocol spec.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("ble-gen: ")
+
+	data, err := os.ReadFile(*specPath)
+	if err != nil {
+		log.Fatalf("Failed to read spec: %v", err)
+	}
+	spec, err := ParseSpec(data)
+	if err != nil {
+		log.Fatalf("%s: %v", *specPath, err)
+	}
+
+	if *goPath != "" {
+		src, err := GenerateGo(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*goPath, src, 0644); err != nil {
+			log.Fatalf("Failed to write Go output: %v", err)
+		}
+	}
+	if *mdPath != "" {
+		if err := os.WriteFile(*mdPath, GenerateMarkdown(spec), 0644); err != nil {
+			log.Fatalf("Failed to write Markdown output: %v", err)
+		}
+	}
+}
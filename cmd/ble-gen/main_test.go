@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestGeneratedUpToDate fails when protocol.yaml was changed without
+// running go generate ./pkg/ble
+func TestGeneratedUpToDate(t *testing.T) {
+	data, err := os.ReadFile("../../pkg/ble/protocol.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := ParseSpec(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := GenerateGo(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string][]byte{
+		"../../pkg/ble/protocol_gen.go": src,
+		"../../docs/protocol.md":        GenerateMarkdown(spec),
+	} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date, run go generate ./pkg/ble", path)
+		}
+	}
+}
+
+const baseSpec = `
+prefix: P_
+enums:
+  onoff: [{value: 0, name: "off"}, {value: 1, name: "on"}]
+types:
+  - name: A
+    go: A
+    value: 0x0010
+    subtypes:
+      - {name: X, go: AX, value: 1, field: x, kind: int, direction: inbound, enum: onoff}
+  - name: B
+    go: B
+    parent: A
+    offset: 8
+    subtypes:
+`
+
+func TestParseSpecCollisions(t *testing.T) {
+	if _, err := ParseSpec([]byte(baseSpec)); err != nil {
+		t.Fatalf("base spec: %v", err)
+	}
+	for _, tc := range []struct {
+		name  string
+		extra string
+		want  string
+	}{
+		{"out of range", "      - {name: Y, go: BY, value: 0xFFF0}", "out of range"},
+		{"shared key", "      - {name: Y, go: BY, value: 0, field: y, kind: int, direction: inbound}\n" +
+			"  - name: C\n    go: C\n    value: 0x0011\n    subtypes:\n      - {name: Y, go: CY, value: 0x0007}", "share the absolute subtype 0x0018"},
+		{"message type", "  - name: C\n    go: C\n    value: 0x0018", "are both 0x0018"},
+		{"type name", "  - name: A\n    go: C\n    value: 0x0030", "share the firmware name A"},
+		{"Go name", "      - {name: Y, go: BX, value: 1}\n  - name: C\n    go: BX\n    value: 0x0030", "both declare TypeBX"},
+		{"subtype twice", "      - {name: Y, go: BY, value: 1}\n      - {name: Z, go: BZ, value: 1}", "declared twice"},
+		{"field twice", "      - {name: Y, go: BY, value: 1, field: y, kind: int, direction: inbound}\n" +
+			"      - {name: Z, go: BZ, value: 2, field: y, kind: int, direction: inbound}", `field "y" declared twice`},
+		{"unknown enum", "      - {name: Y, go: BY, value: 1, field: y, kind: int, direction: inbound, enum: nope}", "unknown enum"},
+		{"fallback without enum", "      - {name: Y, go: BY, value: 1, field: y, kind: int, direction: inbound, enum_fallback: \"y %d\"}", "enum_fallback without an enum"},
+		{"unknown key", "      - {name: Y, go: BY, value: 1, colour: red}", "not found"},
+	} {
+		_, err := ParseSpec([]byte(baseSpec + tc.extra + "\n"))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the protocol description, see pkg/ble/protocol.yaml
+type Spec struct {
+	Prefix string                 `yaml:"prefix"`
+	Enums  map[string][]EnumValue `yaml:"enums"`
+	Types  []*Type                `yaml:"types"`
+}
+
+// EnumValue maps a wire value to its Redis name
+type EnumValue struct {
+	Value int    `yaml:"value"`
+	Name  string `yaml:"name"`
+}
+
+// Type is a message type and its subtypes
+type Type struct {
+	Name        string     `yaml:"name"`
+	Go          string     `yaml:"go"`
+	Value       *int       `yaml:"value"`
+	Parent      string     `yaml:"parent"`
+	Offset      int        `yaml:"offset"`
+	Doc         string     `yaml:"doc"`
+	ConstPrefix string     `yaml:"const_prefix"`
+	SubtypeType string     `yaml:"subtype_type"`
+	Subtypes    []*Subtype `yaml:"subtypes"`
+
+	value uint16 // Resolved message type
+}
+
+// Subtype is a subtype of a message type and the field registered for it
+type Subtype struct {
+	Name       string   `yaml:"name"`
+	Firmware   string   `yaml:"firmware"`
+	Go         string   `yaml:"go"`
+	Value      int      `yaml:"value"`
+	Const      *bool    `yaml:"const"`
+	Reserved   bool     `yaml:"reserved"`
+	Doc        string   `yaml:"doc"`
+	Field      string   `yaml:"field"`
+	Kind       string   `yaml:"kind"`
+	Wire       string   `yaml:"wire"`
+	Direction  string   `yaml:"direction"`
+	Redis      []string `yaml:"redis"`
+	Publish    bool     `yaml:"publish"`
+	Default    string   `yaml:"default"`
+	Enum       string   `yaml:"enum"`
+	Fallback   string   `yaml:"enum_fallback"` // Printf format of values not in Enum, e.g. "MAX1730X (%d)"
+	AnySubType bool     `yaml:"any_subtype"`
+}
+
+// Go names of the spec values, in the ble package
+var (
+	kinds      = map[string]string{"int": "KindInt", "string": "KindString", "bool": "KindBool", "array": "KindArray", "any": "KindAny", "bytes": "KindBytes"}
+	wires      = map[string]string{"uint16": "WireUint16", "uint32": "WireUint32", "int32": "WireInt32", "int64": "WireInt64"}
+	directions = map[string]string{"inbound": "Inbound", "outbound": "Outbound", "both": "Both"}
+)
+
+// ParseSpec decodes and validates a spec. Unknown keys are errors, so are
+// collisions: duplicate names, message types, subtypes, absolute subtypes
+// and Go identifiers.
+func ParseSpec(data []byte) (*Spec, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var spec Spec
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("failed to parse spec: %v", err)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// HasConst tells whether the subtype gets a Go constant
+func (s *Subtype) HasConst() bool {
+	return !s.Reserved && (s.Const == nil || *s.Const)
+}
+
+// ConstName is the Go name of the subtype constant
+func (s *Subtype) ConstName(t *Type) string {
+	prefix := t.ConstPrefix
+	if prefix == "" {
+		prefix = "Type"
+	}
+	return prefix + s.Go
+}
+
+// FieldName is the Go name of the registered field
+func (s *Subtype) FieldName(t *Type) string {
+	return "Field" + strings.TrimPrefix(s.ConstName(t), "Type")
+}
+
+// FirmwareName is the name of the subtype in the firmware, without the prefix
+func (s *Subtype) FirmwareName(t *Type) string {
+	switch {
+	case s.Firmware != "":
+		return s.Firmware
+	case s.Name == "":
+		return t.Name
+	default:
+		return t.Name + "_" + s.Name
+	}
+}
+
+// Key is the absolute subtype
+func (s *Subtype) Key(t *Type) uint16 {
+	return t.value + uint16(s.Value)
+}
+
+func (spec *Spec) validate() error {
+	if len(spec.Types) == 0 {
+		return fmt.Errorf("spec has no message types")
+	}
+	for name, values := range spec.Enums {
+		seen := map[string]bool{}
+		for _, v := range values {
+			if v.Name == "" || seen[v.Name] {
+				return fmt.Errorf("enum %s: empty or duplicate name %q", name, v.Name)
+			}
+			seen[v.Name] = true
+		}
+	}
+
+	idents := map[string]string{}    // Go identifier -> what declares it
+	firmware := map[string]string{}  // Firmware name -> what declares it
+	types := map[string]*Type{}      // By firmware name
+	typeValues := map[uint16]*Type{} // By message type
+	keys := map[uint16]string{}      // Absolute subtype -> what declares it
+	ident := func(name, owner string) error {
+		if other, ok := idents[name]; ok {
+			return fmt.Errorf("%s and %s both declare %s", other, owner, name)
+		}
+		idents[name] = owner
+		return nil
+	}
+	fwName := func(name, owner string) error {
+		if other, ok := firmware[name]; ok {
+			return fmt.Errorf("%s and %s share the firmware name %s", other, owner, name)
+		}
+		firmware[name] = owner
+		return nil
+	}
+
+	for _, t := range spec.Types {
+		if t.Name == "" || t.Go == "" {
+			return fmt.Errorf("message type %q needs a name and a Go name", t.Name)
+		}
+		switch {
+		case t.Value != nil && t.Parent != "":
+			return fmt.Errorf("%s: value and parent are exclusive", t.Name)
+		case t.Value != nil:
+			if *t.Value < 0 || *t.Value > 0xFFFF {
+				return fmt.Errorf("%s: value 0x%x out of range", t.Name, *t.Value)
+			}
+			t.value = uint16(*t.Value)
+		case t.Parent != "":
+			parent, ok := types[t.Parent]
+			if !ok {
+				return fmt.Errorf("%s: parent %s is not declared before it", t.Name, t.Parent)
+			}
+			v := int(parent.value) + t.Offset
+			if t.Offset <= 0 || v > 0xFFFF {
+				return fmt.Errorf("%s: offset %d out of range", t.Name, t.Offset)
+			}
+			t.value = uint16(v)
+		default:
+			return fmt.Errorf("%s: needs a value or a parent", t.Name)
+		}
+		if err := fwName(t.Name, "message type "+t.Name); err != nil {
+			return err
+		}
+		if other, ok := typeValues[t.value]; ok {
+			return fmt.Errorf("message types %s and %s are both 0x%04x", other.Name, t.Name, t.value)
+		}
+		if err := ident("Type"+t.Go, "message type "+t.Name); err != nil {
+			return err
+		}
+		types[t.Name] = t
+		typeValues[t.value] = t
+
+		values := map[int]bool{}
+		fields := map[string]bool{}
+		for _, s := range t.Subtypes {
+			owner := fmt.Sprintf("%s subtype %d", t.Name, s.Value)
+			if s.Value < 0 || int(t.value)+s.Value > 0xFFFF {
+				return fmt.Errorf("%s: out of range", owner)
+			}
+			if values[s.Value] {
+				return fmt.Errorf("%s: declared twice", owner)
+			}
+			values[s.Value] = true
+			if other, ok := keys[s.Key(t)]; ok {
+				return fmt.Errorf("%s and %s share the absolute subtype 0x%04x", other, owner, s.Key(t))
+			}
+			keys[s.Key(t)] = owner
+			if s.Name != "" || s.Firmware != "" {
+				if err := fwName(s.FirmwareName(t), owner); err != nil {
+					return err
+				}
+			}
+
+			if s.Reserved {
+				if s.Go != "" || s.Field != "" {
+					return fmt.Errorf("%s: reserved subtypes have no Go name or field", owner)
+				}
+				continue
+			}
+			if s.Go == "" {
+				return fmt.Errorf("%s: needs a Go name", owner)
+			}
+			if s.HasConst() {
+				if err := ident(s.ConstName(t), owner); err != nil {
+					return err
+				}
+			}
+			if s.AnySubType && s.Value != 0 {
+				return fmt.Errorf("%s: any_subtype needs subtype 0", owner)
+			}
+			if s.Field == "" {
+				if s.Kind != "" || s.Direction != "" || len(s.Redis) > 0 || s.Enum != "" || s.Fallback != "" || s.AnySubType {
+					return fmt.Errorf("%s: field properties without a field name", owner)
+				}
+				continue
+			}
+			if fields[s.Field] {
+				return fmt.Errorf("%s: field %q declared twice", owner, s.Field)
+			}
+			fields[s.Field] = true
+			if err := ident(s.FieldName(t), owner); err != nil {
+				return err
+			}
+			if _, ok := kinds[s.Kind]; !ok {
+				return fmt.Errorf("%s: unknown kind %q", owner, s.Kind)
+			}
+			if _, ok := directions[s.Direction]; !ok {
+				return fmt.Errorf("%s: unknown direction %q", owner, s.Direction)
+			}
+			if s.Wire != "" {
+				if _, ok := wires[s.Wire]; !ok {
+					return fmt.Errorf("%s: unknown wire type %q", owner, s.Wire)
+				}
+				if s.Kind != "int" && s.Kind != "bool" {
+					return fmt.Errorf("%s: wire type of a %s field", owner, s.Kind)
+				}
+			}
+			if s.Redis != nil && len(s.Redis) != 2 {
+				return fmt.Errorf("%s: redis must be [hash, field]", owner)
+			}
+			if s.Enum != "" {
+				if _, ok := spec.Enums[s.Enum]; !ok {
+					return fmt.Errorf("%s: unknown enum %q", owner, s.Enum)
+				}
+				if s.Kind != "int" {
+					return fmt.Errorf("%s: enum of a %s field", owner, s.Kind)
+				}
+			}
+			if s.Fallback != "" {
+				if s.Enum == "" {
+					return fmt.Errorf("%s: enum_fallback without an enum", owner)
+				}
+				if !validFallback(s.Fallback) {
+					return fmt.Errorf("%s: enum_fallback must contain one %%d and no other verb", owner)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validFallback tells whether an enum fallback formats exactly one integer
+func validFallback(format string) bool {
+	return strings.Count(format, "%d") == 1 && strings.Count(strings.ReplaceAll(format, "%%", ""), "%") == 1
+}
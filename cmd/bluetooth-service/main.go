@@ -8,6 +8,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/librescoot/bluetooth-service/pkg/ble"
 	"github.com/librescoot/bluetooth-service/pkg/redis"
 	"github.com/librescoot/bluetooth-service/pkg/service"
 	"github.com/librescoot/bluetooth-service/pkg/usock"
@@ -17,9 +18,23 @@ import (
 var (
 	serialDevice = flag.String("serial", "/dev/ttymxc1", "Serial device path")
 	baudRate     = flag.Int("baud", 115200, "Serial baud rate")
+	tcpAddr      = flag.String("tcp", "", "Reach the nRF52 over TCP (host:port) instead of the serial device")
+	ackTimeout   = flag.Duration("ack-timeout", usock.DefaultAckConfig.Timeout, "Time to wait for the nRF52 to acknowledge a command")
+	ackRetries   = flag.Int("ack-retries", usock.DefaultAckConfig.Retries, "Number of retransmissions of an unacknowledged command")
+	rxQueueSize  = flag.Int("rx-queue-size", usock.DefaultDispatchConfig.QueueSize, "Number of received frames buffered for handling")
+	rxOverflow   = flag.String("rx-overflow", usock.DefaultDispatchConfig.Overflow.String(), "What to do when the receive queue is full: drop-oldest, block or drop-newest")
+	txGap        = flag.Duration("tx-gap", usock.DefaultWriteConfig.MinGap, "Minimum time between two frames sent to the nRF52")
+	txTimeout    = flag.Duration("tx-timeout", usock.DefaultWriteConfig.Timeout, "Maximum time to write one frame to the nRF52 (0 for no limit)")
+	batchDelay   = flag.Duration("batch-delay", service.DefaultBatchConfig.Delay, "How long an outbound value waits to be sent in one frame with others of its message type (0 disables batching)")
+	mappingFile  = flag.String("mapping", "", "YAML file overriding the Redis hashes, fields and enums of the nRF52 fields, see README")
+	syncFields   = flag.String("sync", "", "Override the sync direction of fields stored in Redis: TYPE[/field]=inbound|outbound|both, comma separated")
+	captureFile  = flag.String("capture", "", "Record every received and sent USOCK frame to this file (JSONL)")
+	reconcile    = flag.Duration("reconcile-interval", 5*time.Minute, "How often to push the full state to the nRF52, including values not sent because unchanged (0 disables)")
+	statsPeriod  = flag.Duration("link-stats-interval", 30*time.Second, "How often to publish link statistics to the ble:link hash (0 disables)")
 	redisAddr    = flag.String("redis-addr", "localhost:6379", "Redis server address")
 	redisPass    = flag.String("redis-pass", "", "Redis password")
 	redisDB      = flag.Int("redis-db", 0, "Redis database number")
+	redisTrigger = flag.String("redis-trigger", service.DefaultSubscribeConfig.Trigger.String(), "How changed Redis fields are noticed: publish (messages on the hash's channel) or keyspace (keyspace notifications, see README)")
 )
 
 // Redis keys
@@ -52,25 +67,77 @@ func main() {
 	log.Printf("Baud rate: %d", *baudRate)
 	log.Printf("Redis address: %s", *redisAddr)
 
-	redisClient, err := redis.New(*redisAddr, *redisPass, *redisDB)
+	overflow, err := usock.ParseOverflowPolicy(*rxOverflow)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		log.Fatalf("Invalid --rx-overflow: %v", err)
 	}
+	if *mappingFile != "" {
+		if err := ble.LoadMapping(*mappingFile); err != nil {
+			log.Fatalf("Invalid --mapping: %v", err)
+		}
+		log.Printf("Loaded Redis mapping from %s", *mappingFile)
+	}
+	syncDirections, err := service.ParseSyncDirections(*syncFields)
+	if err != nil {
+		log.Fatalf("Invalid --sync: %v", err)
+	}
+	trigger, err := service.ParseTrigger(*redisTrigger)
+	if err != nil {
+		log.Fatalf("Invalid --redis-trigger: %v", err)
+	}
+
+	// Redis is waited for; later connection losses are recovered by the
+	// subscription, see SubscribeToRedisChannels
+	redisClient := redis.Connect(*redisAddr, *redisPass, *redisDB)
 	defer redisClient.Close()
 	log.Printf("Connected to Redis")
 
 	svc := service.New(redisClient)
+	svc.SetBatchConfig(service.BatchConfig{Delay: *batchDelay})
+	svc.SetSyncConfig(service.SyncConfig{Directions: syncDirections})
+	svc.SetSubscribeConfig(service.SubscribeConfig{Trigger: trigger})
 
 	usockHandler := func(payload *usock.Payload) {
 		svc.HandleUSockMessage(payload.ID, payload)
 	}
-	sock, err := usock.New(*serialDevice, *baudRate, usockHandler)
-	if err != nil {
-		log.Fatalf("Failed to connect to nRF52 via USOCK: %v", err)
+
+	// The link is (re)opened in the background; until it is up the service
+	// keeps running and reports "link: down" in the ble hash.
+	dial := usock.SerialDialer(*serialDevice, *baudRate)
+	if *tcpAddr != "" {
+		dial = usock.TCPDialer(*tcpAddr, 5*time.Second)
+	}
+	var capture *usock.CaptureWriter
+	if *captureFile != "" {
+		f, err := os.OpenFile(*captureFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("Failed to open capture file: %v", err)
+		}
+		defer f.Close()
+		capture = usock.NewCaptureWriter(f)
+		log.Printf("Capturing USOCK frames to %s", *captureFile)
 	}
+	svc.HandleLinkState(false)
+	sock := usock.NewWithDialer(dial, usockHandler, svc.HandleLinkState)
+	sock.SetCapture(capture)
+	sock.SetAckConfig(usock.AckConfig{Timeout: *ackTimeout, Retries: *ackRetries})
+	sock.SetDispatchConfig(usock.DispatchConfig{QueueSize: *rxQueueSize, Overflow: overflow})
+	sock.SetWriteConfig(usock.WriteConfig{MinGap: *txGap, Timeout: *txTimeout})
 	svc.SetUSock(sock)
 	defer sock.Close()
-	log.Printf("Connected to nRF52 via USOCK")
+
+	// Initialize the nRF52 and push the full state whenever the link comes up
+	go svc.WatchLink()
+
+	// Publish link quality counters for fleet monitoring
+	if *statsPeriod > 0 {
+		go svc.PublishLinkStats(*statsPeriod)
+	}
+
+	// Re-send values the nRF52 may have missed despite the change cache
+	if *reconcile > 0 {
+		go svc.WatchReconcile(*reconcile)
+	}
 
 	// Start the command watcher goroutine
 	go svc.WatchRedisCommands()
@@ -80,61 +147,6 @@ func main() {
 
 	log.Printf("Subscribed to Redis channels")
 
-	log.Printf("Initializing communication with nRF52...")
-	if err := svc.InitializeNRF52(); err != nil {
-		// Log the error but continue, initialization might partially succeed
-		log.Printf("Error during nRF52 initialization sequence: %v", err)
-	} else {
-		log.Printf("nRF52 initialization sequence sent successfully.")
-	}
-
-	// Wait a bit for nRF52 to process initialization commands before sending state updates
-	log.Printf("Waiting briefly before sending initial state updates...")
-	time.Sleep(200 * time.Millisecond)
-
-	log.Printf("Sending initial state updates...")
-
-	// Update vehicle state
-	if err := svc.UpdateVehicleState(); err != nil {
-		log.Printf("Warning during initial update: %v", err)
-	}
-	// Update seatbox lock state
-	if err := svc.UpdateSeatboxLock(); err != nil {
-		log.Printf("Warning during initial update: %v", err)
-	}
-	// Update handlebar lock state
-	if err := svc.UpdateHandlebarLock(); err != nil {
-		log.Printf("Warning during initial update: %v", err)
-	}
-	// Update mileage
-	if err := svc.UpdateMileage(); err != nil {
-		log.Printf("Warning during initial update: %v", err)
-	}
-	// Update firmware version
-	if err := svc.UpdateFirmwareVersion(); err != nil {
-		log.Printf("Warning during initial update: %v", err)
-	}
-	// Update battery states for both slots
-	for slot := 1; slot <= 2; slot++ {
-		if err := svc.UpdateBatteryPresentStatus(slot); err != nil {
-			log.Printf("Warning during initial update (Slot %d): %v", slot, err)
-		}
-		if err := svc.UpdateBatteryActiveStatus(slot); err != nil {
-			log.Printf("Warning during initial update (Slot %d): %v", slot, err)
-		}
-		if err := svc.UpdateBatteryCycleCount(slot); err != nil {
-			log.Printf("Warning during initial update (Slot %d): %v", slot, err)
-		}
-		if err := svc.UpdateBatteryRemainingCharge(slot); err != nil {
-			log.Printf("Warning during initial update (Slot %d): %v", slot, err)
-		}
-	}
-	// Update power management state
-	if err := svc.UpdatePowerManagementState(); err != nil {
-		log.Printf("Warning during initial update: %v", err)
-	}
-	log.Printf("Initial state updates sent.")
-
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
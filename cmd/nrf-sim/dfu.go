@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/dfu"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// Simulated bootloader limits
+const (
+	bootloaderMTU        = 131
+	bootloaderMaxCommand = 256
+	bootloaderMaxData    = 4096
+
+	// The bootloader gives up when the host goes quiet
+	bootloaderIdleTimeout = 30 * time.Second
+	// The transfer counts as complete when no request follows the execution
+	// of a firmware object within this time
+	bootloaderDoneTimeout = 500 * time.Millisecond
+)
+
+// bootloader simulates the nRF5 SDK secure serial DFU bootloader
+type bootloader struct {
+	conn   dfu.Conn
+	reader *dfu.SLIPReader
+
+	current  byte            // Object type of the last Create
+	objects  map[byte][]byte // Received bytes per object type
+	executed map[byte]int    // Executed bytes per object type
+}
+
+// enterBootloader hands the PTY over to the simulated bootloader. The new
+// firmware reports the fw_version of the received init packet.
+func (s *simulator) enterBootloader() {
+	log.Printf("Entering DFU bootloader")
+	err := s.sock.Hijack(func(t usock.Transport) error {
+		conn, ok := t.(dfu.Conn)
+		if !ok {
+			return fmt.Errorf("transport %T does not support DFU", t)
+		}
+		b := &bootloader{
+			conn:     conn,
+			reader:   dfu.NewSLIPReader(bufio.NewReader(conn)),
+			objects:  make(map[byte][]byte),
+			executed: make(map[byte]int),
+		}
+		if err := b.run(); err != nil {
+			return err
+		}
+
+		img := dfu.Image{InitPacket: b.objects[dfu.ObjCommand]}
+		version, err := img.FirmwareVersion()
+		if err != nil {
+			return fmt.Errorf("received firmware but no usable init packet: %v", err)
+		}
+		s.setVersion(strconv.FormatUint(uint64(version), 10))
+		log.Printf("DFU complete: %d bytes of firmware, now running version %d", b.executed[dfu.ObjData], version)
+		return nil
+	})
+	if err != nil {
+		log.Printf("DFU failed, resuming old firmware: %v", err)
+	}
+}
+
+// run answers requests until the firmware has been executed
+func (b *bootloader) run() error {
+	for {
+		timeout := bootloaderIdleTimeout
+		if b.executed[dfu.ObjData] > 0 {
+			timeout = bootloaderDoneTimeout
+		}
+		b.conn.SetReadDeadline(time.Now().Add(timeout))
+		packet, err := b.reader.ReadPacket()
+		if err != nil {
+			if b.executed[dfu.ObjData] > 0 {
+				b.conn.SetReadDeadline(time.Time{})
+				return nil
+			}
+			return fmt.Errorf("bootloader read failed: %v", err)
+		}
+
+		if packet[0] == dfu.OpWrite {
+			// Writes are not answered without packet receipt notifications
+			b.objects[b.current] = append(b.objects[b.current], packet[1:]...)
+			continue
+		}
+
+		resp, result := b.handle(packet[0], packet[1:])
+		out := append([]byte{dfu.OpResponse, packet[0], result}, resp...)
+		if err := dfu.WritePacket(b.conn, out); err != nil {
+			return fmt.Errorf("bootloader write failed: %v", err)
+		}
+	}
+}
+
+// handle executes a request and returns its response payload and result
+func (b *bootloader) handle(op byte, params []byte) ([]byte, byte) {
+	switch op {
+	case dfu.OpPing:
+		return params[:min(1, len(params))], dfu.ResSuccess
+
+	case dfu.OpSetPRN:
+		return nil, dfu.ResSuccess
+
+	case dfu.OpGetMTU:
+		return binary.LittleEndian.AppendUint16(nil, bootloaderMTU), dfu.ResSuccess
+
+	case dfu.OpSelect:
+		if len(params) < 1 {
+			return nil, dfu.ResInvalidParameter
+		}
+		maxSize := bootloaderMaxData
+		if params[0] == dfu.ObjCommand {
+			maxSize = bootloaderMaxCommand
+		}
+		b.current = params[0]
+		resp := binary.LittleEndian.AppendUint32(nil, uint32(maxSize))
+		return append(resp, b.checksum()...), dfu.ResSuccess
+
+	case dfu.OpCreate:
+		if len(params) < 5 {
+			return nil, dfu.ResInvalidParameter
+		}
+		b.current = params[0]
+		if b.current == dfu.ObjCommand {
+			b.objects[dfu.ObjCommand] = nil
+			b.executed[dfu.ObjCommand] = 0
+		}
+		// Drop the unexecuted part of a previous object
+		b.objects[b.current] = b.objects[b.current][:b.executed[b.current]]
+		return nil, dfu.ResSuccess
+
+	case dfu.OpCalcCRC:
+		return b.checksum(), dfu.ResSuccess
+
+	case dfu.OpExecute:
+		b.executed[b.current] = len(b.objects[b.current])
+		return nil, dfu.ResSuccess
+
+	default:
+		return nil, dfu.ResOpNotSupported
+	}
+}
+
+// checksum returns the offset and CRC32 of the current object type
+func (b *bootloader) checksum() []byte {
+	data := b.objects[b.current]
+	resp := binary.LittleEndian.AppendUint32(nil, uint32(len(data)))
+	return binary.LittleEndian.AppendUint32(resp, crc32.ChecksumIEEE(data))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/dfu"
+)
+
+// startBootloader runs the simulated bootloader on one end of a pipe and
+// returns a client for the other end and the bootloader's result
+func startBootloader(t *testing.T) (*dfu.Client, *bootloader, chan error) {
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	bl := &bootloader{
+		conn:     b,
+		reader:   dfu.NewSLIPReader(bufio.NewReader(b)),
+		objects:  make(map[byte][]byte),
+		executed: make(map[byte]int),
+	}
+	done := make(chan error, 1)
+	go func() { done <- bl.run() }()
+	return dfu.NewClient(a), bl, done
+}
+
+func TestDFUUpdate(t *testing.T) {
+	// Several data objects, with bytes the SLIP framing has to escape
+	firmware := bytes.Repeat([]byte{0x00, 0xc0, 0x11, 0xdb, 0x22}, 2*bootloaderMaxData/5+100)
+	initPacket := []byte{0x0a, 0x06, 0x12, 0x04, 0x08, 0x2a, 0xc0, 0xdb} // fw_version 42
+	pkg := &dfu.Package{Images: []dfu.Image{{Name: "application", InitPacket: initPacket, Firmware: firmware}}}
+
+	client, bl, done := startBootloader(t)
+	var progress []int
+	client.Progress = func(sent, total int) {
+		if total != pkg.Size() {
+			t.Errorf("got progress total %d, want %d", total, pkg.Size())
+		}
+		progress = append(progress, sent)
+	}
+	if err := client.WaitForBootloader(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Update(pkg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bootloader did not finish after the firmware was executed")
+	}
+
+	if !bytes.Equal(bl.objects[dfu.ObjCommand], initPacket) || bl.executed[dfu.ObjCommand] != len(initPacket) {
+		t.Errorf("got init packet % x, %d bytes executed", bl.objects[dfu.ObjCommand], bl.executed[dfu.ObjCommand])
+	}
+	if !bytes.Equal(bl.objects[dfu.ObjData], firmware) || bl.executed[dfu.ObjData] != len(firmware) {
+		t.Errorf("got %d bytes of firmware, %d executed, want %d", len(bl.objects[dfu.ObjData]), bl.executed[dfu.ObjData], len(firmware))
+	}
+	if len(progress) == 0 || progress[len(progress)-1] != pkg.Size() {
+		t.Errorf("got progress %v, want it to end at %d", progress, pkg.Size())
+	}
+}
+
+func TestDFUInitPacketTooLarge(t *testing.T) {
+	pkg := &dfu.Package{Images: []dfu.Image{{
+		Name:       "application",
+		InitPacket: make([]byte, bootloaderMaxCommand+1),
+		Firmware:   []byte{0x01},
+	}}}
+	client, bl, _ := startBootloader(t)
+	if err := client.Update(pkg); err == nil {
+		t.Fatal("init packet above the maximum object size accepted")
+	}
+	if len(bl.objects[dfu.ObjCommand]) != 0 {
+		t.Errorf("init packet written after it was found too large")
+	}
+}
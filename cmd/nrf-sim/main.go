@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// Configuration flags
+var (
+	fwVersion  = flag.String("version", "nrf-sim-1.0.0", "Firmware version string reported at 0xA001")
+	macAddress = flag.String("mac", "C0:FF:EE:00:00:01", "MAC address reported at 0xA081")
+	linkPath   = flag.String("link", "", "Optional symlink to create pointing at the PTY slave device")
+	capsFlag   = flag.String("capabilities", "", "Message types answered in the capability handshake, comma separated (default: all known), or \"legacy\" to not answer")
+)
+
+// simulator answers the service like the nRF52 firmware would
+type simulator struct {
+	sock *usock.USOCK
+
+	mu      sync.Mutex
+	version string // Changed by a firmware update
+
+	caps *ble.Capabilities // Answer to the capability handshake, nil for legacy firmware
+}
+
+func main() {
+	flag.Parse()
+
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+
+	pty, err := usock.OpenPTY()
+	if err != nil {
+		log.Fatalf("Failed to create pseudo-terminal: %v", err)
+	}
+
+	if *linkPath != "" {
+		os.Remove(*linkPath)
+		if err := os.Symlink(pty.Name(), *linkPath); err != nil {
+			log.Fatalf("Failed to create symlink %s: %v", *linkPath, err)
+		}
+		defer os.Remove(*linkPath)
+	}
+
+	caps, err := parseCapabilities(*capsFlag)
+	if err != nil {
+		log.Fatalf("Invalid -capabilities: %v", err)
+	}
+	sim := &simulator{version: *fwVersion, caps: caps}
+	sim.sock = usock.NewWithTransport(pty, sim.handleFrame)
+	// The firmware answers without delay
+	sim.sock.SetWriteConfig(usock.WriteConfig{Timeout: usock.DefaultWriteConfig.Timeout})
+	defer sim.sock.Close()
+
+	log.Printf("Simulated nRF52 listening on %s", pty.Name())
+	log.Printf("Run: bluetooth-service --serial %s", pty.Name())
+	printHelp()
+
+	go sim.console()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+	log.Printf("Shutting down...")
+}
+
+func printHelp() {
+	fmt.Println(`Commands:
+  reset [reason] [count]         send nRF reset info (0xA021)
+  pin <code>                     send pairing PIN for display (0xA082)
+  pin-remove                     send pairing PIN removal (0xA083)
+  cb-battery [subtype value]     send cb-battery info (default: a healthy snapshot)
+  aux-battery [subtype value]    send aux-battery info (default: a full battery)
+  event [string]                 send an event (default: "scooter:seatbox open")
+  raw <frame-id> <hex>           send a raw payload, e.g. to test malformed or batched frames
+  help                           show this help`)
+}
+
+// console reads commands from stdin and emits the corresponding messages
+func (s *simulator) console() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if err := s.runCommand(fields[0], fields[1:]); err != nil {
+			log.Printf("Command '%s' failed: %v", fields[0], err)
+		}
+	}
+}
+
+func (s *simulator) runCommand(cmd string, args []string) error {
+	switch cmd {
+	case "reset":
+		reason, count := 1, 1
+		if len(args) > 0 {
+			v, err := strconv.ParseInt(args[0], 0, 32)
+			if err != nil {
+				return fmt.Errorf("invalid reason: %v", err)
+			}
+			reason = int(v)
+		}
+		if len(args) > 1 {
+			v, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid count: %v", err)
+			}
+			count = v
+		}
+		return s.send(ble.TypeBLEDebug, map[uint16]interface{}{
+			ble.FieldBLEDebugResetInfo.Key(): []int{reason, count},
+		})
+
+	case "pin":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: pin <code>")
+		}
+		return s.send(ble.TypeBLEParam, map[uint16]interface{}{
+			uint16(ble.TypeBLEPairingPinDisplay): args[0],
+		})
+
+	case "pin-remove":
+		return s.send(ble.TypeBLEParam, map[uint16]interface{}{
+			uint16(ble.TypeBLEPairingPinRemove): 1,
+		})
+
+	case "cb-battery":
+		values, err := subTypeValues(ble.TypeBatteryInfo, args, map[ble.SubType]interface{}{
+			ble.TypeBatteryInfoCharge:           87,
+			ble.TypeBatteryInfoCurrent:          120,
+			ble.TypeBatteryInfoCellVoltage:      3950,
+			ble.TypeBatteryInfoTemp:             24,
+			ble.TypeBatteryInfoCycleCount:       12,
+			ble.TypeBatteryInfoStatus:           0,
+			ble.TypeBatteryInfoProtectionStatus: 0,
+			ble.TypeBatteryInfoBattStatus:       0,
+			ble.TypeBatteryInfoSOH:              98,
+			ble.TypeBatteryInfoPartNo:           5,
+			ble.TypeBatteryInfoPresent:          1,
+			ble.TypeBatteryInfoChargeStatus:     1,
+		})
+		if err != nil {
+			return err
+		}
+		return s.send(ble.TypeBatteryInfo, values)
+
+	case "aux-battery":
+		values, err := subTypeValues(ble.TypeAuxBattery, args, map[ble.SubType]interface{}{
+			ble.TypeAuxBatteryVoltage:       12600,
+			ble.TypeAuxBatteryCharge:        100,
+			ble.TypeAuxBatteryChargerStatus: "float-charge",
+		})
+		if err != nil {
+			return err
+		}
+		return s.send(ble.TypeAuxBattery, values)
+
+	case "event":
+		event := "scooter:seatbox open"
+		if len(args) > 0 {
+			event = strings.Join(args, " ")
+		}
+		return s.send(0x0000, map[uint16]interface{}{0x0000: event})
+
+	case "raw":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: raw <frame-id> <hex>")
+		}
+		frameID, err := strconv.ParseUint(args[0], 0, 8)
+		if err != nil {
+			return fmt.Errorf("invalid frame ID: %v", err)
+		}
+		data, err := hex.DecodeString(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid payload: %v", err)
+		}
+		return s.sock.WriteChunked(context.Background(), byte(frameID), data)
+
+	case "help":
+		printHelp()
+		return nil
+
+	default:
+		return fmt.Errorf("unknown command, type 'help' for a list")
+	}
+}
+
+// subTypeValues builds an absolute-subtype value map either from a single
+// "subtype value" argument pair or from the given defaults
+func subTypeValues(msgType ble.MessageType, args []string, defaults map[ble.SubType]interface{}) (map[uint16]interface{}, error) {
+	values := make(map[uint16]interface{})
+	if len(args) == 0 {
+		for subType, value := range defaults {
+			values[uint16(msgType)+uint16(subType)] = value
+		}
+		return values, nil
+	}
+	if len(args) != 2 {
+		return nil, fmt.Errorf("expected <subtype> <value>")
+	}
+
+	subType, err := strconv.ParseUint(args[0], 0, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subtype: %v", err)
+	}
+	var value interface{} = args[1]
+	if n, err := strconv.ParseInt(args[1], 0, 64); err == nil {
+		value = n
+	}
+	values[uint16(msgType)+uint16(subType)] = value
+	return values, nil
+}
+
+// send encodes values as {type: {absoluteSubtype: value}} and writes them
+// with the frame ID the firmware uses for that message type, chunked if they
+// do not fit into a single frame
+func (s *simulator) send(msgType ble.MessageType, values map[uint16]interface{}) error {
+	data, err := cbor.Marshal(map[uint16]map[uint16]interface{}{
+		uint16(msgType): values,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal CBOR: %w", err)
+	}
+	return s.sock.WriteChunked(context.Background(), byte(msgType&0xFF), data)
+}
+
+// parseCapabilities returns the capabilities of the -capabilities flag
+func parseCapabilities(value string) (*ble.Capabilities, error) {
+	switch value {
+	case "legacy":
+		return nil, nil
+	case "":
+		caps := ble.ServiceCapabilities()
+		return &caps, nil
+	}
+	caps := ble.Capabilities{Version: ble.ProtocolVersion, Types: map[ble.MessageType]bool{ble.TypeBLEVersion: true}}
+	for _, name := range strings.Split(value, ",") {
+		t, ok := ble.ParseMessageType(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown message type %q", name)
+		}
+		caps.Types[t] = true
+	}
+	return &caps, nil
+}
+
+func (s *simulator) getVersion() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version
+}
+
+func (s *simulator) setVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+}
+
+// sendAck sends the empty-map acknowledgment that echoes the frame ID
+func (s *simulator) sendAck(frameID byte) error {
+	return s.sock.Send(usock.PriorityControl, frameID, []byte{0xa1, 0x18, frameID, 0xa0})
+}
+
+// handleFrame acknowledges every frame from the service, answers the
+// requests of the initialization sequence and enters the bootloader on request
+func (s *simulator) handleFrame(payload *usock.Payload) {
+	var msg map[uint16]map[uint16]interface{}
+	if err := cbor.Unmarshal(payload.Data, &msg); err != nil {
+		log.Printf("Failed to decode CBOR from service: %v", err)
+		return
+	}
+
+	if err := s.sendAck(payload.ID); err != nil {
+		log.Printf("Failed to send ACK for frame ID 0x%02x: %v", payload.ID, err)
+	}
+
+	for msgType, params := range msg {
+		for absSubType, value := range params {
+			log.Printf("Service sent type 0x%04x subtype 0x%04x: %v", msgType, absSubType, value)
+
+			var err error
+			switch absSubType {
+			case uint16(ble.TypeBLEVersion) + uint16(ble.TypeBLEVersionString):
+				err = s.send(ble.TypeBLEVersion, map[uint16]interface{}{absSubType: s.getVersion()})
+			case uint16(ble.TypeBLEVersion) + uint16(ble.TypeBLEVersionCapabilities):
+				if s.caps != nil {
+					err = s.send(ble.TypeBLEVersion, map[uint16]interface{}{absSubType: s.caps.Encode()})
+				}
+			case uint16(ble.TypeBLEParam) + uint16(ble.TypeBLEParamMACAddress):
+				err = s.send(ble.TypeBLEParam, map[uint16]interface{}{absSubType: *macAddress})
+			case uint16(ble.TypeBLECommand) + uint16(ble.BLECommandEnterBootloader):
+				// Hijack waits for this read loop callback to return
+				go s.enterBootloader()
+			}
+			if err != nil {
+				log.Printf("Failed to answer subtype 0x%04x: %v", absSubType, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// Markers of the service log lines carrying frames
+const (
+	markTXFrame   = "TX Complete Frame: "
+	markRXFrame   = "RX Frame: "
+	markRXPayload = "RX Payload: "
+	markSending   = "Sending message: "
+)
+
+// decoder prints the frames found in its input lines
+type decoder struct {
+	out     io.Writer
+	frameID int // Frame ID of bare payloads, -1 if unknown
+
+	// The last "RX Frame:" log line, checked against the next "RX Payload:"
+	rx      *usock.Frame
+	invalid int // Frames that failed validation
+	printed int // Frames printed
+}
+
+func newDecoder(out io.Writer, frameID int) *decoder {
+	return &decoder{out: out, frameID: frameID}
+}
+
+// read decodes every line of r, skipping lines without frames
+func (d *decoder) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		d.line(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// line decodes one line of input: a service log line, a capture line or
+// hex. It returns false for lines carrying nothing it understands.
+func (d *decoder) line(line string) bool {
+	switch {
+	case strings.Contains(line, markTXFrame):
+		prefix, data := split(line, markTXFrame)
+		d.stream(prefix+"TX ", data)
+	case strings.Contains(line, markRXFrame):
+		_, rest := split(line, markRXFrame)
+		var f usock.Frame
+		var length int
+		if _, err := fmt.Sscanf(rest, "ID=0x%x, Len=%d, HeaderCRC=0x%x, PayloadCRC=0x%x", &f.ID, &length, &f.HeaderCRC, &f.PayloadCRC); err != nil {
+			d.fail(line, "unreadable RX Frame line: %v", err)
+			return true
+		}
+		f.PayloadLen = uint16(length)
+		d.rx = &f
+	case strings.Contains(line, markRXPayload):
+		prefix, data := split(line, markRXPayload)
+		d.rxPayload(prefix, data)
+	case strings.Contains(line, markSending):
+		prefix, rest := split(line, markSending)
+		var id int
+		var data string
+		if _, err := fmt.Sscanf(rest, "Frame ID=0x%x, CBOR Data=%s", &id, &data); err != nil {
+			d.fail(line, "unreadable Sending message line: %v", err)
+			return true
+		}
+		d.payload(prefix+"TX ", id, data, "payload only, no CRC")
+	case strings.Contains(line, `"dir"`) && strings.Contains(line, "{"):
+		var f usock.CapturedFrame
+		start := strings.IndexByte(line, '{')
+		if err := json.Unmarshal([]byte(line[start:]), &f); err != nil {
+			d.fail(line, "unreadable capture line: %v", err)
+			return true
+		}
+		prefix := f.Time.Format("2006-01-02 15:04:05.000000 ") + strings.ToUpper(string(f.Dir)) + " "
+		if f.Err != "" {
+			d.fail(prefix+f.Data, "rejected by the parser: %s", f.Err)
+			return true
+		}
+		d.payload(prefix, int(f.ID), f.Data, "from capture, CRC checked on capture")
+	default:
+		data, err := parseHex(line)
+		if err != nil || len(data) == 0 {
+			return false
+		}
+		if len(data) >= 2 && data[0] == usock.SyncByte1 && data[1] == usock.SyncByte2 {
+			d.stream("", line)
+		} else {
+			d.payload("", d.frameID, line, "payload only, no CRC")
+		}
+	}
+	return true
+}
+
+// split returns the text before a marker, e.g. the log timestamp, and the rest
+func split(line, marker string) (string, string) {
+	i := strings.Index(line, marker)
+	return strings.TrimSpace(line[:i]) + " ", strings.TrimSpace(line[i+len(marker):])
+}
+
+// parseHex decodes hex with optional 0x prefixes and whitespace, colon or
+// comma separators
+func parseHex(s string) ([]byte, error) {
+	s = strings.ReplaceAll(s, "0x", "")
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', ':', ',', '\r':
+			return -1
+		}
+		return r
+	}, s)
+	return hex.DecodeString(s)
+}
+
+// stream validates the framing and CRCs of hex encoded frames and prints them
+func (d *decoder) stream(prefix, text string) {
+	data, err := parseHex(text)
+	if err != nil {
+		d.fail(prefix+text, "invalid hex: %v", err)
+		return
+	}
+	dec := usock.NewDecoder()
+	skipped := false
+	dec.OnError = func(err *usock.DecodeError) {
+		d.fail(prefix+text, "invalid frame 0x%02x: %v", err.FrameID, err)
+	}
+	dec.OnResync = func() { skipped = true }
+	frames := dec.Feed(data)
+	if skipped {
+		fmt.Fprintf(d.out, "%sskipped bytes outside of valid frames\n", prefix)
+	}
+	for _, f := range frames {
+		d.print(prefix, int(f.ID), f.Payload, "CRC ok")
+	}
+	if n := dec.Buffered(); n > 0 {
+		d.fail(prefix+text, "%d trailing bytes of a truncated frame", n)
+	}
+}
+
+// rxPayload prints a payload logged after its "RX Frame:" line, checking it
+// against the length and CRC logged there
+func (d *decoder) rxPayload(prefix, text string) {
+	rx := d.rx
+	d.rx = nil
+	if rx == nil {
+		d.payload(prefix+"RX ", d.frameID, text, "no RX Frame line, CRC not checked")
+		return
+	}
+	data, err := parseHex(text)
+	if err != nil {
+		d.fail(prefix+text, "invalid hex: %v", err)
+		return
+	}
+	if len(data) != int(rx.PayloadLen) {
+		d.fail(prefix+text, "payload of %d bytes, RX Frame line says %d (truncated log line?)", len(data), rx.PayloadLen)
+		return
+	}
+	frame, err := usock.EncodeFrame(rx.ID, data)
+	if err != nil {
+		d.fail(prefix+text, "%v", err)
+		return
+	}
+	if crc := uint16(frame[len(frame)-2]) | uint16(frame[len(frame)-1])<<8; crc != rx.PayloadCRC {
+		d.fail(prefix+text, "payload CRC 0x%04x, RX Frame line says 0x%04x", crc, rx.PayloadCRC)
+		return
+	}
+	d.print(prefix+"RX ", int(rx.ID), data, "CRC ok")
+}
+
+// payload prints a hex encoded payload without framing
+func (d *decoder) payload(prefix string, frameID int, text, note string) {
+	data, err := parseHex(text)
+	if err != nil {
+		d.fail(prefix+text, "invalid hex: %v", err)
+		return
+	}
+	d.print(prefix, frameID, data, note)
+}
+
+// fail reports input that failed validation
+func (d *decoder) fail(input, format string, args ...interface{}) {
+	d.invalid++
+	fmt.Fprintf(d.out, "%s\n  error: %s\n", strings.TrimSpace(input), fmt.Sprintf(format, args...))
+}
+
+// print prints a frame and the values of its payload
+func (d *decoder) print(prefix string, frameID int, data []byte, note string) {
+	d.printed++
+	id := "frame ID unknown"
+	if frameID >= 0 {
+		id = fmt.Sprintf("frame 0x%02x", frameID)
+	}
+	fmt.Fprintf(d.out, "%s%s, %d bytes, %s\n", prefix, id, len(data), note)
+
+	if frameID == usock.ChunkFrameID {
+		if len(data) < usock.ChunkHeaderLength {
+			fmt.Fprintf(d.out, "  ACK of a chunk\n")
+		} else {
+			fmt.Fprintf(d.out, "  chunk of a chunked transfer for frame 0x%02x, not decoded\n", data[0])
+		}
+		return
+	}
+	if frameID >= 0 {
+		if isAck, isNack := usock.AckPayload(byte(frameID), data); isAck {
+			fmt.Fprintf(d.out, "  ACK\n")
+			return
+		} else if isNack {
+			fmt.Fprintf(d.out, "  NACK %x\n", data[3:])
+			return
+		}
+	}
+
+	entries, errs := ble.DecodePayload(data)
+	for _, e := range entries {
+		if len(e.Values) == 0 {
+			fmt.Fprintf(d.out, "  %s (empty)\n", e.Type)
+		}
+		for _, v := range e.Values {
+			fmt.Fprintf(d.out, "  %s\n", describe(e.Type, v))
+		}
+	}
+	for _, err := range errs {
+		fmt.Fprintf(d.out, "  error: %v\n", err)
+	}
+}
+
+// describe returns a value as "BATTERY slot2 charge = 87"
+func describe(msgType ble.MessageType, v ble.Value) string {
+	f, ok := ble.Lookup(msgType, v.Key)
+	if !ok {
+		return fmt.Sprintf("%s 0x%04x = %s (unknown subtype)", msgType, v.Key, formatRaw(v.Value))
+	}
+	value, err := f.Decode(v.Value)
+	if err != nil {
+		return fmt.Sprintf("%s = %s (invalid: %v)", f, formatRaw(v.Value), err)
+	}
+	switch value := value.(type) {
+	case int:
+		if f.Enum != nil {
+			if name, ok := f.Enum.Name(value); ok {
+				return fmt.Sprintf("%s = %s (%d)", f, name, value)
+			}
+			return fmt.Sprintf("%s = %d (not in enum)", f, value)
+		}
+	case string:
+		return fmt.Sprintf("%s = %q", f, value)
+	}
+	return fmt.Sprintf("%s = %s", f, f.Format(value))
+}
+
+// formatRaw formats a value as decoded from CBOR
+func formatRaw(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []byte:
+		return fmt.Sprintf("h'%x'", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+func TestDecode(t *testing.T) {
+	frame, err := usock.EncodeFrame(0xe0, []byte{0xa1, 0x18, 0xe0, 0xa2, 0x18, 0xe9, 0x05, 0x18, 0xf5, 0x18, 0x57})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), frame...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	for _, tc := range []struct {
+		name    string
+		input   string
+		want    []string
+		invalid int
+	}{
+		{"frame", hex.EncodeToString(frame), []string{"frame 0xe0, 11 bytes, CRC ok", "BATTERY slot1 charge = 5", "BATTERY slot2 charge = 87"}, 0},
+		{"log line", "2024/01/01 00:00:00 TX Complete Frame: " + hex.EncodeToString(frame), []string{"TX frame 0xe0", "BATTERY slot2 charge = 87"}, 0},
+		{"ack", "a1 18 e0 a0", []string{"ACK"}, 0},
+		{"enum", "a1 18 20 a1 18 21 01", []string{"SCOOTER_STATE state = parked (1)"}, 0},
+		{"unknown subtype", "a1 18 e0 a1 18 ff 01", []string{"BATTERY 0x00ff = 1 (unknown subtype)"}, 0},
+		{"payload CRC", hex.EncodeToString(corrupt), []string{"error: invalid frame 0xe0"}, 1},
+		{"truncated", hex.EncodeToString(frame[:8]), []string{"error: 8 trailing bytes"}, 1},
+		{"RX lines", "RX Frame: ID=0xe0, Len=11, HeaderCRC=0x0, PayloadCRC=0x0\nRX Payload: a118e0a218e9051" + "8f51857", []string{"payload CRC 0x"}, 1},
+	} {
+		var out bytes.Buffer
+		d := newDecoder(&out, 0xe0)
+		if err := d.read(strings.NewReader(tc.input)); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: output lacks %q:\n%s", tc.name, want, out.String())
+			}
+		}
+		if d.invalid != tc.invalid {
+			t.Errorf("%s: %d invalid frames, want %d", tc.name, d.invalid, tc.invalid)
+		}
+	}
+}
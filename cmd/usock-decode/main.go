@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var frameID = flag.Int("id", -1, "Frame ID of bare hex payloads, used to recognise ACKs")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [FILE | HEX]...\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Decodes USOCK frames and prints their messages with the names from pkg/ble.\n")
+		fmt.Fprintf(os.Stderr, "Reads raw hex (whole frames starting with f6d9, or bare CBOR payloads),\n")
+		fmt.Fprintf(os.Stderr, "service log lines (\"TX Complete Frame:\", \"RX Frame:\"/\"RX Payload:\",\n")
+		fmt.Fprintf(os.Stderr, "\"Sending message:\") and --capture files, from the arguments or stdin.\n")
+		fmt.Fprintf(os.Stderr, "Frames are checked for framing and CRC errors; the exit status is 1 if any fail.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(0)
+
+	d := newDecoder(os.Stdout, *frameID)
+	if flag.NArg() == 0 {
+		if err := d.read(os.Stdin); err != nil {
+			log.Fatalf("Failed to read stdin: %v", err)
+		}
+	}
+	for _, arg := range flag.Args() {
+		if arg == "-" {
+			if err := d.read(os.Stdin); err != nil {
+				log.Fatalf("Failed to read stdin: %v", err)
+			}
+			continue
+		}
+		f, err := os.Open(arg)
+		if err != nil {
+			// Not a file, so it has to be hex
+			if !d.line(arg) {
+				log.Fatalf("%s is neither a file nor hex", arg)
+			}
+			continue
+		}
+		err = d.read(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", arg, err)
+		}
+	}
+
+	if d.printed == 0 && d.invalid == 0 {
+		log.Printf("No frames found")
+	}
+	if d.invalid > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/librescoot/bluetooth-service/pkg/redis"
+	"github.com/librescoot/bluetooth-service/pkg/service"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// Configuration flags
+var (
+	redisAddr = flag.String("redis-addr", "localhost:6379", "Redis server address")
+	redisPass = flag.String("redis-pass", "", "Redis password")
+	redisDB   = flag.Int("redis-db", 0, "Redis database number")
+	realtime  = flag.Bool("realtime", false, "Replay frames at the pace they were captured")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] CAPTURE_FILE\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Feeds the RX frames of a --capture file (or a flight recorder dump from the log)\n")
+		fmt.Fprintf(os.Stderr, "through the service message handler against the given Redis.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open capture: %v", err)
+	}
+	defer f.Close()
+
+	redisClient, err := redis.New(*redisAddr, *redisPass, *redisDB)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer redisClient.Close()
+
+	svc := service.New(redisClient)
+
+	// Frames the handlers send back (e.g. reset ACKs) go nowhere
+	local, remote := usock.NewPipe()
+	go io.Copy(io.Discard, remote)
+	sock := usock.NewWithTransport(local, nil)
+	svc.SetUSock(sock)
+
+	r := newReplayer(func(payload *usock.Payload) {
+		svc.HandleUSockMessage(payload.ID, payload)
+	}, *realtime)
+	if err := r.read(f); err != nil {
+		log.Fatalf("Failed to replay capture: %v", err)
+	}
+
+	log.Printf("Replayed %d payloads, skipped %d frames (TX or rejected) and %d broken transfers", r.replayed, r.skipped, r.broken)
+}
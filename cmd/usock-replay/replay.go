@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// replayer feeds the RX frames of a capture to a handler as USOCK would:
+// chunks are reassembled into the payload they carry
+type replayer struct {
+	handle   func(*usock.Payload)
+	realtime bool // Keep the captured pace
+
+	chunks   *usock.Reassembler
+	last     time.Time
+	replayed int // Payloads handled
+	skipped  int // TX and rejected frames
+	broken   int // Transfers that could not be reassembled
+}
+
+func newReplayer(handle func(*usock.Payload), realtime bool) *replayer {
+	return &replayer{handle: handle, realtime: realtime, chunks: usock.NewReassembler()}
+}
+
+// read replays every frame of a capture
+func (r *replayer) read(in io.Reader) error {
+	return usock.ReadCapture(in, r.frame)
+}
+
+// frame replays a single captured frame
+func (r *replayer) frame(frame usock.CapturedFrame) error {
+	if frame.Dir != usock.DirRX || frame.Err != "" {
+		r.skipped++
+		return nil
+	}
+	data, err := frame.Payload()
+	if err != nil {
+		return fmt.Errorf("frame at %s: %v", frame.Time.Format(time.RFC3339Nano), err)
+	}
+
+	if r.realtime && !r.last.IsZero() && frame.Time.After(r.last) {
+		time.Sleep(frame.Time.Sub(r.last))
+	}
+	r.last = frame.Time
+
+	payload, err := r.chunks.Add(frame.ID, data, frame.Time)
+	if err != nil {
+		log.Printf("Chunk captured at %s: %v", frame.Time.Format(time.RFC3339Nano), err)
+		r.broken++
+		return nil
+	}
+	if payload == nil {
+		return nil // More chunks to come
+	}
+
+	log.Printf("Replaying RX frame ID=0x%02x captured at %s", payload.ID, frame.Time.Format(time.RFC3339Nano))
+	r.handle(payload)
+	r.replayed++
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// TestReplayChunked records a chunked transfer with a capture and replays it
+func TestReplayChunked(t *testing.T) {
+	long := bytes.Repeat([]byte{0xa1, 0x19, 0xa0, 0x40, 0x01}, 2*usock.MaxPayloadLength/5)
+	short := []byte{0xa1, 0x19, 0xa0, 0x40, 0x02}
+
+	var capture bytes.Buffer
+	a, b := usock.NewPipe()
+	sender := usock.NewWithTransport(a, nil)
+	defer sender.Close()
+	received := make(chan struct{}, 2)
+	receiver := usock.NewWithTransport(b, func(*usock.Payload) { received <- struct{}{} })
+	receiver.SetCapture(usock.NewCaptureWriter(&capture))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, data := range [][]byte{long, short} {
+		if err := sender.WriteChunked(ctx, 0x40, data); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-received:
+		case <-ctx.Done():
+			t.Fatal("payload not received")
+		}
+	}
+	receiver.Close()
+	if n := strings.Count(capture.String(), `"id":254`); n < 2 {
+		t.Fatalf("capture holds %d chunks, want a chunked transfer", n)
+	}
+
+	var replayed []*usock.Payload
+	r := newReplayer(func(p *usock.Payload) { replayed = append(replayed, p) }, false)
+	if err := r.read(&capture); err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 2 || r.broken != 0 {
+		t.Fatalf("replayed %d payloads with %d broken transfers, want 2 and 0", len(replayed), r.broken)
+	}
+	for i, want := range [][]byte{long, short} {
+		if p := replayed[i]; p.ID != 0x40 || !bytes.Equal(p.Data, want) {
+			t.Errorf("payload %d: got ID 0x%02x with %d bytes, want ID 0x40 with %d bytes", i, p.ID, len(p.Data), len(want))
+		}
+	}
+}
+
+// TestReplayCapture writes a capture and replays it: only frames received
+// and accepted by the parser are handled, with their captured payload
+func TestReplayCapture(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+	written := []usock.CapturedFrame{
+		{Time: start, Dir: usock.DirRX, ID: 0x20, Data: "a11820a1182101"},
+		{Time: start.Add(time.Millisecond), Dir: usock.DirTX, ID: 0x40, Data: "a11840a0"},
+		{Time: start.Add(2 * time.Millisecond), Dir: usock.DirRX, ID: 0x20, Data: "a11820", Err: "payload crc"},
+		{Time: start.Add(3 * time.Millisecond), Dir: usock.DirRX, ID: 0x21, Data: "a11821a1182202"},
+	}
+	var capture bytes.Buffer
+	w := usock.NewCaptureWriter(&capture)
+	for _, f := range written {
+		if err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var read []usock.CapturedFrame
+	if err := usock.ReadCapture(bytes.NewReader(capture.Bytes()), func(f usock.CapturedFrame) error {
+		read = append(read, f)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(written) {
+		t.Fatalf("read %d frames, want %d", len(read), len(written))
+	}
+	for i, f := range read {
+		if w := written[i]; !f.Time.Equal(w.Time) || f.Dir != w.Dir || f.ID != w.ID || f.Data != w.Data || f.Err != w.Err {
+			t.Errorf("frame %d: read %+v, want %+v", i, f, w)
+		}
+	}
+
+	// The same frames as dumped by the flight recorder into the service log
+	var dump bytes.Buffer
+	for _, line := range strings.SplitAfter(capture.String(), "\n") {
+		if line != "" {
+			dump.WriteString("2026/01/02 03:04:05 Flight recorder: " + line)
+		}
+	}
+	for name, in := range map[string]*bytes.Buffer{"capture": &capture, "log": &dump} {
+		var replayed []*usock.Payload
+		r := newReplayer(func(p *usock.Payload) { replayed = append(replayed, p) }, false)
+		if err := r.read(in); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(replayed) != 2 || r.replayed != 2 || r.skipped != 2 {
+			t.Fatalf("%s: replayed %d payloads and skipped %d frames, want 2 and 2", name, len(replayed), r.skipped)
+		}
+		if p := replayed[1]; p.ID != 0x21 || hex.EncodeToString(p.Data) != "a11821a1182202" {
+			t.Errorf("%s: replayed ID 0x%02x % x", name, p.ID, p.Data)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package ble
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProtocolVersion is the version of the message protocol spoken by the
+// service. Firmware that does not answer the capability handshake speaks
+// version 0.
+const ProtocolVersion = 1
+
+// Capabilities are the protocol version and message types supported by the
+// nRF52 firmware. They are exchanged at 0xA003 as
+// [protocol version, [message type, ...]], first by the service, then by
+// the firmware.
+type Capabilities struct {
+	Version int
+	Types   map[MessageType]bool
+}
+
+// KnownTypes returns the message types the service knows, in ascending order
+func KnownTypes() []MessageType {
+	types := make([]MessageType, 0, len(typeNames))
+	for t := range typeNames {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// LegacyCapabilities are assumed for firmware that does not answer the
+// handshake: protocol version 0 with every known message type
+func LegacyCapabilities() Capabilities {
+	c := Capabilities{Types: make(map[MessageType]bool)}
+	for _, t := range KnownTypes() {
+		c.Types[t] = true
+	}
+	return c
+}
+
+// ServiceCapabilities are the capabilities the service announces
+func ServiceCapabilities() Capabilities {
+	c := LegacyCapabilities()
+	c.Version = ProtocolVersion
+	return c
+}
+
+// Supports tells whether the firmware handles messages of type t
+func (c Capabilities) Supports(t MessageType) bool {
+	return c.Types[t]
+}
+
+// Encode returns the handshake value of the capabilities
+func (c Capabilities) Encode() []interface{} {
+	types := make([]interface{}, 0, len(c.Types))
+	for _, t := range c.sortedTypes() {
+		types = append(types, uint16(t))
+	}
+	return []interface{}{c.Version, types}
+}
+
+// DecodeCapabilities parses a received handshake value. Unknown message
+// types are kept, so they show up in the stored capabilities.
+func DecodeCapabilities(value interface{}) (Capabilities, error) {
+	arr, ok := value.([]interface{})
+	if !ok || len(arr) != 2 {
+		return Capabilities{}, fmt.Errorf("expected [version, [types]], got %v", value)
+	}
+	version, err := ToInt(arr[0])
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("protocol version: %v", err)
+	}
+	types, ok := arr[1].([]interface{})
+	if !ok {
+		return Capabilities{}, fmt.Errorf("expected a list of message types, got %T", arr[1])
+	}
+
+	c := Capabilities{Version: version, Types: make(map[MessageType]bool)}
+	for _, v := range types {
+		t, err := ToInt(v)
+		if err != nil || t < 0 || t > 0xFFFF {
+			return Capabilities{}, fmt.Errorf("invalid message type %v", v)
+		}
+		c.Types[MessageType(t)] = true
+	}
+	return c, nil
+}
+
+// String returns the names of the supported message types, comma separated
+func (c Capabilities) String() string {
+	names := make([]string, 0, len(c.Types))
+	for _, t := range c.sortedTypes() {
+		names = append(names, t.String())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c Capabilities) sortedTypes() []MessageType {
+	types := make([]MessageType, 0, len(c.Types))
+	for t, ok := range c.Types {
+		if ok {
+			types = append(types, t)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// ParseMessageType returns the message type of a name as returned by
+// MessageType.String
+func ParseMessageType(name string) (MessageType, bool) {
+	for t, n := range typeNames {
+		if n == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,59 @@
+package ble
+
+import (
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestCapabilitiesRoundTrip(t *testing.T) {
+	caps := Capabilities{Version: 3, Types: map[MessageType]bool{TypeBattery: true, TypeVehicleState: true, 0x1234: true}}
+	data, err := cbor.Marshal(caps.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value interface{}
+	if err := cbor.Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeCapabilities(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 3 || len(got.Types) != 3 || !got.Supports(TypeBattery) || got.Supports(TypeBatteryInfo) {
+		t.Errorf("got %+v", got)
+	}
+	if s := got.String(); s != "SCOOTER_STATE,BATTERY,0x1234" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestDecodeCapabilitiesInvalid(t *testing.T) {
+	for _, value := range []interface{}{
+		uint64(1),
+		[]interface{}{uint64(1)},
+		[]interface{}{"1", []interface{}{}},
+		[]interface{}{uint64(1), uint64(2)},
+		[]interface{}{uint64(1), []interface{}{uint64(0x10000)}},
+	} {
+		if _, err := DecodeCapabilities(value); err == nil {
+			t.Errorf("%v accepted", value)
+		}
+	}
+}
+
+func TestLegacyCapabilities(t *testing.T) {
+	caps := LegacyCapabilities()
+	if caps.Version != 0 {
+		t.Errorf("legacy version %d", caps.Version)
+	}
+	for _, typ := range KnownTypes() {
+		if !caps.Supports(typ) {
+			t.Errorf("legacy firmware does not support %s", typ)
+		}
+		if parsed, ok := ParseMessageType(typ.String()); !ok || parsed != typ {
+			t.Errorf("ParseMessageType(%q) = %v, %v", typ, parsed, ok)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package ble
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mappingFile is a Redis mapping loaded at startup, see LoadMapping. Its
+// keys are those of protocol.yaml.
+type mappingFile struct {
+	Enums  map[string][]mappingEnumValue `yaml:"enums"`
+	Fields []mappingEntry                `yaml:"fields"`
+}
+
+type mappingEnumValue struct {
+	Value int    `yaml:"value"`
+	Name  string `yaml:"name"`
+}
+
+// mappingEntry overrides the field of a subtype, or adds one. Keys left out
+// keep the registered value.
+type mappingEntry struct {
+	Type      string    `yaml:"type"`
+	SubType   *int      `yaml:"subtype"` // Relative to Type
+	Name      string    `yaml:"name"`
+	Kind      string    `yaml:"kind"`
+	Wire      string    `yaml:"wire"`
+	Direction string    `yaml:"direction"`
+	Redis     *[]string `yaml:"redis"` // [hash, field], [] for none
+	Publish   *bool     `yaml:"publish"`
+	Default   *string   `yaml:"default"`
+	Enum      *string   `yaml:"enum"` // Of the file or protocol.yaml, "" for none
+	Fallback  *string   `yaml:"enum_fallback"`
+}
+
+// LoadMapping applies a Redis mapping file to the registry, so scooters
+// with another Redis schema can use the service: every entry names a
+// message type and relative subtype, and replaces the Redis hash and field,
+// kind, wire type, direction, enum and default of its field or registers a
+// new one. It must be called before the fields are used.
+func LoadMapping(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read mapping: %v", err)
+	}
+	fields, err := parseMapping(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, f := range fields {
+		if registered, ok := byKey[f.Key()]; ok {
+			*registered = f
+		} else {
+			Register(f)
+		}
+	}
+	return nil
+}
+
+// parseMapping returns the fields of a mapping file as they are to be
+// registered, without changing the registry
+func parseMapping(data []byte) ([]Field, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var m mappingFile
+	if err := dec.Decode(&m); err != nil && err != io.EOF { // An empty file maps nothing
+		return nil, fmt.Errorf("failed to parse mapping: %v", err)
+	}
+
+	fileEnums := make(map[string]Enum)
+	for name, values := range m.Enums {
+		e := make(Enum, 0, len(values))
+		for _, v := range values {
+			if v.Name == "" {
+				return nil, fmt.Errorf("enum %s: value %d has no name", name, v.Value)
+			}
+			e = append(e, EnumValue{Wire: v.Value, Name: v.Name})
+		}
+		fileEnums[name] = e
+	}
+
+	var fields []Field
+	seen := make(map[uint16]bool)
+	for i, entry := range m.Fields {
+		f, err := entry.field(fileEnums)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %v", i+1, err)
+		}
+		if seen[f.Key()] {
+			return nil, fmt.Errorf("field %d: %s is mapped twice", i+1, &f)
+		}
+		seen[f.Key()] = true
+		fields = append(fields, f)
+	}
+
+	// Names are unique per message type, also across entries
+	for i, f := range fields {
+		for _, other := range registry {
+			if other.Type == f.Type && other.Name == f.Name && other.Key() != f.Key() && !seen[other.Key()] {
+				return nil, fmt.Errorf("%s: name taken by subtype 0x%04x", &f, other.Key())
+			}
+		}
+		for _, other := range fields[:i] {
+			if other.Type == f.Type && other.Name == f.Name {
+				return nil, fmt.Errorf("%s: name given to two subtypes", &f)
+			}
+		}
+	}
+	return fields, nil
+}
+
+// field returns the registered field of the entry with its overrides applied
+func (e *mappingEntry) field(fileEnums map[string]Enum) (Field, error) {
+	msgType, ok := ParseMessageType(e.Type)
+	if !ok {
+		return Field{}, fmt.Errorf("unknown message type %q", e.Type)
+	}
+	if e.SubType == nil || *e.SubType < 0 || int(msgType)+*e.SubType > 0xFFFF {
+		return Field{}, fmt.Errorf("%s: missing or invalid subtype", msgType)
+	}
+	key := uint16(msgType) + uint16(*e.SubType)
+
+	var f Field
+	if registered, ok := byKey[key]; ok {
+		if registered.Type != msgType {
+			return Field{}, fmt.Errorf("%s subtype %d: absolute subtype 0x%04x belongs to %s", msgType, *e.SubType, key, registered)
+		}
+		f = *registered
+	} else {
+		if e.Name == "" || e.Kind == "" || e.Direction == "" {
+			return Field{}, fmt.Errorf("%s subtype %d: new fields need a name, kind and direction", msgType, *e.SubType)
+		}
+		f = Field{Type: msgType, SubType: SubType(*e.SubType)}
+	}
+
+	if e.Name != "" {
+		f.Name = e.Name
+	}
+	if e.Kind != "" {
+		kind, err := parseKind(e.Kind)
+		if err != nil {
+			return Field{}, fmt.Errorf("%s: %v", &f, err)
+		}
+		if kind != f.Kind && fixedKinds[key] {
+			return Field{}, fmt.Errorf("%s: the kind of the field is fixed to %s", &f, f.Kind)
+		}
+		f.Kind = kind
+	}
+	if e.Wire != "" {
+		wire, err := parseWire(e.Wire)
+		if err != nil {
+			return Field{}, fmt.Errorf("%s: %v", &f, err)
+		}
+		f.Wire = wire
+	}
+	if e.Direction != "" {
+		d, err := ParseDirection(e.Direction)
+		if err != nil {
+			return Field{}, fmt.Errorf("%s: %v", &f, err)
+		}
+		f.Direction = d
+	}
+	if e.Redis != nil {
+		switch redis := *e.Redis; len(redis) {
+		case 0:
+			f.RedisKey, f.RedisField = "", ""
+		case 2:
+			f.RedisKey, f.RedisField = redis[0], redis[1]
+		default:
+			return Field{}, fmt.Errorf("%s: redis must be [hash, field] or []", &f)
+		}
+	}
+	if e.Publish != nil {
+		f.Publish = *e.Publish
+	}
+	if e.Default != nil {
+		f.Default = *e.Default
+	}
+	if e.Enum != nil {
+		switch enum, inFile := fileEnums[*e.Enum]; {
+		case *e.Enum == "":
+			f.Enum, f.EnumFallback = nil, ""
+		case inFile:
+			f.Enum = enum
+		case enums[*e.Enum] != nil:
+			f.Enum = enums[*e.Enum]
+		default:
+			return Field{}, fmt.Errorf("%s: unknown enum %q", &f, *e.Enum)
+		}
+	}
+
+	if e.Fallback != nil {
+		f.EnumFallback = *e.Fallback
+	}
+
+	if f.EnumFallback != "" && (f.Enum == nil || strings.Count(f.EnumFallback, "%d") != 1 ||
+		strings.Count(strings.ReplaceAll(f.EnumFallback, "%%", ""), "%") != 1) {
+		return Field{}, fmt.Errorf("%s: enum_fallback needs an enum and one %%d", &f)
+	}
+	if f.Enum != nil && f.Kind != KindInt {
+		return Field{}, fmt.Errorf("%s: enum of a %s field", &f, f.Kind)
+	}
+	if f.Wire != WireUint16 && f.Kind != KindInt && f.Kind != KindBool {
+		return Field{}, fmt.Errorf("%s: wire type of a %s field", &f, f.Kind)
+	}
+	return f, nil
+}
+
+// parseKind returns the kind of a name as returned by ValueKind.String
+func parseKind(name string) (ValueKind, error) {
+	for k := KindInt; k <= KindBytes; k++ {
+		if k.String() == name {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown kind %q", name)
+}
+
+// parseWire returns the wire type of a name as returned by WireType.String
+func parseWire(name string) (WireType, error) {
+	for w := WireUint16; w <= WireInt64; w++ {
+		if w.String() == name {
+			return w, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown wire type %q", name)
+}
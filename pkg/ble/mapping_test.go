@@ -0,0 +1,92 @@
+package ble
+
+import (
+	"strings"
+	"testing"
+)
+
+const testMapping = `
+enums:
+  drive-state:
+    - {value: 0, name: idle}
+    - {value: 2, name: driving}
+fields:
+  - {type: SCOOTER_STATE, subtype: 1, redis: [scooter, mode], enum: drive-state, default: idle}
+  - {type: SCOOTER_STATE, subtype: 3, enum: ""}
+  - {type: BATTERY, subtype: 21, redis: []}
+  - {type: SCOOTER_INFO, subtype: 2, enum: vehicle-state, wire: int64}
+  - {type: BATTERY, subtype: 7, name: slot1 voltage, kind: int, wire: int32, direction: inbound,
+     redis: ["battery:0", voltage], publish: true}
+`
+
+func TestParseMapping(t *testing.T) {
+	fields, err := parseMapping([]byte(testMapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 5 {
+		t.Fatalf("got %d fields, want 5", len(fields))
+	}
+
+	state := fields[0]
+	if state.Name != "state" || state.RedisKey != "scooter" || state.RedisField != "mode" || state.Default != "idle" {
+		t.Errorf("state: got %+v", state)
+	}
+	if wire, err := state.Encode("driving"); err != nil || wire != uint16(2) {
+		t.Errorf("state: Encode(driving) = %v, %v; want 2", wire, err)
+	}
+	if FieldVehicleStateState.RedisKey != "vehicle" {
+		t.Errorf("parseMapping changed the registry")
+	}
+	if fields[1].Enum != nil || fields[1].RedisField != "handlebar:lock-sensor" {
+		t.Errorf("handlebar: got %+v", fields[1])
+	}
+	if fields[2].RedisKey != "" {
+		t.Errorf("slot2 charge: Redis mapping not removed")
+	}
+	if fields[3].Wire != WireInt64 || fields[3].Enum[1].Name != "parked" {
+		t.Errorf("mileage: got %+v", fields[3])
+	}
+	voltage := fields[4]
+	if voltage.Key() != 0x00E7 || voltage.Kind != KindInt || voltage.Wire != WireInt32 || voltage.Direction != Inbound || !voltage.Publish {
+		t.Errorf("voltage: got %+v", voltage)
+	}
+}
+
+func TestParseMappingErrors(t *testing.T) {
+	FixKind(FieldEvent.Key())
+	for _, tc := range []struct {
+		mapping string
+		want    string
+	}{
+		{"fields: [{type: NOPE, subtype: 1}]", "unknown message type"},
+		{"fields: [{type: BATTERY}]", "missing or invalid subtype"},
+		{"fields: [{type: BATTERY, subtype: 7}]", "new fields need a name, kind and direction"},
+		{"fields: [{type: BLE_PARAM, subtype: 2}]", "belongs to BLE_PAIRING_PIN_DISPLAY"},
+		{"fields: [{type: BATTERY, subtype: 9, kind: colour}]", "unknown kind"},
+		{"fields: [{type: BATTERY, subtype: 9, redis: [battery]}]", "redis must be"},
+		{"fields: [{type: BATTERY, subtype: 9, enum: nope}]", "unknown enum"},
+		{"fields: [{type: SCOOTER_INFO, subtype: 1, enum: seatbox}]", "enum of a string field"},
+		{"fields: [{type: BATTERY, subtype: 9}, {type: BATTERY, subtype: 9}]", "mapped twice"},
+		{"fields: [{type: BATTERY, subtype: 9, name: slot2 charge}]", "name taken"},
+		{"fields: [{type: BATTERY, subtype: 9, colour: red}]", "not found"},
+		{"fields: [{type: EVENT, subtype: 0, kind: int}]", "kind of the field is fixed"},
+		{"fields: [{type: BATTERY, subtype: 9, enum_fallback: \"slot %d\"}]", "enum_fallback needs an enum"},
+		{"fields: [{type: BATTERY, subtype: 2, enum_fallback: \"%s\"}]", "enum_fallback needs an enum"},
+	} {
+		_, err := parseMapping([]byte(tc.mapping))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.mapping, err, tc.want)
+		}
+	}
+
+	if fields, err := parseMapping([]byte("# Nothing to change\n")); err != nil || len(fields) != 0 {
+		t.Errorf("empty mapping: got %v, %v", fields, err)
+	}
+
+	// Swapping the names of two subtypes is fine
+	swap := "fields: [{type: BATTERY, subtype: 9, name: slot2 charge}, {type: BATTERY, subtype: 21, name: slot1 charge}]"
+	if _, err := parseMapping([]byte(swap)); err != nil {
+		t.Errorf("swap: %v", err)
+	}
+}
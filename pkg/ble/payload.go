@@ -0,0 +1,204 @@
+package ble
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Value is one {absoluteSubtype: value} entry of a message
+type Value struct {
+	Key   uint16 // Absolute subtype
+	Value interface{}
+}
+
+// Entry is one top-level {type: {values}} entry of a frame payload
+type Entry struct {
+	Type   MessageType
+	Values []Value // In payload order
+}
+
+// ProtocolErrorKind tells what is wrong with a received payload
+type ProtocolErrorKind int
+
+const (
+	ErrInvalidCBOR    ProtocolErrorKind = iota + 1 // Not well-formed CBOR
+	ErrNotMap                                      // Top level is not a map
+	ErrEmptyMessage                                // Top-level map without entries
+	ErrTypeKey                                     // Top-level key is not a message type
+	ErrParams                                      // Message value is not a map
+	ErrSubtypeKey                                  // Inner key is not an absolute subtype
+	ErrTrailingData                                // Bytes after the top-level map
+	ErrUnknownSubtype                              // No registered field for the subtype
+	ErrInvalidValue                                // Value does not match its field
+)
+
+// String returns the name the kind is counted under in Redis
+func (k ProtocolErrorKind) String() string {
+	switch k {
+	case ErrInvalidCBOR:
+		return "invalid-cbor"
+	case ErrNotMap:
+		return "not-a-map"
+	case ErrEmptyMessage:
+		return "empty-message"
+	case ErrTypeKey:
+		return "bad-type-key"
+	case ErrParams:
+		return "bad-params"
+	case ErrSubtypeKey:
+		return "bad-subtype-key"
+	case ErrTrailingData:
+		return "trailing-data"
+	case ErrUnknownSubtype:
+		return "unknown-subtype"
+	case ErrInvalidValue:
+		return "invalid-value"
+	default:
+		return fmt.Sprintf("ProtocolErrorKind(%d)", int(k))
+	}
+}
+
+// ProtocolErrorKinds lists all kinds, e.g. to initialize counters
+var ProtocolErrorKinds = []ProtocolErrorKind{
+	ErrInvalidCBOR, ErrNotMap, ErrEmptyMessage, ErrTypeKey, ErrParams,
+	ErrSubtypeKey, ErrTrailingData, ErrUnknownSubtype, ErrInvalidValue,
+}
+
+// ProtocolError describes a malformed part of a received payload
+type ProtocolError struct {
+	Kind   ProtocolErrorKind
+	Type   MessageType // Message the error was found in, if known
+	Detail string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind, e.Detail)
+}
+
+// DecodePayload decodes a frame payload into its entries, keeping the order
+// of the payload. Malformed entries are skipped and reported as errors, so a
+// single bad entry does not cost the others. An empty inner map, as in the
+// ACK {frameID: {}}, yields an entry without values.
+func DecodePayload(data []byte) ([]Entry, []*ProtocolError) {
+	if err := cbor.Wellformed(data); err != nil {
+		// Wellformed also rejects trailing bytes; try the first item alone
+		if _, firstErr := cbor.UnmarshalFirst(data, new(cbor.RawMessage)); firstErr != nil {
+			return nil, []*ProtocolError{{Kind: ErrInvalidCBOR, Detail: err.Error()}}
+		}
+	}
+
+	var errs []*ProtocolError
+	entries, rest, err := orderedMap(data)
+	if err != nil {
+		return nil, []*ProtocolError{{Kind: ErrNotMap, Detail: err.Error()}}
+	}
+	if len(rest) > 0 {
+		errs = append(errs, &ProtocolError{Kind: ErrTrailingData, Detail: fmt.Sprintf("%d bytes after the message map", len(rest))})
+	}
+	if len(entries) == 0 {
+		return nil, append(errs, &ProtocolError{Kind: ErrEmptyMessage, Detail: "message map has no entries"})
+	}
+
+	var decoded []Entry
+	for _, entry := range entries {
+		msgType, ok := uint16Key(entry.key)
+		if !ok {
+			errs = append(errs, &ProtocolError{Kind: ErrTypeKey, Detail: fmt.Sprintf("message type key %v (%T)", entry.key, entry.key)})
+			continue
+		}
+		msg := Entry{Type: MessageType(msgType)}
+
+		params, _, err := orderedMap(entry.raw)
+		if err != nil {
+			errs = append(errs, &ProtocolError{Kind: ErrParams, Type: msg.Type, Detail: err.Error()})
+			continue
+		}
+		for _, param := range params {
+			key, ok := uint16Key(param.key)
+			if !ok {
+				errs = append(errs, &ProtocolError{Kind: ErrSubtypeKey, Type: msg.Type, Detail: fmt.Sprintf("subtype key %v (%T)", param.key, param.key)})
+				continue
+			}
+			var value interface{}
+			if err := cbor.Unmarshal(param.raw, &value); err != nil {
+				errs = append(errs, &ProtocolError{Kind: ErrInvalidCBOR, Type: msg.Type, Detail: err.Error()})
+				continue
+			}
+			msg.Values = append(msg.Values, Value{Key: key, Value: value})
+		}
+		decoded = append(decoded, msg)
+	}
+	return decoded, errs
+}
+
+// mapEntry is a key and the raw CBOR of its value
+type mapEntry struct {
+	key interface{}
+	raw cbor.RawMessage
+}
+
+// orderedMap decodes the CBOR map at the start of data into its entries in
+// encoding order and returns the bytes after it
+func orderedMap(data []byte) ([]mapEntry, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("no data")
+	}
+	if data[0]>>5 != 5 {
+		return nil, nil, fmt.Errorf("expected a map, got CBOR major type %d", data[0]>>5)
+	}
+
+	// Entry count from the additional information of the initial byte
+	info := data[0] & 0x1f
+	rest := data[1:]
+	count, indefinite := uint64(0), false
+	switch {
+	case info < 24:
+		count = uint64(info)
+	case info == 31:
+		indefinite = true
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(rest) < size {
+			return nil, nil, fmt.Errorf("truncated map header")
+		}
+		var buf [8]byte
+		copy(buf[8-size:], rest[:size])
+		count = binary.BigEndian.Uint64(buf[:])
+		rest = rest[size:]
+	default:
+		return nil, nil, fmt.Errorf("invalid map header 0x%02x", data[0])
+	}
+
+	var entries []mapEntry
+	for i := uint64(0); indefinite || i < count; i++ {
+		if indefinite {
+			if len(rest) == 0 {
+				return nil, nil, fmt.Errorf("unterminated map")
+			}
+			if rest[0] == 0xff {
+				return entries, rest[1:], nil
+			}
+		}
+		var entry mapEntry
+		var err error
+		if rest, err = cbor.UnmarshalFirst(rest, &entry.key); err != nil {
+			return nil, nil, fmt.Errorf("map key %d: %v", i, err)
+		}
+		if rest, err = cbor.UnmarshalFirst(rest, &entry.raw); err != nil {
+			return nil, nil, fmt.Errorf("map value %d: %v", i, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rest, nil
+}
+
+// uint16Key returns a decoded map key as a uint16
+func uint16Key(key interface{}) (uint16, bool) {
+	k, ok := key.(uint64)
+	if !ok || k > 0xFFFF {
+		return 0, false
+	}
+	return uint16(k), true
+}
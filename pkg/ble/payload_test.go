@@ -0,0 +1,88 @@
+package ble
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func errorKinds(errs []*ProtocolError) []ProtocolErrorKind {
+	var kinds []ProtocolErrorKind
+	for _, err := range errs {
+		kinds = append(kinds, err.Kind)
+	}
+	return kinds
+}
+
+func TestDecodePayloadMultipleTypes(t *testing.T) {
+	// {0x0060: {0x0061: 87, 0x0062: 120}, 0x0040: {0x0042: 100}, 0x0000: {0x0000: "x"}}
+	data := mustHex(t, "a3"+"1860a2186118571862187818"+"40a1184218640"+"0a10061"+"78")
+	entries, errs := DecodePayload(data)
+	if len(errs) != 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	want := []Entry{
+		{Type: 0x0060, Values: []Value{{0x0061, uint64(87)}, {0x0062, uint64(120)}}},
+		{Type: 0x0040, Values: []Value{{0x0042, uint64(100)}}},
+		{Type: 0x0000, Values: []Value{{0x0000, "x"}}},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %v, want %v", entries, want)
+	}
+}
+
+func TestDecodePayloadIndefinite(t *testing.T) {
+	// Indefinite-length maps at both levels
+	entries, errs := DecodePayload(mustHex(t, "bf1860bf186101186202ffff"))
+	if len(errs) != 0 || len(entries) != 1 || len(entries[0].Values) != 2 {
+		t.Fatalf("got %v, errors %v", entries, errs)
+	}
+	if entries[0].Values[1].Key != 0x0062 {
+		t.Errorf("values out of order: %v", entries[0].Values)
+	}
+}
+
+func TestDecodePayloadAck(t *testing.T) {
+	entries, errs := DecodePayload(mustHex(t, "a11820a0"))
+	if len(errs) != 0 || len(entries) != 1 || entries[0].Type != 0x20 || len(entries[0].Values) != 0 {
+		t.Errorf("got %v, errors %v", entries, errs)
+	}
+}
+
+func TestDecodePayloadMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		data    string
+		entries int
+		kinds   []ProtocolErrorKind
+	}{
+		{"invalid CBOR", "a2", 0, []ProtocolErrorKind{ErrInvalidCBOR}},
+		{"not a map", "8101", 0, []ProtocolErrorKind{ErrNotMap}},
+		{"empty", "a0", 0, []ProtocolErrorKind{ErrEmptyMessage}},
+		{"string type key", "a2616101" + "1860a1186101", 1, []ProtocolErrorKind{ErrTypeKey}},
+		{"type key too large", "a11a00010000a1186101", 0, []ProtocolErrorKind{ErrTypeKey}},
+		{"params not a map", "a2186005" + "1840a1184201", 1, []ProtocolErrorKind{ErrParams}},
+		{"truncated", "a11860a2200118610", 0, []ProtocolErrorKind{ErrInvalidCBOR}},
+		{"bad subtype key", "a11860a22001186102", 1, []ProtocolErrorKind{ErrSubtypeKey}},
+		{"trailing data", "a11860a1186101ff", 1, []ProtocolErrorKind{ErrTrailingData}},
+	} {
+		data, err := hex.DecodeString(tc.data)
+		if err != nil {
+			// Odd-length strings are deliberately truncated CBOR
+			data, _ = hex.DecodeString(tc.data[:len(tc.data)-1])
+		}
+		entries, errs := DecodePayload(data)
+		if len(entries) != tc.entries || !reflect.DeepEqual(errorKinds(errs), tc.kinds) {
+			t.Errorf("%s: got %d entries and errors %v, want %d and %v", tc.name, len(entries), errs, tc.entries, tc.kinds)
+		}
+	}
+}
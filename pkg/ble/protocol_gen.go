@@ -0,0 +1,236 @@
+// Code generated by ble-gen from protocol.yaml. DO NOT EDIT.
+
+package ble
+
+// Message types
+const (
+	TypeVehicleState         MessageType = 0x0020 // BLE_SCOOTER_SERVICE_SCOOTER_STATE
+	TypeScooterInfo          MessageType = 0xA040 // BLE_SCOOTER_SERVICE_SCOOTER_INFO
+	TypeBattery              MessageType = 0x00E0 // BLE_SCOOTER_SERVICE_BATTERY
+	TypePowerManagement      MessageType = 0x0800 // BLE_SCOOTER_SERVICE_POWER_MANAGEMENT
+	TypeDataStream           MessageType = 0x00C0 // BLE_SCOOTER_SERVICE_DATA_STREAM
+	TypeBLEParam             MessageType = 0xA080 // BLE_SCOOTER_SERVICE_BLE_PARAM
+	TypeBLEPairingPinDisplay MessageType = 0xA082 // BLE_SCOOTER_SERVICE_BLE_PAIRING_PIN_DISPLAY, BLE_PARAM + 2
+	TypeBLEPairingPinRemove  MessageType = 0xA083 // BLE_SCOOTER_SERVICE_BLE_PAIRING_PIN_REMOVE, BLE_PARAM + 3
+	TypeBLEStatus            MessageType = 0xA084 // BLE_SCOOTER_SERVICE_BLE_STATUS, BLE_PARAM + 4
+	TypeBLECommand           MessageType = 0xAA00 // BLE_SCOOTER_SERVICE_BLE_COMMANDS
+	TypeBLEVersion           MessageType = 0xA000 // BLE_SCOOTER_SERVICE_VERSION
+	TypeBLEDebug             MessageType = 0xA020 // BLE_SCOOTER_SERVICE_DEBUG
+	TypeAuxBattery           MessageType = 0x0040 // BLE_SCOOTER_SERVICE_AUX_BATTERY
+	TypeBatteryInfo          MessageType = 0x0060 // BLE_SCOOTER_SERVICE_CB_BATTERY
+	TypePowerMux             MessageType = 0x0100 // BLE_SCOOTER_SERVICE_POWER_MUX
+	TypeEvent                MessageType = 0x0000 // BLE_SCOOTER_SERVICE_EVENT
+)
+
+// Subtypes, relative to their message type
+const (
+	// SCOOTER_STATE
+	TypeVehicleStateState     SubType = 1 // BLE_SCOOTER_SERVICE_SCOOTER_STATE_STATE
+	TypeVehicleStateSeatbox   SubType = 2 // BLE_SCOOTER_SERVICE_SCOOTER_STATE_SEATBOX
+	TypeVehicleStateHandlebar SubType = 3 // BLE_SCOOTER_SERVICE_SCOOTER_STATE_HANDLEBAR
+
+	// SCOOTER_INFO
+	TypeSoftwareVersion SubType = 1 // BLE_SCOOTER_SERVICE_SOFTWARE_VERSION
+	TypeMileage         SubType = 2 // BLE_SCOOTER_SERVICE_MILEAGE
+
+	// BATTERY
+	TypeBatterySlot1State      SubType = 2  // BLE_SCOOTER_SERVICE_BATTERY_SLOT1_STATE
+	TypeBatterySlot1Presence   SubType = 3  // BLE_SCOOTER_SERVICE_BATTERY_SLOT1_PRESENCE
+	TypeBatterySlot1CycleCount SubType = 6  // BLE_SCOOTER_SERVICE_BATTERY_SLOT1_CYCLE_COUNT
+	TypeBatterySlot1Charge     SubType = 9  // BLE_SCOOTER_SERVICE_BATTERY_SLOT1_CHARGE
+	TypeBatterySlot2State      SubType = 14 // BLE_SCOOTER_SERVICE_BATTERY_SLOT2_STATE
+	TypeBatterySlot2Presence   SubType = 15 // BLE_SCOOTER_SERVICE_BATTERY_SLOT2_PRESENCE
+	TypeBatterySlot2CycleCount SubType = 18 // BLE_SCOOTER_SERVICE_BATTERY_SLOT2_CYCLE_COUNT
+	TypeBatterySlot2Charge     SubType = 21 // BLE_SCOOTER_SERVICE_BATTERY_SLOT2_CHARGE
+
+	// POWER_MANAGEMENT
+	TypePowerManagementState        SubType = 1 // BLE_SCOOTER_SERVICE_POWER_MANAGEMENT_STATE
+	TypePowerManagementPowerRequest SubType = 2 // BLE_SCOOTER_SERVICE_POWER_MANAGEMENT_POWER_REQUEST
+
+	// DATA_STREAM
+	TypeDataStreamEnable SubType = 1 // BLE_SCOOTER_SERVICE_DATA_STREAM_ENABLE
+	TypeDataStreamSync   SubType = 2 // BLE_SCOOTER_SERVICE_DATA_STREAM_SYNC
+
+	// BLE_PARAM
+	TypeBLEParamMACAddress SubType = 1  // BLE_SCOOTER_SERVICE_BLE_PARAM_MAC_ADDRESS
+	TypeBLEParamData       SubType = 24 // BLE_SCOOTER_SERVICE_BLE_PARAM_DATA, custom data parameter
+
+	// VERSION
+	TypeBLEVersionString       SubType = 1 // BLE_SCOOTER_SERVICE_VERSION_STRING
+	TypeBLEVersionRequest      SubType = 2 // BLE_SCOOTER_SERVICE_VERSION_REQUEST
+	TypeBLEVersionCapabilities SubType = 3 // BLE_SCOOTER_SERVICE_VERSION_CAPABILITIES, [protocol version, [message types]], see Capabilities
+
+	// DEBUG
+	TypeBLEDebugResetInfo SubType = 1 // BLE_SCOOTER_SERVICE_DEBUG_RESET_INFO, [reason, count] after the nRF52 reset
+	TypeBLEDebugResetAck  SubType = 3 // BLE_SCOOTER_SERVICE_DEBUG_RESET_ACK
+
+	// AUX_BATTERY
+	TypeAuxBatteryVoltage       SubType = 1 // BLE_SCOOTER_SERVICE_AUX_BATTERY_VOLTAGE
+	TypeAuxBatteryChargerStatus SubType = 3 // BLE_SCOOTER_SERVICE_AUX_BATTERY_CHARGER_STATUS
+	TypeAuxBatteryCharge        SubType = 4 // BLE_SCOOTER_SERVICE_AUX_BATTERY_CHARGE
+
+	// CB_BATTERY
+	TypeBatteryInfoCharge           SubType = 1  // BLE_SCOOTER_SERVICE_CB_BATTERY_CHARGE
+	TypeBatteryInfoCurrent          SubType = 2  // BLE_SCOOTER_SERVICE_CB_BATTERY_CURRENT
+	TypeBatteryInfoRemCapacity      SubType = 3  // BLE_SCOOTER_SERVICE_CB_BATTERY_REMAINING_CAPACITY
+	TypeBatteryInfoFullCapacity     SubType = 4  // BLE_SCOOTER_SERVICE_CB_BATTERY_FULL_CAPACITY
+	TypeBatteryInfoCellVoltage      SubType = 5  // BLE_SCOOTER_SERVICE_CB_BATTERY_CELL_VOLTAGE
+	TypeBatteryInfoTemp             SubType = 6  // BLE_SCOOTER_SERVICE_CB_BATTERY_TEMPERATURE
+	TypeBatteryInfoCycleCount       SubType = 7  // BLE_SCOOTER_SERVICE_CB_BATTERY_CYCLE_COUNT
+	TypeBatteryInfoStatus           SubType = 8  // BLE_SCOOTER_SERVICE_CB_BATTERY_STATUS
+	TypeBatteryInfoTTE              SubType = 9  // BLE_SCOOTER_SERVICE_CB_BATTERY_TTE
+	TypeBatteryInfoTTF              SubType = 10 // BLE_SCOOTER_SERVICE_CB_BATTERY_TTF
+	TypeBatteryInfoProtectionStatus SubType = 11 // BLE_SCOOTER_SERVICE_CB_BATTERY_PROTECTION_STATUS
+	TypeBatteryInfoSOH              SubType = 12 // BLE_SCOOTER_SERVICE_CB_BATTERY_SOH
+	TypeBatteryInfoUniqueID         SubType = 13 // BLE_SCOOTER_SERVICE_CB_BATTERY_UNIQUE_ID
+	TypeBatteryInfoSerialNumber     SubType = 14 // BLE_SCOOTER_SERVICE_CB_BATTERY_SERIAL_NO
+	TypeBatteryInfoBattStatus       SubType = 15 // BLE_SCOOTER_SERVICE_CB_BATTERY_BATT_STATUS
+	TypeBatteryInfoPartNo           SubType = 16 // BLE_SCOOTER_SERVICE_CB_BATTERY_PART_NO
+	TypeBatteryInfoPresent          SubType = 17 // BLE_SCOOTER_SERVICE_CB_BATTERY_PRESENT
+	TypeBatteryInfoChargeStatus     SubType = 18 // BLE_SCOOTER_SERVICE_CB_BATTERY_CHARGE_STATUS
+)
+
+// BLECommand subtypes
+const (
+	BLECommandAdvStartWithWhitelist BLECommand = 1 // BLE_SCOOTER_SERVICE_BLE_COMMANDS_ADV_START_WITH_WHITELISTING
+	BLECommandAdvRestartNoWhitelist BLECommand = 2 // BLE_SCOOTER_SERVICE_BLE_COMMANDS_ADV_RESTART_NO_WHITELISTING
+	BLECommandAdvStop               BLECommand = 3 // BLE_SCOOTER_SERVICE_BLE_COMMANDS_ADV_STOP
+	BLECommandDeleteBond            BLECommand = 4 // BLE_SCOOTER_SERVICE_BLE_COMMANDS_DELETE_BOND
+	BLECommandDeleteAllBonds        BLECommand = 5 // BLE_SCOOTER_SERVICE_BLE_COMMANDS_DELETE_ALL_BONDS
+	BLECommandEnterBootloader       BLECommand = 6 // BLE_SCOOTER_SERVICE_BLE_COMMANDS_ENTER_DFU, reboots into the serial DFU bootloader
+)
+
+// typeNames are the names of the message types in the nRF52 firmware,
+// without the BLE_SCOOTER_SERVICE_ prefix
+var typeNames = map[MessageType]string{
+	TypeVehicleState:         "SCOOTER_STATE",
+	TypeScooterInfo:          "SCOOTER_INFO",
+	TypeBattery:              "BATTERY",
+	TypePowerManagement:      "POWER_MANAGEMENT",
+	TypeDataStream:           "DATA_STREAM",
+	TypeBLEParam:             "BLE_PARAM",
+	TypeBLEPairingPinDisplay: "BLE_PAIRING_PIN_DISPLAY",
+	TypeBLEPairingPinRemove:  "BLE_PAIRING_PIN_REMOVE",
+	TypeBLEStatus:            "BLE_STATUS",
+	TypeBLECommand:           "BLE_COMMANDS",
+	TypeBLEVersion:           "VERSION",
+	TypeBLEDebug:             "DEBUG",
+	TypeAuxBattery:           "AUX_BATTERY",
+	TypeBatteryInfo:          "CB_BATTERY",
+	TypePowerMux:             "POWER_MUX",
+	TypeEvent:                "EVENT",
+}
+
+// Enums mapping wire values to Redis strings
+var (
+	batteryStateEnum   = Enum{{0, "unknown"}, {1, "asleep"}, {2, "idle"}, {3, "active"}}
+	cbChargeStatusEnum = Enum{{0, "not-charging"}, {1, "charging"}}
+	cbPartNumberEnum   = Enum{{5, "MAX17301"}, {6, "MAX17302"}, {7, "MAX17303"}}
+	handlebarEnum      = Enum{{0, "locked"}, {1, "unlocked"}}
+	powerMuxInputEnum  = Enum{{0, "aux"}, {1, "cb"}}
+	powerStateEnum     = Enum{{1, "running"}, {0, "suspending"}, {2, "hibernating"}, {2, "hibernating-l2"}, {3, "suspending-imminent"}, {4, "hibernating-imminent"}, {5, "reboot"}, {1, "reboot-imminent"}}
+	seatboxEnum        = Enum{{0, "closed"}, {1, "open"}}
+	vehicleStateEnum   = Enum{{0, "standby"}, {1, "parked"}, {2, "ready-to-drive"}, {3, "shutting-down"}, {4, "updating"}, {5, "off"}}
+)
+
+// enums are the enums by their name in protocol.yaml
+var enums = map[string]Enum{
+	"battery-state":    batteryStateEnum,
+	"cb-charge-status": cbChargeStatusEnum,
+	"cb-part-number":   cbPartNumberEnum,
+	"handlebar":        handlebarEnum,
+	"power-mux-input":  powerMuxInputEnum,
+	"power-state":      powerStateEnum,
+	"seatbox":          seatboxEnum,
+	"vehicle-state":    vehicleStateEnum,
+}
+
+// Fields exchanged with the nRF52, registered in this order
+var (
+	// Vehicle state, sent whenever the Redis field changes
+	FieldVehicleStateState     = Register(Field{Type: TypeVehicleState, SubType: TypeVehicleStateState, Name: "state", Kind: KindInt, Direction: Outbound, RedisKey: "vehicle", RedisField: "state", Default: "standby", Enum: vehicleStateEnum})
+	FieldVehicleStateSeatbox   = Register(Field{Type: TypeVehicleState, SubType: TypeVehicleStateSeatbox, Name: "seatbox", Kind: KindInt, Direction: Outbound, RedisKey: "vehicle", RedisField: "seatbox:lock", Default: "closed", Enum: seatboxEnum})
+	FieldVehicleStateHandlebar = Register(Field{Type: TypeVehicleState, SubType: TypeVehicleStateHandlebar, Name: "handlebar", Kind: KindInt, Direction: Outbound, RedisKey: "vehicle", RedisField: "handlebar:lock-sensor", Default: "locked", Enum: handlebarEnum})
+
+	// Scooter info, written by either side
+	FieldSoftwareVersion = Register(Field{Type: TypeScooterInfo, SubType: TypeSoftwareVersion, Name: "software-version", Kind: KindString, Direction: Both, RedisKey: "system", RedisField: "mdb-version"})
+	FieldMileage         = Register(Field{Type: TypeScooterInfo, SubType: TypeMileage, Name: "mileage", Kind: KindInt, Wire: WireUint32, Direction: Both, RedisKey: "engine-ecu", RedisField: "odometer", Default: "0"})
+
+	// Main battery slots
+	FieldBatterySlot1State      = Register(Field{Type: TypeBattery, SubType: TypeBatterySlot1State, Name: "slot1 state", Kind: KindInt, Direction: Outbound, RedisKey: "battery:0", RedisField: "state", Default: "unknown", Enum: batteryStateEnum})
+	FieldBatterySlot1Presence   = Register(Field{Type: TypeBattery, SubType: TypeBatterySlot1Presence, Name: "slot1 present", Kind: KindBool, Direction: Outbound, RedisKey: "battery:0", RedisField: "present", Default: "false"})
+	FieldBatterySlot1CycleCount = Register(Field{Type: TypeBattery, SubType: TypeBatterySlot1CycleCount, Name: "slot1 cycle-count", Kind: KindInt, Direction: Outbound, RedisKey: "battery:0", RedisField: "cycle-count", Default: "0"})
+	FieldBatterySlot1Charge     = Register(Field{Type: TypeBattery, SubType: TypeBatterySlot1Charge, Name: "slot1 charge", Kind: KindInt, Direction: Outbound, RedisKey: "battery:0", RedisField: "charge", Default: "0"})
+	FieldBatterySlot2State      = Register(Field{Type: TypeBattery, SubType: TypeBatterySlot2State, Name: "slot2 state", Kind: KindInt, Direction: Outbound, RedisKey: "battery:1", RedisField: "state", Default: "unknown", Enum: batteryStateEnum})
+	FieldBatterySlot2Presence   = Register(Field{Type: TypeBattery, SubType: TypeBatterySlot2Presence, Name: "slot2 present", Kind: KindBool, Direction: Outbound, RedisKey: "battery:1", RedisField: "present", Default: "false"})
+	FieldBatterySlot2CycleCount = Register(Field{Type: TypeBattery, SubType: TypeBatterySlot2CycleCount, Name: "slot2 cycle-count", Kind: KindInt, Direction: Outbound, RedisKey: "battery:1", RedisField: "cycle-count", Default: "0"})
+	FieldBatterySlot2Charge     = Register(Field{Type: TypeBattery, SubType: TypeBatterySlot2Charge, Name: "slot2 charge", Kind: KindInt, Direction: Outbound, RedisKey: "battery:1", RedisField: "charge", Default: "0"})
+
+	// Power management; the hibernation level request is sent along with the state
+	FieldPowerManagementState        = Register(Field{Type: TypePowerManagement, SubType: TypePowerManagementState, Name: "state", Kind: KindInt, Direction: Outbound, RedisKey: "power-manager", RedisField: "state", Default: "running", Enum: powerStateEnum})
+	FieldPowerManagementPowerRequest = Register(Field{Type: TypePowerManagement, SubType: TypePowerManagementPowerRequest, Name: "power-request", Kind: KindInt, Direction: Outbound})
+
+	// Data stream, set by the initialization and reported back by the nRF52
+	FieldDataStreamEnable = Register(Field{Type: TypeDataStream, SubType: TypeDataStreamEnable, Name: "enable", Kind: KindInt, Direction: Inbound, RedisKey: "aux-battery", RedisField: "data-stream-enable"})
+	FieldDataStreamSync   = Register(Field{Type: TypeDataStream, SubType: TypeDataStreamSync, Name: "sync", Kind: KindInt, Direction: Inbound})
+
+	// BLE parameters
+	FieldBLEParamMACAddress = Register(Field{Type: TypeBLEParam, SubType: TypeBLEParamMACAddress, Name: "mac-address", Kind: KindString, Direction: Inbound, RedisKey: "ble", RedisField: "mac-address"})
+	FieldBLEParamData       = Register(Field{Type: TypeBLEParam, SubType: TypeBLEParamData, Name: "data", Kind: KindAny, Direction: Inbound})
+
+	// Pairing PIN to show while a phone pairs
+	FieldBLEPairingPinDisplay = Register(Field{Type: TypeBLEPairingPinDisplay, Name: "pin-code", Kind: KindString, Direction: Inbound, RedisKey: "ble", RedisField: "pin-code", Publish: true})
+
+	// Removes the pairing PIN, sent by either side
+	FieldBLEPairingPinRemove = Register(Field{Type: TypeBLEPairingPinRemove, Name: "remove", Kind: KindInt, Direction: Both})
+
+	// Connection status of the BLE link
+	FieldBLEStatus = Register(Field{Type: TypeBLEStatus, Name: "connection-status", Kind: KindString, Direction: Inbound, RedisKey: "ble", RedisField: "connection-status"})
+
+	// BLE commands, named as in the scooter:bluetooth command list
+	FieldBLECommandAdvStartWithWhitelist = Register(Field{Type: TypeBLECommand, SubType: SubType(BLECommandAdvStartWithWhitelist), Name: "advertising-start-with-whitelisting", Kind: KindInt, Direction: Outbound})
+	FieldBLECommandAdvRestartNoWhitelist = Register(Field{Type: TypeBLECommand, SubType: SubType(BLECommandAdvRestartNoWhitelist), Name: "advertising-restart-no-whitelisting", Kind: KindInt, Direction: Outbound})
+	FieldBLECommandAdvStop               = Register(Field{Type: TypeBLECommand, SubType: SubType(BLECommandAdvStop), Name: "advertising-stop", Kind: KindInt, Direction: Outbound})
+	FieldBLECommandDeleteBond            = Register(Field{Type: TypeBLECommand, SubType: SubType(BLECommandDeleteBond), Name: "delete-bond", Kind: KindInt, Direction: Outbound})
+	FieldBLECommandDeleteAllBonds        = Register(Field{Type: TypeBLECommand, SubType: SubType(BLECommandDeleteAllBonds), Name: "delete-all-bonds", Kind: KindInt, Direction: Outbound})
+	FieldBLECommandEnterBootloader       = Register(Field{Type: TypeBLECommand, SubType: SubType(BLECommandEnterBootloader), Name: "enter-dfu", Kind: KindInt, Direction: Outbound})
+
+	// Firmware version and capability handshake
+	FieldBLEVersionString       = Register(Field{Type: TypeBLEVersion, SubType: TypeBLEVersionString, Name: "version", Kind: KindString, Direction: Inbound, RedisKey: "ble", RedisField: "nrf-fw-version"})
+	FieldBLEVersionCapabilities = Register(Field{Type: TypeBLEVersion, SubType: TypeBLEVersionCapabilities, Name: "capabilities", Kind: KindArray, Direction: Both})
+
+	// Debug information
+	FieldBLEDebugResetInfo = Register(Field{Type: TypeBLEDebug, SubType: TypeBLEDebugResetInfo, Name: "reset-info", Kind: KindArray, Direction: Inbound})
+	FieldBLEDebugResetAck  = Register(Field{Type: TypeBLEDebug, SubType: TypeBLEDebugResetAck, Name: "reset-ack", Kind: KindInt, Direction: Outbound})
+
+	// Auxiliary battery
+	FieldAuxBatteryVoltage       = Register(Field{Type: TypeAuxBattery, SubType: TypeAuxBatteryVoltage, Name: "voltage", Kind: KindInt, Direction: Inbound, RedisKey: "aux-battery", RedisField: "voltage"})
+	FieldAuxBatteryChargerStatus = Register(Field{Type: TypeAuxBattery, SubType: TypeAuxBatteryChargerStatus, Name: "charger-status", Kind: KindString, Direction: Inbound, RedisKey: "aux-battery", RedisField: "charge-status"})
+	FieldAuxBatteryCharge        = Register(Field{Type: TypeAuxBattery, SubType: TypeAuxBatteryCharge, Name: "charge", Kind: KindInt, Direction: Inbound, RedisKey: "aux-battery", RedisField: "charge"})
+
+	// CB battery; the status registers are decoded into alerts and faults by the service
+	FieldBatteryInfoCharge           = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoCharge, Name: "charge", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "charge"})
+	FieldBatteryInfoCurrent          = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoCurrent, Name: "current", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "current"})
+	FieldBatteryInfoRemCapacity      = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoRemCapacity, Name: "remaining-capacity", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "remaining-capacity"})
+	FieldBatteryInfoFullCapacity     = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoFullCapacity, Name: "full-capacity", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "full-capacity"})
+	FieldBatteryInfoCellVoltage      = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoCellVoltage, Name: "cell-voltage", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "cell-voltage"})
+	FieldBatteryInfoTemp             = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoTemp, Name: "temperature", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "temperature"})
+	FieldBatteryInfoCycleCount       = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoCycleCount, Name: "cycle-count", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "cycle-count"})
+	FieldBatteryInfoStatus           = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoStatus, Name: "status", Kind: KindInt, Direction: Inbound})
+	FieldBatteryInfoTTE              = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoTTE, Name: "time-to-empty", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "time-to-empty"})
+	FieldBatteryInfoTTF              = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoTTF, Name: "time-to-full", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "time-to-full"})
+	FieldBatteryInfoProtectionStatus = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoProtectionStatus, Name: "protection-status", Kind: KindInt, Direction: Inbound})
+	FieldBatteryInfoSOH              = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoSOH, Name: "state-of-health", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "state-of-health"})
+	FieldBatteryInfoUniqueID         = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoUniqueID, Name: "unique-id", Kind: KindString, Direction: Inbound, RedisKey: "cb-battery", RedisField: "unique-id"})
+	FieldBatteryInfoSerialNumber     = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoSerialNumber, Name: "serial-number", Kind: KindString, Direction: Inbound, RedisKey: "cb-battery", RedisField: "serial-number"})
+	FieldBatteryInfoBattStatus       = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoBattStatus, Name: "batt-status", Kind: KindInt, Direction: Inbound})
+	FieldBatteryInfoPartNo           = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoPartNo, Name: "part-number", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "part-number", Enum: cbPartNumberEnum, EnumFallback: "MAX1730X (%d)"})
+	FieldBatteryInfoPresent          = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoPresent, Name: "present", Kind: KindBool, Direction: Inbound, RedisKey: "cb-battery", RedisField: "present"})
+	FieldBatteryInfoChargeStatus     = Register(Field{Type: TypeBatteryInfo, SubType: TypeBatteryInfoChargeStatus, Name: "charge-status", Kind: KindInt, Direction: Inbound, RedisKey: "cb-battery", RedisField: "charge-status", Default: "unknown", Enum: cbChargeStatusEnum})
+
+	// Selected input of the power mux, whatever its subtype
+	FieldPowerMuxSelectedInput = Register(Field{Type: TypePowerMux, Name: "selected-input", Kind: KindInt, Direction: Inbound, RedisKey: "power-mux", RedisField: "selected-input", Publish: true, Default: "cb", Enum: powerMuxInputEnum, AnySubType: true})
+
+	// Event strings such as "scooter:seatbox open", whatever their subtype
+	FieldEvent = Register(Field{Type: TypeEvent, Name: "event", Kind: KindString, Direction: Inbound, AnySubType: true})
+)
@@ -0,0 +1,413 @@
+package ble
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ErrOverflow is returned when a value does not fit the wire type of its field
+var ErrOverflow = errors.New("value out of range")
+
+// ValueKind is the Go type a field value is decoded to
+type ValueKind int
+
+const (
+	KindInt    ValueKind = iota // int
+	KindString                  // string
+	KindBool                    // bool, an integer on the wire
+	KindArray                   // []interface{}
+	KindAny                     // Passed on as decoded by CBOR
+	KindBytes                   // []byte, hex encoded in Redis
+)
+
+// String returns the name of the kind
+func (k ValueKind) String() string {
+	switch k {
+	case KindInt:
+		return "int"
+	case KindString:
+		return "string"
+	case KindBool:
+		return "bool"
+	case KindArray:
+		return "array"
+	case KindAny:
+		return "any"
+	case KindBytes:
+		return "bytes"
+	default:
+		return fmt.Sprintf("ValueKind(%d)", int(k))
+	}
+}
+
+// WireType is the integer type an integer or bool field is sent as. It bounds
+// the values that can be sent; CBOR encodes every integer in as few bytes as
+// its value needs.
+type WireType int
+
+const (
+	WireUint16 WireType = iota // Default
+	WireUint32
+	WireInt32
+	WireInt64
+)
+
+// String returns the Go name of the wire type
+func (w WireType) String() string {
+	switch w {
+	case WireUint16:
+		return "uint16"
+	case WireUint32:
+		return "uint32"
+	case WireInt32:
+		return "int32"
+	case WireInt64:
+		return "int64"
+	default:
+		return fmt.Sprintf("WireType(%d)", int(w))
+	}
+}
+
+// Convert returns n as the Go type of the wire type, or ErrOverflow if it
+// does not fit
+func (w WireType) Convert(n int64) (interface{}, error) {
+	switch w {
+	case WireUint16:
+		if n >= 0 && n <= math.MaxUint16 {
+			return uint16(n), nil
+		}
+	case WireUint32:
+		if n >= 0 && n <= math.MaxUint32 {
+			return uint32(n), nil
+		}
+	case WireInt32:
+		if n >= math.MinInt32 && n <= math.MaxInt32 {
+			return int32(n), nil
+		}
+	case WireInt64:
+		return n, nil
+	default:
+		return nil, fmt.Errorf("unknown wire type %d", int(w))
+	}
+	return nil, fmt.Errorf("%w: %d does not fit %s", ErrOverflow, n, w)
+}
+
+// Direction tells which side sends a field's value. Requests the service
+// sends to make the nRF52 report a value, like the version request, do not
+// count.
+type Direction int
+
+const (
+	Inbound  Direction = 1 << iota // Sent by the nRF52
+	Outbound                       // Sent to the nRF52
+	Both     = Inbound | Outbound
+)
+
+// String returns "inbound", "outbound" or "both"
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "inbound"
+	case Outbound:
+		return "outbound"
+	case Both:
+		return "both"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+// ParseDirection returns the direction of a name as returned by Direction.String
+func ParseDirection(name string) (Direction, error) {
+	for _, d := range []Direction{Inbound, Outbound, Both} {
+		if d.String() == name {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown direction %q, expected inbound, outbound or both", name)
+}
+
+// EnumValue is one entry of an Enum
+type EnumValue struct {
+	Wire int    // Value on the wire
+	Name string // Value in Redis
+}
+
+// Enum maps integer wire values to the strings stored in Redis. Several
+// names may share a wire value; the first one is used for received values.
+type Enum []EnumValue
+
+// Name returns the name of a wire value
+func (e Enum) Name(wire int) (string, bool) {
+	for _, v := range e {
+		if v.Wire == wire {
+			return v.Name, true
+		}
+	}
+	return "", false
+}
+
+// Wire returns the wire value of a name
+func (e Enum) Wire(name string) (int, bool) {
+	for _, v := range e {
+		if v.Name == name {
+			return v.Wire, true
+		}
+	}
+	return 0, false
+}
+
+// Field describes one value exchanged with the nRF52. Inbound fields with a
+// Redis key are stored there when received; outbound fields with a Redis key
+// are sent whenever that Redis field changes.
+type Field struct {
+	Type         MessageType
+	SubType      SubType // Relative to Type
+	Name         string  // Human readable name, unique per message type
+	Kind         ValueKind
+	Wire         WireType // Integer type of integer and bool fields on the wire
+	Direction    Direction
+	RedisKey     string // Hash holding the value, empty for none
+	RedisField   string
+	Publish      bool   // Publish the Redis field after storing a received value
+	Enum         Enum   // Optional mapping of integer values to Redis strings
+	EnumFallback string // Printf format (one %d) of integers not in Enum, instead of Default
+	Default      string // Redis value used when the stored or received one is missing or unknown
+	AnySubType   bool   // With SubType 0: matches every subtype of Type that has no field of its own
+}
+
+// Key returns the absolute subtype, the key of the value in the CBOR map
+func (f *Field) Key() uint16 {
+	return uint16(f.Type) + uint16(f.SubType)
+}
+
+// String returns the message type and name, e.g. "CB_BATTERY charge"
+func (f *Field) String() string {
+	return f.Type.String() + " " + f.Name
+}
+
+// Decode converts a value received from CBOR to the Go type of the field
+func (f *Field) Decode(value interface{}) (interface{}, error) {
+	switch f.Kind {
+	case KindInt:
+		return ToInt(value)
+	case KindBool:
+		n, err := ToInt(value)
+		return n != 0, err
+	case KindString:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case []byte:
+			return string(v), nil
+		}
+		return nil, fmt.Errorf("expected a string, got %T", value)
+	case KindArray:
+		if v, ok := value.([]interface{}); ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("expected an array, got %T", value)
+	case KindBytes:
+		if v, ok := value.([]byte); ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("expected a byte string, got %T", value)
+	default:
+		return value, nil
+	}
+}
+
+// Format returns the Redis representation of a decoded value. Integers not
+// in the enum are formatted with EnumFallback, or stored as Default, or as a
+// number if there is neither.
+func (f *Field) Format(value interface{}) string {
+	switch v := value.(type) {
+	case int:
+		if f.Enum != nil {
+			if name, ok := f.Enum.Name(v); ok {
+				return name
+			}
+			if f.EnumFallback != "" {
+				return fmt.Sprintf(f.EnumFallback, v)
+			}
+			if f.Default != "" {
+				return f.Default
+			}
+		}
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	case []byte:
+		return hex.EncodeToString(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Encode converts a Redis value to the value sent to the nRF52: integer and
+// bool fields are converted to their wire type, failing with ErrOverflow
+// instead of wrapping around, string fields are sent as they are and byte
+// fields are decoded from hex.
+func (f *Field) Encode(value string) (interface{}, error) {
+	switch f.Kind {
+	case KindInt:
+		if wire, ok := f.Enum.Wire(value); ok {
+			return f.Wire.Convert(int64(wire))
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			if errors.Is(err, strconv.ErrRange) {
+				return nil, fmt.Errorf("%w: %s for %s", ErrOverflow, value, f)
+			}
+			return nil, fmt.Errorf("invalid value %q for %s", value, f)
+		}
+		v, err := f.Wire.Convert(n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		return v, nil
+	case KindBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s", value, f)
+		}
+		if b {
+			return f.Wire.Convert(1)
+		}
+		return f.Wire.Convert(0)
+	case KindString:
+		return value, nil
+	case KindBytes:
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: %v", value, f, err)
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("%s values cannot be sent", f.Kind)
+	}
+}
+
+// ToInt converts any of the integer types CBOR decodes to into an int
+func ToInt(value interface{}) (int, error) {
+	const maxInt = int64(^uint(0) >> 1)
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int8:
+		return int(v), nil
+	case int16:
+		return int(v), nil
+	case int32:
+		return int(v), nil
+	case int64:
+		if v <= maxInt && v >= -maxInt-1 {
+			return int(v), nil
+		}
+	case uint8:
+		return int(v), nil
+	case uint16:
+		return int(v), nil
+	case uint32:
+		if int64(v) <= maxInt {
+			return int(v), nil
+		}
+	case uint:
+		if uint64(v) <= uint64(maxInt) {
+			return int(v), nil
+		}
+	case uint64:
+		if v <= uint64(maxInt) {
+			return int(v), nil
+		}
+	default:
+		return 0, fmt.Errorf("expected an integer, got %T", value)
+	}
+	return 0, fmt.Errorf("integer %v out of range", value)
+}
+
+var (
+	registry   []*Field              // In registration order
+	byKey      = map[uint16]*Field{} // By absolute subtype
+	fixedKinds = map[uint16]bool{}   // Absolute subtypes whose kind a mapping cannot change
+)
+
+// FixKind keeps a mapping file from changing the kind of the field with the
+// given absolute subtype, e.g. because a handler relies on it. It must be
+// called before LoadMapping.
+func FixKind(key uint16) {
+	fixedKinds[key] = true
+}
+
+// Register adds a field to the registry. Absolute subtypes are unique across
+// all message types, so registering one twice panics. It returns the
+// registered field.
+func Register(f Field) *Field {
+	if f.Name == "" {
+		panic(fmt.Sprintf("ble: field 0x%04x has no name", f.Key()))
+	}
+	if other, ok := byKey[f.Key()]; ok {
+		panic(fmt.Sprintf("ble: %s and %s share subtype 0x%04x", other, &f, f.Key()))
+	}
+	byKey[f.Key()] = &f
+	registry = append(registry, &f)
+	return &f
+}
+
+// Lookup returns the field of a received value. Values of message types
+// with an AnySubType field fall back to that field.
+func Lookup(msgType MessageType, absSubType uint16) (*Field, bool) {
+	if f, ok := byKey[absSubType]; ok {
+		return f, true
+	}
+	if f, ok := byKey[uint16(msgType)]; ok && f.Type == msgType && f.AnySubType {
+		return f, true
+	}
+	return nil, false
+}
+
+// FieldByName returns the field of a message type with the given name
+func FieldByName(msgType MessageType, name string) (*Field, bool) {
+	for _, f := range registry {
+		if f.Type == msgType && f.Name == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// Fields returns all registered fields in registration order
+func Fields() []*Field {
+	return registry
+}
+
+// OutboundFields returns the outbound fields stored in the given Redis hash
+// field, in registration order
+func OutboundFields(redisKey, redisField string) []*Field {
+	var fields []*Field
+	for _, f := range registry {
+		if f.Direction&Outbound != 0 && f.RedisKey == redisKey && f.RedisField == redisField {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// OutboundRedisKeys returns the Redis hashes holding outbound fields, in
+// registration order
+func OutboundRedisKeys() []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, f := range registry {
+		if f.Direction&Outbound != 0 && f.RedisKey != "" && !seen[f.RedisKey] {
+			seen[f.RedisKey] = true
+			keys = append(keys, f.RedisKey)
+		}
+	}
+	return keys
+}
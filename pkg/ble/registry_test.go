@@ -0,0 +1,158 @@
+package ble
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func mustField(t *testing.T, msgType MessageType, name string) *Field {
+	t.Helper()
+	f, ok := FieldByName(msgType, name)
+	if !ok {
+		t.Fatalf("no field %q for %s", name, msgType)
+	}
+	return f
+}
+
+func TestLookup(t *testing.T) {
+	f, ok := Lookup(TypeBatteryInfo, 0x0061)
+	if !ok || f.Name != "charge" {
+		t.Errorf("0x0061: got %v, want CB_BATTERY charge", f)
+	}
+	f, ok = Lookup(TypePowerMux, 0x0105)
+	if !ok || f.Name != "selected-input" {
+		t.Errorf("0x0105: got %v, want POWER_MUX selected-input", f)
+	}
+	f, ok = Lookup(TypeEvent, 0x0003)
+	if !ok || f.Name != "event" {
+		t.Errorf("0x0003: got %v, want EVENT event", f)
+	}
+	if f, ok := Lookup(TypeBLEDebug, 0xA0F0); ok {
+		t.Errorf("0xA0F0: got %v, want no field", f)
+	}
+}
+
+func TestEnumRoundTrip(t *testing.T) {
+	f := mustField(t, TypeVehicleState, "state")
+	for _, v := range f.Enum {
+		wire, err := f.Encode(v.Name)
+		if err != nil || wire != uint16(v.Wire) {
+			t.Errorf("Encode(%q) = %v, %v; want %d", v.Name, wire, err, v.Wire)
+		}
+		if name := f.Format(v.Wire); name != v.Name {
+			t.Errorf("Format(%d) = %q, want %q", v.Wire, name, v.Name)
+		}
+	}
+	if name := f.Format(42); name != f.Default {
+		t.Errorf("Format(42) = %q, want the default %q", name, f.Default)
+	}
+	if _, err := f.Encode("flying"); err == nil {
+		t.Errorf("Encode accepted an unknown state")
+	}
+
+	// Several names share a wire value; received values use the first
+	pm := mustField(t, TypePowerManagement, "state")
+	if wire, _ := pm.Encode("hibernating-l2"); wire != uint16(2) {
+		t.Errorf("Encode(hibernating-l2) = %v, want 2", wire)
+	}
+	if name := pm.Format(2); name != "hibernating" {
+		t.Errorf("Format(2) = %q, want hibernating", name)
+	}
+
+	// Unknown part numbers keep their value
+	partNo := FieldBatteryInfoPartNo
+	if name := partNo.Format(6); name != "MAX17302" {
+		t.Errorf("Format(6) = %q, want MAX17302", name)
+	}
+	if name := partNo.Format(8); name != "MAX1730X (8)" {
+		t.Errorf("Format(8) = %q, want MAX1730X (8)", name)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	present := mustField(t, TypeBatteryInfo, "present")
+	v, err := present.Decode(uint64(1))
+	if err != nil || v != true || present.Format(v) != "true" {
+		t.Errorf("Decode(1) = %v, %v; want true", v, err)
+	}
+	if wire, err := mustField(t, TypeBattery, "slot1 present").Encode("false"); err != nil || wire != uint16(0) {
+		t.Errorf("Encode(false) = %v, %v; want 0", wire, err)
+	}
+
+	serial := mustField(t, TypeBatteryInfo, "serial-number")
+	if v, err := serial.Decode([]byte("SN1")); err != nil || v != "SN1" {
+		t.Errorf("Decode([]byte) = %v, %v; want SN1", v, err)
+	}
+	if _, err := serial.Decode(uint64(1)); err == nil {
+		t.Errorf("string field accepted an integer")
+	}
+
+	charge := mustField(t, TypeBatteryInfo, "charge")
+	if _, err := charge.Decode(uint64(1) << 63); err == nil {
+		t.Errorf("integer field accepted an out of range value")
+	}
+	if _, err := charge.Decode("87"); err == nil {
+		t.Errorf("integer field accepted a string")
+	}
+}
+
+func TestEncodeOverflow(t *testing.T) {
+	mileage := mustField(t, TypeScooterInfo, "mileage")
+	if v, err := mileage.Encode("70000"); err != nil || v != uint32(70000) {
+		t.Errorf("Encode(70000) = %v, %v; want uint32 70000", v, err)
+	}
+	for _, value := range []string{"4294967296", "-1", "99999999999999999999"} {
+		if v, err := mileage.Encode(value); !errors.Is(err, ErrOverflow) {
+			t.Errorf("Encode(%s) = %v, %v; want ErrOverflow", value, v, err)
+		}
+	}
+	if _, err := mustField(t, TypeBattery, "slot1 charge").Encode("65536"); !errors.Is(err, ErrOverflow) {
+		t.Errorf("uint16 field accepted 65536: %v", err)
+	}
+
+	for _, tc := range []struct {
+		wire WireType
+		n    int64
+		want interface{}
+	}{
+		{WireInt32, -5, int32(-5)},
+		{WireInt64, -1 << 40, int64(-1 << 40)},
+		{WireUint16, 65535, uint16(65535)},
+	} {
+		if v, err := tc.wire.Convert(tc.n); err != nil || v != tc.want {
+			t.Errorf("%s.Convert(%d) = %v, %v; want %v", tc.wire, tc.n, v, err, tc.want)
+		}
+	}
+	if _, err := WireInt32.Convert(1 << 31); !errors.Is(err, ErrOverflow) {
+		t.Errorf("int32 accepted 1<<31: %v", err)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	f := &Field{Type: TypeBLEParam, SubType: 0x10, Name: "test", Kind: KindBytes}
+	v, err := f.Encode("c0ffee")
+	if err != nil || !bytes.Equal(v.([]byte), []byte{0xc0, 0xff, 0xee}) {
+		t.Errorf("Encode(c0ffee) = %v, %v", v, err)
+	}
+	if _, err := f.Encode("xyz"); err == nil {
+		t.Errorf("Encode accepted invalid hex")
+	}
+	if s := f.Format([]byte{0x01, 0xab}); s != "01ab" {
+		t.Errorf("Format = %q, want 01ab", s)
+	}
+}
+
+func TestOutboundFields(t *testing.T) {
+	fields := OutboundFields("battery:1", "charge")
+	if len(fields) != 1 || fields[0].SubType != TypeBatterySlot2Charge {
+		t.Errorf("battery:1 charge: got %v", fields)
+	}
+	for _, f := range Fields() {
+		if f.Direction&Outbound != 0 && f.RedisKey != "" {
+			if _, err := f.Encode(f.Default); err != nil && f.Kind != KindString {
+				t.Errorf("%s: default %q cannot be sent: %v", f, f.Default, err)
+			}
+		}
+	}
+}
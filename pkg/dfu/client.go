@@ -0,0 +1,181 @@
+package dfu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"time"
+)
+
+// Conn is the serial link to the bootloader
+type Conn interface {
+	io.ReadWriter
+	SetReadDeadline(t time.Time) error
+}
+
+// ResponseTimeout bounds the wait for a bootloader response. Create and
+// Execute erase and write flash and can take a while.
+const ResponseTimeout = 5 * time.Second
+
+// Client drives a DFU transfer
+type Client struct {
+	conn   Conn
+	reader *SLIPReader
+	mtu    int
+	sent   int // Bytes of the package written so far
+	total  int
+
+	// Progress, if set, is called after every chunk written to the bootloader
+	// with the number of bytes of the whole package sent so far
+	Progress func(sent, total int)
+}
+
+// NewClient creates a client talking to the bootloader over conn
+func NewClient(conn Conn) *Client {
+	return &Client{
+		conn:   conn,
+		reader: NewSLIPReader(bufio.NewReader(conn)),
+	}
+}
+
+// request sends a request and returns the payload of its response
+func (c *Client) request(timeout time.Duration, op byte, params ...byte) ([]byte, error) {
+	if err := WritePacket(c.conn, append([]byte{op}, params...)); err != nil {
+		return nil, fmt.Errorf("failed to send opcode 0x%02x: %v", op, err)
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	for {
+		packet, err := c.reader.ReadPacket()
+		if err != nil {
+			return nil, fmt.Errorf("no response to opcode 0x%02x: %v", op, err)
+		}
+		// Anything else is left over from the application firmware
+		if len(packet) < 3 || packet[0] != OpResponse || packet[1] != op {
+			continue
+		}
+		if packet[2] != ResSuccess {
+			resErr := &ResultError{Opcode: op, Result: packet[2]}
+			if packet[2] == ResExtendedError && len(packet) > 3 {
+				resErr.Extended = packet[3]
+			}
+			return nil, resErr
+		}
+		return packet[3:], nil
+	}
+}
+
+// WaitForBootloader pings until the bootloader answers or timeout expires
+func (c *Client) WaitForBootloader(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var id byte
+	for {
+		id++
+		_, err := c.request(250*time.Millisecond, OpPing, id)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("bootloader not responding: %v", err)
+		}
+	}
+}
+
+// Update transfers every image of pkg. The bootloader activates the new
+// firmware and resets after the last image has been executed.
+func (c *Client) Update(pkg *Package) error {
+	// No packet receipt notifications, the CRC is checked after every object
+	if _, err := c.request(ResponseTimeout, OpSetPRN, 0, 0); err != nil {
+		return err
+	}
+
+	resp, err := c.request(ResponseTimeout, OpGetMTU)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 2 {
+		return fmt.Errorf("short MTU response: %x", resp)
+	}
+	c.mtu = int(binary.LittleEndian.Uint16(resp))
+	if c.mtu < 8 {
+		return fmt.Errorf("bootloader reported an unusable MTU of %d", c.mtu)
+	}
+
+	c.sent, c.total = 0, pkg.Size()
+	for _, img := range pkg.Images {
+		log.Printf("DFU: sending %s (init packet %d bytes, firmware %d bytes)", img.Name, len(img.InitPacket), len(img.Firmware))
+		if err := c.sendObjects(ObjCommand, img.InitPacket); err != nil {
+			return fmt.Errorf("%s init packet: %w", img.Name, err)
+		}
+		if err := c.sendObjects(ObjData, img.Firmware); err != nil {
+			return fmt.Errorf("%s firmware: %w", img.Name, err)
+		}
+	}
+	return nil
+}
+
+// sendObjects splits data into objects of the size the bootloader accepts,
+// verifies the CRC of each one and executes it
+func (c *Client) sendObjects(objType byte, data []byte) error {
+	resp, err := c.request(ResponseTimeout, OpSelect, objType)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 12 {
+		return fmt.Errorf("short select response: %x", resp)
+	}
+	maxSize := int(binary.LittleEndian.Uint32(resp))
+	if maxSize == 0 {
+		return fmt.Errorf("bootloader reported a maximum object size of 0")
+	}
+	if objType == ObjCommand && len(data) > maxSize {
+		return fmt.Errorf("init packet of %d bytes exceeds the maximum of %d", len(data), maxSize)
+	}
+
+	// Each write is one SLIP packet of at most MTU bytes after escaping
+	chunkSize := (c.mtu-1)/2 - 1
+
+	for offset := 0; offset < len(data); offset += maxSize {
+		object := data[offset:min(offset+maxSize, len(data))]
+
+		params := binary.LittleEndian.AppendUint32([]byte{objType}, uint32(len(object)))
+		if _, err := c.request(ResponseTimeout, OpCreate, params...); err != nil {
+			return err
+		}
+
+		for i := 0; i < len(object); i += chunkSize {
+			chunk := object[i:min(i+chunkSize, len(object))]
+			if err := WritePacket(c.conn, append([]byte{OpWrite}, chunk...)); err != nil {
+				return fmt.Errorf("failed to write data: %v", err)
+			}
+			c.sent += len(chunk)
+			if c.Progress != nil {
+				c.Progress(c.sent, c.total)
+			}
+		}
+
+		resp, err := c.request(ResponseTimeout, OpCalcCRC)
+		if err != nil {
+			return err
+		}
+		if len(resp) < 8 {
+			return fmt.Errorf("short CRC response: %x", resp)
+		}
+		sent := offset + len(object)
+		gotOffset := int(binary.LittleEndian.Uint32(resp))
+		gotCRC := binary.LittleEndian.Uint32(resp[4:])
+		if wantCRC := crc32.ChecksumIEEE(data[:sent]); gotOffset != sent || gotCRC != wantCRC {
+			return fmt.Errorf("transfer check failed: bootloader has %d bytes with CRC 0x%08x, sent %d bytes with CRC 0x%08x",
+				gotOffset, gotCRC, sent, wantCRC)
+		}
+
+		if _, err := c.request(ResponseTimeout, OpExecute); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package dfu
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestClientRejected(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	go func() {
+		// Reject the first request with an extended error
+		packet, err := NewSLIPReader(bufio.NewReader(b)).ReadPacket()
+		if err != nil {
+			return
+		}
+		WritePacket(b, []byte{OpResponse, packet[0], ResExtendedError, 0x07})
+	}()
+
+	err := NewClient(a).Update(&Package{Images: []Image{{Name: "application", InitPacket: []byte{1}, Firmware: []byte{1}}}})
+	var resErr *ResultError
+	if !errors.As(err, &resErr) || resErr.Opcode != OpSetPRN || resErr.Extended != 0x07 {
+		t.Fatalf("got %v, want the extended error of the PRN request", err)
+	}
+}
@@ -0,0 +1,193 @@
+package dfu
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Image is one firmware image of a DFU package
+type Image struct {
+	Name       string // Manifest key, e.g. "application"
+	InitPacket []byte // Signed init packet (.dat)
+	Firmware   []byte // Firmware binary (.bin)
+}
+
+// Package is a DFU package as generated by nrfutil pkg generate
+type Package struct {
+	Images []Image // In the order they have to be transferred
+}
+
+// manifest keys in transfer order; a combined SoftDevice and bootloader
+// image goes first, the application always last
+var manifestImages = []string{"softdevice_bootloader", "softdevice", "bootloader", "application"}
+
+type manifestFile struct {
+	Manifest map[string]struct {
+		BinFile string `json:"bin_file"`
+		DatFile string `json:"dat_file"`
+	} `json:"manifest"`
+}
+
+// OpenPackage reads and validates a DFU package zip
+func OpenPackage(path string) (*Package, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DFU package: %v", err)
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	read := func(name string) ([]byte, error) {
+		f, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("DFU package has no %s", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+
+	data, err := read("manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	var m manifestFile
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest.json: %v", err)
+	}
+
+	pkg := &Package{}
+	for _, name := range manifestImages {
+		entry, ok := m.Manifest[name]
+		if !ok {
+			continue
+		}
+		img := Image{Name: name}
+		if img.InitPacket, err = read(entry.DatFile); err != nil {
+			return nil, err
+		}
+		if img.Firmware, err = read(entry.BinFile); err != nil {
+			return nil, err
+		}
+		if len(img.InitPacket) == 0 || len(img.Firmware) == 0 {
+			return nil, fmt.Errorf("empty %s image in DFU package", name)
+		}
+		pkg.Images = append(pkg.Images, img)
+	}
+	if len(pkg.Images) == 0 {
+		return nil, fmt.Errorf("DFU package contains no images")
+	}
+	return pkg, nil
+}
+
+// Size returns the number of bytes that will be transferred
+func (p *Package) Size() int {
+	n := 0
+	for _, img := range p.Images {
+		n += len(img.InitPacket) + len(img.Firmware)
+	}
+	return n
+}
+
+// Application returns the application image, or nil if the package has none
+func (p *Package) Application() *Image {
+	for i := range p.Images {
+		if p.Images[i].Name == "application" {
+			return &p.Images[i]
+		}
+	}
+	return nil
+}
+
+// FirmwareVersion returns the fw_version field of the image's init packet
+func (img *Image) FirmwareVersion() (uint32, error) {
+	// Packet{signed_command=2{command=1}} or Packet{command=1}, then
+	// Command{init=2}, InitCommand{fw_version=1}
+	cmd, err := protoField(img.InitPacket, 2)
+	if err == nil {
+		cmd, err = protoField(cmd, 1)
+	} else {
+		cmd, err = protoField(img.InitPacket, 1)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("init packet has no command: %v", err)
+	}
+	initCmd, err := protoField(cmd, 2)
+	if err != nil {
+		return 0, fmt.Errorf("init packet has no init command: %v", err)
+	}
+	return protoVarint(initCmd, 1)
+}
+
+// protoField returns the first length-delimited field with the given number
+func protoField(msg []byte, field uint64) ([]byte, error) {
+	_, value, err := protoFind(msg, field, 2)
+	return value, err
+}
+
+// protoVarint returns the first varint field with the given number
+func protoVarint(msg []byte, field uint64) (uint32, error) {
+	v, _, err := protoFind(msg, field, 0)
+	return uint32(v), err
+}
+
+// protoFind walks a protobuf message and returns the first field with the
+// given number and wire type: varints as v, length-delimited fields as b
+func protoFind(msg []byte, field uint64, wireType uint64) (v uint64, b []byte, err error) {
+	for len(msg) > 0 {
+		key, n := readVarint(msg)
+		if n == 0 {
+			return 0, nil, fmt.Errorf("truncated protobuf key")
+		}
+		msg = msg[n:]
+		num, wire := key>>3, key&7
+
+		var size int
+		switch wire {
+		case 0: // varint
+			if v, size = readVarint(msg); size == 0 {
+				return 0, nil, fmt.Errorf("truncated protobuf field %d", num)
+			}
+		case 1: // 64-bit
+			size = 8
+		case 2: // length-delimited
+			l, n := readVarint(msg)
+			if n == 0 || uint64(len(msg)-n) < l {
+				return 0, nil, fmt.Errorf("truncated protobuf field %d", num)
+			}
+			b, size = msg[n:n+int(l)], n+int(l)
+		case 5: // 32-bit
+			size = 4
+		default:
+			return 0, nil, fmt.Errorf("unsupported protobuf wire type %d", wire)
+		}
+		if size > len(msg) {
+			return 0, nil, fmt.Errorf("truncated protobuf field %d", num)
+		}
+		if num == field && wire == wireType {
+			return v, b, nil
+		}
+		msg = msg[size:]
+	}
+	return 0, nil, fmt.Errorf("field %d not found", field)
+}
+
+// readVarint decodes a protobuf varint, returning 0 bytes read on error
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
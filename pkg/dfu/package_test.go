@@ -0,0 +1,144 @@
+package dfu
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// initPacket returns an unsigned init packet with the given fw_version:
+// Packet{command=1{init=2{fw_version=1}}}
+func initPacket(version byte) []byte {
+	initCmd := []byte{0x08, version}
+	cmd := append([]byte{0x12, byte(len(initCmd))}, initCmd...)
+	return append([]byte{0x0a, byte(len(cmd))}, cmd...)
+}
+
+// writePackage writes a zip with the given files and returns its path
+func writePackage(t *testing.T, files map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dfu.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testManifest = `{"manifest": {
+	"application": {"bin_file": "app.bin", "dat_file": "app.dat"},
+	"softdevice_bootloader": {"bin_file": "sd_bl.bin", "dat_file": "sd_bl.dat"}
+}}`
+
+func TestOpenPackage(t *testing.T) {
+	path := writePackage(t, map[string]string{
+		"manifest.json": testManifest,
+		"app.bin":       "application firmware",
+		"app.dat":       string(initPacket(42)),
+		"sd_bl.bin":     "softdevice and bootloader",
+		"sd_bl.dat":     "sd init",
+	})
+	pkg, err := OpenPackage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkg.Images) != 2 || pkg.Images[0].Name != "softdevice_bootloader" || pkg.Images[1].Name != "application" {
+		t.Fatalf("got images %+v, want the SoftDevice and bootloader before the application", pkg.Images)
+	}
+	if want := len("application firmware") + 6 + len("softdevice and bootloader") + len("sd init"); pkg.Size() != want {
+		t.Errorf("got size %d, want %d", pkg.Size(), want)
+	}
+	app := pkg.Application()
+	if app == nil || string(app.Firmware) != "application firmware" {
+		t.Fatalf("got application %+v", app)
+	}
+	if v, err := app.FirmwareVersion(); err != nil || v != 42 {
+		t.Errorf("got firmware version %d, %v; want 42", v, err)
+	}
+}
+
+func TestOpenPackageInvalid(t *testing.T) {
+	valid := map[string]string{
+		"manifest.json": testManifest,
+		"app.bin":       "application firmware",
+		"app.dat":       "app init",
+		"sd_bl.bin":     "softdevice and bootloader",
+		"sd_bl.dat":     "sd init",
+	}
+	for _, tc := range []struct {
+		name    string
+		change  map[string]string // Files to replace, nil content to remove
+		wantErr string
+	}{
+		{"no manifest", map[string]string{"manifest.json": removed}, "no manifest.json"},
+		{"corrupt manifest", map[string]string{"manifest.json": `{"manifest": {`}, "invalid manifest.json"},
+		{"missing firmware", map[string]string{"app.bin": removed}, "no app.bin"},
+		{"missing init packet", map[string]string{"sd_bl.dat": removed}, "no sd_bl.dat"},
+		{"empty firmware", map[string]string{"app.bin": ""}, "empty application image"},
+		{"no images", map[string]string{"manifest.json": `{"manifest": {"unknown": {}}}`}, "no images"},
+	} {
+		files := make(map[string]string)
+		for name, content := range valid {
+			files[name] = content
+		}
+		for name, content := range tc.change {
+			if content == removed {
+				delete(files, name)
+			} else {
+				files[name] = content
+			}
+		}
+		_, err := OpenPackage(writePackage(t, files))
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.wantErr)
+		}
+	}
+
+	// Not a zip at all, e.g. a truncated download
+	path := filepath.Join(t.TempDir(), "dfu.zip")
+	if err := os.WriteFile(path, []byte("PK\x03\x04 truncated"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenPackage(path); err == nil || !strings.Contains(err.Error(), "failed to open") {
+		t.Errorf("corrupt zip: got %v", err)
+	}
+}
+
+// removed marks a file left out of the package
+const removed = "\x00removed"
+
+func TestFirmwareVersion(t *testing.T) {
+	signed := append([]byte{0x12, byte(len(initPacket(7)))}, initPacket(7)...) // Packet{signed_command=2{...}}
+	signed = append(signed, 0x1a, 0x02, 0xaa, 0xbb)                            // Signature
+	for _, tc := range []struct {
+		name    string
+		packet  []byte
+		want    uint32
+		wantErr bool
+	}{
+		{"unsigned", initPacket(42), 42, false},
+		{"signed", signed, 7, false},
+		{"truncated", initPacket(42)[:3], 0, true},
+		{"no init command", []byte{0x0a, 0x02, 0x08, 0x01}, 0, true},
+		{"empty", nil, 0, true},
+	} {
+		got, err := (&Image{InitPacket: tc.packet}).FirmwareVersion()
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("%s: got %d, %v; want %d, error %v", tc.name, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Package dfu implements the host side of the Nordic nRF5 SDK secure serial
+// DFU protocol: SLIP framed requests to the bootloader over a UART.
+package dfu
+
+import "fmt"
+
+// Request opcodes
+const (
+	OpProtocolVersion byte = 0x00
+	OpCreate          byte = 0x01
+	OpSetPRN          byte = 0x02
+	OpCalcCRC         byte = 0x03
+	OpExecute         byte = 0x04
+	OpSelect          byte = 0x06
+	OpGetMTU          byte = 0x07
+	OpWrite           byte = 0x08
+	OpPing            byte = 0x09
+	OpAbort           byte = 0x0C
+	OpResponse        byte = 0x60
+)
+
+// Object types
+const (
+	ObjCommand byte = 0x01 // Init packet
+	ObjData    byte = 0x02 // Firmware image
+)
+
+// Result codes
+const (
+	ResInvalidOpcode      byte = 0x00
+	ResSuccess            byte = 0x01
+	ResOpNotSupported     byte = 0x02
+	ResInvalidParameter   byte = 0x03
+	ResInsufficientSpace  byte = 0x04
+	ResInvalidObject      byte = 0x05
+	ResUnsupportedType    byte = 0x07
+	ResOperationForbidden byte = 0x08
+	ResOperationFailed    byte = 0x0A
+	ResExtendedError      byte = 0x0B
+)
+
+// ResultError is returned when the bootloader rejects a request
+type ResultError struct {
+	Opcode   byte
+	Result   byte
+	Extended byte // Only set for ResExtendedError
+}
+
+func (e *ResultError) Error() string {
+	var name string
+	switch e.Result {
+	case ResInvalidOpcode:
+		name = "invalid opcode"
+	case ResOpNotSupported:
+		name = "operation not supported"
+	case ResInvalidParameter:
+		name = "invalid parameter"
+	case ResInsufficientSpace:
+		name = "insufficient resources"
+	case ResInvalidObject:
+		name = "invalid object"
+	case ResUnsupportedType:
+		name = "unsupported object type"
+	case ResOperationForbidden:
+		name = "operation not permitted"
+	case ResOperationFailed:
+		name = "operation failed"
+	case ResExtendedError:
+		name = fmt.Sprintf("extended error 0x%02x", e.Extended)
+	default:
+		name = fmt.Sprintf("result 0x%02x", e.Result)
+	}
+	return fmt.Sprintf("bootloader rejected opcode 0x%02x: %s", e.Opcode, name)
+}
@@ -0,0 +1,79 @@
+package dfu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// SLIP (RFC 1055) special bytes
+const (
+	slipEnd    = 0xC0
+	slipEsc    = 0xDB
+	slipEscEnd = 0xDC
+	slipEscEsc = 0xDD
+)
+
+// slipEncode returns data as one SLIP packet terminated by END
+func slipEncode(data []byte) []byte {
+	out := make([]byte, 0, len(data)+2)
+	for _, b := range data {
+		switch b {
+		case slipEnd:
+			out = append(out, slipEsc, slipEscEnd)
+		case slipEsc:
+			out = append(out, slipEsc, slipEscEsc)
+		default:
+			out = append(out, b)
+		}
+	}
+	return append(out, slipEnd)
+}
+
+// SLIPReader reads SLIP packets from a byte stream
+type SLIPReader struct {
+	r *bufio.Reader
+}
+
+// NewSLIPReader wraps r
+func NewSLIPReader(r *bufio.Reader) *SLIPReader {
+	return &SLIPReader{r: r}
+}
+
+// ReadPacket returns the next non-empty packet
+func (s *SLIPReader) ReadPacket() ([]byte, error) {
+	var packet []byte
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch b {
+		case slipEnd:
+			if len(packet) > 0 {
+				return packet, nil
+			}
+		case slipEsc:
+			next, err := s.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			switch next {
+			case slipEscEnd:
+				packet = append(packet, slipEnd)
+			case slipEscEsc:
+				packet = append(packet, slipEsc)
+			default:
+				return nil, fmt.Errorf("invalid SLIP escape sequence 0x%02x 0x%02x", b, next)
+			}
+		default:
+			packet = append(packet, b)
+		}
+	}
+}
+
+// WritePacket SLIP-encodes data into a single packet
+func WritePacket(w io.Writer, data []byte) error {
+	_, err := w.Write(slipEncode(data))
+	return err
+}
@@ -0,0 +1,72 @@
+package dfu
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSLIPRoundTrip(t *testing.T) {
+	packets := [][]byte{
+		{OpPing, 0x01},
+		{slipEnd},
+		{slipEsc},
+		{slipEsc, slipEscEnd, slipEnd, slipEscEsc}, // Escape bytes next to the escaped ones
+		{0x00, slipEnd, slipEnd, 0xff, slipEsc, slipEsc},
+		bytes.Repeat([]byte{slipEnd, 0x42, slipEsc}, 100),
+	}
+
+	var buf bytes.Buffer
+	for _, p := range packets {
+		if err := WritePacket(&buf, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := bytes.Count(buf.Bytes(), []byte{slipEnd}); n != len(packets) {
+		t.Fatalf("got %d END bytes, want one per packet: % x", n, buf.Bytes())
+	}
+
+	r := NewSLIPReader(bufio.NewReader(&buf))
+	for i, want := range packets {
+		got, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("packet %d: got % x, want % x", i, got, want)
+		}
+	}
+	if _, err := r.ReadPacket(); err != io.EOF {
+		t.Errorf("after the last packet: got %v, want EOF", err)
+	}
+}
+
+func TestSLIPEncode(t *testing.T) {
+	got := slipEncode([]byte{0x01, slipEnd, 0x02, slipEsc, 0x03})
+	want := []byte{0x01, slipEsc, slipEscEnd, 0x02, slipEsc, slipEscEsc, 0x03, slipEnd}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got % x, want % x", got, want)
+	}
+}
+
+func TestSLIPReader(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		stream  []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"leading END bytes", []byte{slipEnd, slipEnd, 0x01, slipEnd}, []byte{0x01}, false},
+		{"escaped END", []byte{slipEsc, slipEscEnd, slipEnd}, []byte{slipEnd}, false},
+		{"escaped ESC", []byte{slipEsc, slipEscEsc, slipEnd}, []byte{slipEsc}, false},
+		{"invalid escape", []byte{0x01, slipEsc, 0x02, slipEnd}, nil, true},
+		{"escape at end of stream", []byte{0x01, slipEsc}, nil, true},
+		{"unterminated", []byte{0x01, 0x02}, nil, true},
+	} {
+		got, err := NewSLIPReader(bufio.NewReader(bytes.NewReader(tc.stream))).ReadPacket()
+		if (err != nil) != tc.wantErr || !bytes.Equal(got, tc.want) {
+			t.Errorf("%s: got % x, %v; want % x, error %v", tc.name, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -14,25 +15,52 @@ import (
 type Client struct {
 	client *redis.Client
 	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	pubsubs    map[*redis.PubSub]bool // Open subscriptions, closed by Close
+	reconnects int                    // Subscriptions restored after a lost connection
 }
 
-// New creates a new Redis client
-func New(addr string, password string, db int) (*Client, error) {
+func newClient(addr string, password string, db int) *Client {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
 		DB:       db,
 	})
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		client:  client,
+		ctx:     ctx,
+		cancel:  cancel,
+		pubsubs: make(map[*redis.PubSub]bool),
+	}
+}
 
-	ctx := context.Background()
-	if err := client.Ping(ctx).Err(); err != nil {
+// New creates a new Redis client, failing if Redis does not answer
+func New(addr string, password string, db int) (*Client, error) {
+	c := newClient(addr, password, db)
+	if err := c.client.Ping(c.ctx).Err(); err != nil {
+		c.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
 	}
-
-	return &Client{
-		client: client,
-		ctx:    ctx,
-	}, nil
+	return c, nil
+}
+
+// Connect creates a new Redis client and waits until Redis answers,
+// retrying with exponential backoff
+func Connect(addr string, password string, db int) *Client {
+	c := newClient(addr, password, db)
+	backoff := ReconnectMinBackoff
+	for {
+		err := c.client.Ping(c.ctx).Err()
+		if err == nil {
+			return c
+		}
+		log.Printf("Failed to connect to Redis: %v (retrying in %v)", err, backoff)
+		time.Sleep(backoff)
+		backoff = nextBackoff(backoff)
+	}
 }
 
 // WriteString writes a string value to Redis
@@ -54,6 +82,11 @@ func (c *Client) WriteInt(key, field string, value int) error {
 	return c.client.HSet(c.ctx, key, field, value).Err()
 }
 
+// WriteHash writes several fields of a hash at once
+func (c *Client) WriteHash(key string, fields map[string]interface{}) error {
+	return c.client.HSet(c.ctx, key, fields).Err()
+}
+
 // WriteAndPublishInt writes an integer value to Redis and publishes it
 func (c *Client) WriteAndPublishInt(key, field string, value int) error {
 	pipe := c.client.Pipeline()
@@ -72,6 +105,11 @@ func (c *Client) GetString(key, field string) (string, error) {
 	return val, err
 }
 
+// GetAll gets all fields of a hash
+func (c *Client) GetAll(key string) (map[string]string, error) {
+	return c.client.HGetAll(c.ctx, key).Result()
+}
+
 // GetInt gets an integer value from Redis
 func (c *Client) GetInt(key, field string) (int, error) {
 	val, err := c.client.HGet(c.ctx, key, field).Result()
@@ -84,20 +122,19 @@ func (c *Client) GetInt(key, field string) (int, error) {
 	return strconv.Atoi(val)
 }
 
-// Subscribe subscribes to a Redis channel and returns a channel for messages
-func (c *Client) Subscribe(channel string) (<-chan *redis.Message, func()) {
-	pubsub := c.client.Subscribe(c.ctx, channel)
-	ch := pubsub.Channel()
-	return ch, func() { pubsub.Close() }
-}
-
 // Publish publishes a message to a Redis channel
 func (c *Client) Publish(channel string, message string) error {
 	return c.client.Publish(c.ctx, channel, message).Err()
 }
 
-// Close closes the Redis client connection
+// Close closes the Redis client connection and ends all subscriptions
 func (c *Client) Close() error {
+	c.cancel()
+	c.mu.Lock()
+	for pubsub := range c.pubsubs {
+		pubsub.Close()
+	}
+	c.mu.Unlock()
 	return c.client.Close()
 }
 
@@ -111,6 +148,10 @@ func (c *Client) GetStateString(key, field string) (string, error) {
 }
 
 // GetStateInt gets a state value from Redis and converts it to an integer if possible
+//
+// Deprecated: the numbers are the wire values of a few fields before they
+// were configurable. Read the value with GetString and encode it with the
+// ble.Field it belongs to, which follows the loaded mappings.
 func (c *Client) GetStateInt(key, field string) (int, error) {
 	val, err := c.GetStateString(key, field)
 	if err != nil {
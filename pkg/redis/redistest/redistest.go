@@ -0,0 +1,264 @@
+// Package redistest provides an in-memory Redis server for tests. It speaks
+// RESP2 and implements just the commands the service uses: PING, the hash
+// commands, PUBLISH, SUBSCRIBE and PSUBSCRIBE. Anything else is answered
+// with an error.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Server is an in-memory Redis listening on a local TCP port
+type Server struct {
+	l net.Listener
+
+	mu          sync.Mutex
+	hashes      map[string]map[string]string
+	published   []string // "channel payload"
+	conns       map[*conn]bool
+	ignorePings bool
+}
+
+// conn is a client connection and its subscriptions
+type conn struct {
+	net.Conn
+	wmu      sync.Mutex
+	channels map[string]bool
+	patterns map[string]bool
+}
+
+func (c *conn) write(reply string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := io.WriteString(c.Conn, reply)
+	return err
+}
+
+func (c *conn) subscribed() bool {
+	return len(c.channels)+len(c.patterns) > 0
+}
+
+// NewServer starts a server that is stopped when the test ends
+func NewServer(t testing.TB) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		l:      l,
+		hashes: make(map[string]map[string]string),
+		conns:  make(map[*conn]bool),
+	}
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c := &conn{Conn: nc, channels: make(map[string]bool), patterns: make(map[string]bool)}
+			s.mu.Lock()
+			s.conns[c] = true
+			s.mu.Unlock()
+			go s.serve(c)
+		}
+	}()
+	t.Cleanup(func() {
+		l.Close()
+		s.DropConnections()
+	})
+	return s
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// DropConnections closes every client connection, as if Redis restarted
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// IgnorePings makes the server leave PINGs of subscribed connections
+// unanswered, as if the connection stalled
+func (s *Server) IgnorePings(ignore bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ignorePings = ignore
+}
+
+// Hash returns a copy of the fields of a hash
+func (s *Server) Hash(key string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := make(map[string]string, len(s.hashes[key]))
+	for field, value := range s.hashes[key] {
+		hash[field] = value
+	}
+	return hash
+}
+
+// TakePublished returns and forgets the messages published so far, as
+// "channel payload"
+func (s *Server) TakePublished() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	published := s.published
+	s.published = nil
+	return published
+}
+
+func (s *Server) serve(c *conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+	in := bufio.NewReader(c)
+	for {
+		args, err := readCommand(in)
+		if err != nil {
+			return
+		}
+		if reply := s.exec(c, args); reply != "" {
+			if err := c.write(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(in *bufio.Reader) ([]string, error) {
+	line, err := in.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = in.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(in, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func array(items ...string) string {
+	return fmt.Sprintf("*%d\r\n%s", len(items), strings.Join(items, ""))
+}
+
+func integer(n int) string {
+	return fmt.Sprintf(":%d\r\n", n)
+}
+
+// exec runs a command and returns its reply, "" for none
+func (s *Server) exec(c *conn, args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		if !c.subscribed() {
+			return "+PONG\r\n"
+		}
+		if s.ignorePings {
+			return ""
+		}
+		return array(bulk("pong"), bulk(""))
+	case "HSET":
+		hash := s.hashes[args[1]]
+		if hash == nil {
+			hash = make(map[string]string)
+			s.hashes[args[1]] = hash
+		}
+		added := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := hash[args[i]]; !ok {
+				added++
+			}
+			hash[args[i]] = args[i+1]
+		}
+		return integer(added)
+	case "HGET":
+		if v, ok := s.hashes[args[1]][args[2]]; ok {
+			return bulk(v)
+		}
+		return "$-1\r\n"
+	case "HDEL":
+		deleted := 0
+		for _, field := range args[2:] {
+			if _, ok := s.hashes[args[1]][field]; ok {
+				delete(s.hashes[args[1]], field)
+				deleted++
+			}
+		}
+		return integer(deleted)
+	case "HGETALL":
+		var items []string
+		for field, value := range s.hashes[args[1]] {
+			items = append(items, bulk(field), bulk(value))
+		}
+		return array(items...)
+	case "PUBLISH":
+		s.published = append(s.published, args[1]+" "+args[2])
+		receivers := 0
+		for sub := range s.conns {
+			if sub.channels[args[1]] {
+				sub.write(array(bulk("message"), bulk(args[1]), bulk(args[2])))
+				receivers++
+			}
+			for pattern := range sub.patterns {
+				if ok, _ := path.Match(pattern, args[1]); ok {
+					sub.write(array(bulk("pmessage"), bulk(pattern), bulk(args[1]), bulk(args[2])))
+					receivers++
+				}
+			}
+		}
+		return integer(receivers)
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		kind, subs := "subscribe", c.channels
+		if strings.ToUpper(args[0]) == "PSUBSCRIBE" {
+			kind, subs = "psubscribe", c.patterns
+		}
+		var reply string
+		for _, name := range args[1:] {
+			subs[name] = true
+			reply += array(bulk(kind), bulk(name), integer(len(c.channels)+len(c.patterns)))
+		}
+		return reply
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+}
@@ -0,0 +1,148 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Reconnect backoff bounds
+const (
+	ReconnectMinBackoff = 500 * time.Millisecond
+	ReconnectMaxBackoff = 30 * time.Second
+)
+
+// HealthCheckInterval is how long a subscription may be idle before it is
+// checked with a PING. A connection that does not answer within another
+// interval is treated as lost.
+var HealthCheckInterval = 5 * time.Second
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > ReconnectMaxBackoff {
+		backoff = ReconnectMaxBackoff
+	}
+	return backoff
+}
+
+// Subscribe calls handler with every message published to the channels
+// until the client is closed. When the connection is lost, the subscription
+// is restored with exponential backoff; connHandler, if not nil, is called
+// with false when it is lost and with true every time it is established,
+// including the first time.
+func (c *Client) Subscribe(channels []string, handler func(channel, payload string), connHandler func(up bool)) {
+	c.subscribe(func() *redis.PubSub { return c.client.Subscribe(c.ctx, channels...) }, handler, connHandler)
+}
+
+// PSubscribe is Subscribe for channel patterns, see PSUBSCRIBE
+func (c *Client) PSubscribe(patterns []string, handler func(channel, payload string), connHandler func(up bool)) {
+	c.subscribe(func() *redis.PubSub { return c.client.PSubscribe(c.ctx, patterns...) }, handler, connHandler)
+}
+
+func (c *Client) subscribe(open func() *redis.PubSub, handler func(channel, payload string), connHandler func(up bool)) {
+	backoff := ReconnectMinBackoff
+	up, first := false, true
+
+	for c.ctx.Err() == nil {
+		pubsub := open()
+		c.mu.Lock()
+		c.pubsubs[pubsub] = true
+		c.mu.Unlock()
+
+		// The confirmation of the first channel tells the connection is up
+		_, err := pubsub.ReceiveTimeout(c.ctx, HealthCheckInterval)
+		if err == nil {
+			if !first {
+				log.Printf("Redis subscription restored")
+				c.mu.Lock()
+				c.reconnects++
+				c.mu.Unlock()
+			}
+			first, up, backoff = false, true, ReconnectMinBackoff
+			if connHandler != nil {
+				connHandler(true)
+			}
+			err = c.receive(pubsub, handler)
+		}
+
+		c.mu.Lock()
+		delete(c.pubsubs, pubsub)
+		c.mu.Unlock()
+		pubsub.Close()
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		if up {
+			up = false
+			if connHandler != nil {
+				connHandler(false)
+			}
+		}
+		log.Printf("Redis subscription lost: %v (resubscribing in %v)", err, backoff)
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// receive passes messages on to handler until the connection fails. An idle
+// connection is checked with a PING, which must be answered before the next
+// check.
+func (c *Client) receive(pubsub *redis.PubSub, handler func(channel, payload string)) error {
+	pinged := false
+	for {
+		msg, err := pubsub.ReceiveTimeout(c.ctx, HealthCheckInterval)
+		if err != nil {
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				return err
+			}
+			if pinged {
+				return fmt.Errorf("no answer to PING within %v", HealthCheckInterval)
+			}
+			if err := pubsub.Ping(c.ctx); err != nil {
+				return err
+			}
+			pinged = true
+			continue
+		}
+		pinged = false
+		if m, ok := msg.(*redis.Message); ok {
+			handler(m.Channel, m.Payload)
+		}
+	}
+}
+
+// KeyspaceChannel returns the channel of the keyspace notifications of a
+// key in the database of the client, e.g. __keyspace@0__:vehicle
+func (c *Client) KeyspaceChannel(key string) string {
+	return fmt.Sprintf("__keyspace@%d__:%s", c.client.Options().DB, key)
+}
+
+// KeyspaceEventsEnabled tells whether Redis sends keyspace notifications
+// for hash commands, see notify-keyspace-events
+func (c *Client) KeyspaceEventsEnabled() (bool, error) {
+	config, err := c.client.ConfigGet(c.ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return false, err
+	}
+	flags := config["notify-keyspace-events"]
+	return strings.Contains(flags, "K") && strings.ContainsAny(flags, "hA"), nil
+}
+
+// Reconnects returns how often a subscription was restored after the
+// connection to Redis was lost
+func (c *Client) Reconnects() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reconnects
+}
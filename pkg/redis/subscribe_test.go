@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/redis/redistest"
+)
+
+// subscription records what a Subscribe or PSubscribe call reported
+type subscription struct {
+	messages chan string // "channel payload"
+	states   chan bool
+}
+
+func newSubscription() *subscription {
+	return &subscription{messages: make(chan string, 8), states: make(chan bool, 8)}
+}
+
+func (s *subscription) handle(channel, payload string) { s.messages <- channel + " " + payload }
+func (s *subscription) connState(up bool)              { s.states <- up }
+
+func (s *subscription) wantState(t *testing.T, want bool) {
+	t.Helper()
+	select {
+	case up := <-s.states:
+		if up != want {
+			t.Fatalf("got connection state %v, want %v", up, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection state %v not reported", want)
+	}
+}
+
+func (s *subscription) wantMessage(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		if msg != want {
+			t.Fatalf("got message %q, want %q", msg, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message %q not received", want)
+	}
+}
+
+func TestSubscribeReconnect(t *testing.T) {
+	srv := redistest.NewServer(t)
+	c, err := New(srv.Addr(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sub := newSubscription()
+	go c.Subscribe([]string{"vehicle"}, sub.handle, sub.connState)
+	sub.wantState(t, true)
+	if err := c.Publish("vehicle", "state"); err != nil {
+		t.Fatal(err)
+	}
+	sub.wantMessage(t, "vehicle state")
+
+	srv.DropConnections()
+	sub.wantState(t, false)
+	sub.wantState(t, true)
+	if n := c.Reconnects(); n != 1 {
+		t.Errorf("got %d reconnects, want 1", n)
+	}
+	if err := c.Publish("vehicle", "seatbox:lock"); err != nil {
+		t.Fatal(err)
+	}
+	sub.wantMessage(t, "vehicle seatbox:lock")
+}
+
+// TestPSubscribeHealthCheck stalls an idle subscription: the unanswered
+// PING must be taken as a lost connection
+func TestPSubscribeHealthCheck(t *testing.T) {
+	interval := HealthCheckInterval
+	HealthCheckInterval = 50 * time.Millisecond
+	defer func() { HealthCheckInterval = interval }()
+
+	srv := redistest.NewServer(t)
+	c, err := New(srv.Addr(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	srv.IgnorePings(true)
+	sub := newSubscription()
+	go c.PSubscribe([]string{c.KeyspaceChannel("*")}, sub.handle, sub.connState)
+	sub.wantState(t, true)
+	sub.wantState(t, false)
+
+	srv.IgnorePings(false)
+	sub.wantState(t, true)
+	if n := c.Reconnects(); n != 1 {
+		t.Errorf("got %d reconnects, want 1", n)
+	}
+	if err := c.Publish(c.KeyspaceChannel("ble"), "hset"); err != nil {
+		t.Fatal(err)
+	}
+	sub.wantMessage(t, "__keyspace@0__:ble hset")
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// BatchConfig controls how outbound values are grouped into frames
+type BatchConfig struct {
+	// Delay is how long a value waits for other values of its message type
+	// before they are queued together, 0 to queue every value on its own
+	Delay time.Duration
+}
+
+// DefaultBatchConfig is used until SetBatchConfig is called
+var DefaultBatchConfig = BatchConfig{
+	Delay: 20 * time.Millisecond,
+}
+
+// batchWriter collects outbound values and sends the values of one message
+// type together, as a single {type: {absoluteSubtype: value, ...}} map per
+// frame. A newer value of a pending subtype replaces the older one.
+type batchWriter struct {
+	mu      sync.Mutex
+	cfg     BatchConfig
+	pending map[ble.MessageType]map[uint16]interface{}
+	order   []ble.MessageType // Message types in the order of their first pending value
+	timer   *time.Timer
+	holds   int // Values wait for the last release instead of the delay, see hold
+
+	send func(ble.MessageType, map[uint16]interface{}) error
+}
+
+func newBatchWriter(send func(ble.MessageType, map[uint16]interface{}) error) *batchWriter {
+	return &batchWriter{
+		cfg:     DefaultBatchConfig,
+		pending: make(map[ble.MessageType]map[uint16]interface{}),
+		send:    send,
+	}
+}
+
+// add queues a value; it is sent once the batch delay has passed or Flush is called
+func (b *batchWriter) add(messageType ble.MessageType, subType ble.SubType, value interface{}) error {
+	if err := checkUARTValue(value); err != nil {
+		return fmt.Errorf("cannot send 0x%04x: %v", absKey(messageType, subType), err)
+	}
+
+	b.mu.Lock()
+	if b.cfg.Delay <= 0 && b.holds == 0 {
+		b.mu.Unlock()
+		return b.send(messageType, map[uint16]interface{}{absKey(messageType, subType): value})
+	}
+	values, ok := b.pending[messageType]
+	if !ok {
+		values = make(map[uint16]interface{})
+		b.pending[messageType] = values
+		b.order = append(b.order, messageType)
+	}
+	values[absKey(messageType, subType)] = value
+	if b.timer == nil && b.holds == 0 {
+		b.timer = time.AfterFunc(b.cfg.Delay, b.flush)
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+// hold keeps all values added until release, whatever the batch delay. Holds
+// nest, e.g. for overlapping full state pushes: values are kept until every
+// hold is released.
+func (b *batchWriter) hold() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.holds++
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+}
+
+// release ends a hold and, once no other hold is left, sends the values
+// added meanwhile
+func (b *batchWriter) release() {
+	b.mu.Lock()
+	b.holds--
+	held := b.holds > 0
+	b.mu.Unlock()
+	if !held {
+		b.flush()
+	}
+}
+
+// flush sends all pending values, one message type after the other
+func (b *batchWriter) flush() {
+	b.mu.Lock()
+	pending, order := b.pending, b.order
+	b.pending = make(map[ble.MessageType]map[uint16]interface{})
+	b.order = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	for _, messageType := range order {
+		if err := b.send(messageType, pending[messageType]); err != nil {
+			log.Printf("Failed to send batched values of type 0x%04x: %v", uint16(messageType), err)
+		}
+	}
+}
+
+// SetBatchConfig changes how long outbound values wait to be batched
+func (s *Service) SetBatchConfig(cfg BatchConfig) {
+	s.batch.mu.Lock()
+	defer s.batch.mu.Unlock()
+	s.batch.cfg = cfg
+}
+
+// FlushBatch sends the pending outbound values without waiting for the batch delay
+func (s *Service) FlushBatch() {
+	s.batch.flush()
+}
+
+// writeUARTMessage queues a value of any type accepted by checkUARTValue.
+// Values of the same message type queued within the batch delay are sent in
+// one frame.
+func (s *Service) writeUARTMessage(messageType ble.MessageType, subType ble.SubType, value interface{}) error {
+	if s.usock.Load() == nil {
+		return fmt.Errorf("USOCK connection is not initialized")
+	}
+	return s.batch.add(messageType, subType, value)
+}
+
+// sendBatch queues the frames carrying the values of one message type. Values
+// that do not fit into one frame are split over several; a single value too
+// long for a frame is sent as a chunked transfer.
+func (s *Service) sendBatch(messageType ble.MessageType, values map[uint16]interface{}) error {
+	sock := s.usock.Load()
+	if sock == nil {
+		s.forgetSent(values)
+		return fmt.Errorf("USOCK connection is not initialized")
+	}
+	frames, err := splitBatch(messageType, values)
+	if err != nil {
+		s.forgetSent(values)
+		return err
+	}
+
+	// Use the lower byte of the MessageType as the Frame ID, matching observed logs.
+	frameID := byte(messageType & 0xFF)
+	for i, frame := range frames {
+		log.Printf("Sending message: Frame ID=0x%02x, CBOR Data=%s", frameID, hex.EncodeToString(frame.data))
+		if len(frame.data) > usock.MaxPayloadLength {
+			err = sock.WriteChunked(context.Background(), frameID, frame.data)
+			if err != nil {
+				s.sent.forgetKeys(frame.keys)
+			}
+		} else {
+			err = sock.PostFunc(messagePriority(messageType), frame.key, frameID, frame.data, s.frameSent(frameID, frame.keys))
+		}
+		if err != nil {
+			for _, f := range frames[i:] {
+				s.sent.forgetKeys(f.keys)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// frameSent returns the completion of a posted batch frame: the values of a
+// frame the nRF52 did not acknowledge are forgotten by the sent cache, so
+// they are not skipped as unchanged when sent again
+func (s *Service) frameSent(frameID byte, keys []uint16) func(error) {
+	return func(err error) {
+		if err != nil {
+			log.Printf("Frame ID 0x%02x with %d values not delivered: %v", frameID, len(keys), err)
+			s.sent.forgetKeys(keys)
+		}
+	}
+}
+
+// forgetSent drops the values of a batch that could not be sent from the
+// sent cache
+func (s *Service) forgetSent(values map[uint16]interface{}) {
+	keys := make([]uint16, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	s.sent.forgetKeys(keys)
+}
+
+// batchFrame is the CBOR payload of a batch, the absolute subtypes it
+// carries and the key it is coalesced by
+type batchFrame struct {
+	key  string
+	keys []uint16
+	data []byte
+}
+
+// splitBatch encodes values into as few payloads of at most
+// MaxPayloadLength bytes as possible, in ascending subtype order. A value
+// too long on its own gets a payload of its own. A queued frame is only
+// replaced by a newer one carrying the same subtypes.
+func splitBatch(messageType ble.MessageType, values map[uint16]interface{}) ([]batchFrame, error) {
+	keys := make([]uint16, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	encode := func(keys []uint16) (batchFrame, error) {
+		inner := make(map[uint16]interface{}, len(keys))
+		subtypes := make([]string, len(keys))
+		for i, k := range keys {
+			inner[k] = values[k]
+			subtypes[i] = fmt.Sprintf("%04x", k)
+		}
+		data, err := cbor.Marshal(map[uint16]map[uint16]interface{}{uint16(messageType): inner})
+		if err != nil {
+			return batchFrame{}, fmt.Errorf("failed to marshal CBOR: %w", err)
+		}
+		return batchFrame{
+			key:  fmt.Sprintf("%04x/%s", uint16(messageType), strings.Join(subtypes, ",")),
+			keys: append([]uint16(nil), keys...),
+			data: data,
+		}, nil
+	}
+
+	var frames []batchFrame
+	var current []uint16
+	var last batchFrame
+	for _, k := range keys {
+		frame, err := encode(append(current, k))
+		if err != nil {
+			return nil, err
+		}
+		if len(frame.data) > usock.MaxPayloadLength && len(current) > 0 {
+			frames = append(frames, last)
+			current = nil
+			if frame, err = encode([]uint16{k}); err != nil {
+				return nil, err
+			}
+		}
+		current = append(current, k)
+		last = frame
+	}
+	if len(current) > 0 {
+		frames = append(frames, last)
+	}
+	return frames, nil
+}
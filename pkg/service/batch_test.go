@@ -0,0 +1,148 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// recordBatches returns a batchWriter that records what it sends
+func recordBatches(delay time.Duration) (*batchWriter, func() []map[uint16]interface{}) {
+	var mu sync.Mutex
+	var sent []map[uint16]interface{}
+	b := newBatchWriter(func(_ ble.MessageType, values map[uint16]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, values)
+		return nil
+	})
+	b.cfg = BatchConfig{Delay: delay}
+	return b, func() []map[uint16]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return sent
+	}
+}
+
+func TestBatchWriterGroupsByType(t *testing.T) {
+	b, sent := recordBatches(time.Hour)
+	b.add(ble.TypeBattery, ble.TypeBatterySlot1Charge, uint16(10))
+	b.add(ble.TypeVehicleState, ble.TypeVehicleStateState, uint16(1))
+	b.add(ble.TypeBattery, ble.TypeBatterySlot2Charge, uint16(20))
+	b.add(ble.TypeBattery, ble.TypeBatterySlot1Charge, uint16(11)) // Replaces 10
+	if len(sent()) != 0 {
+		t.Fatalf("values sent before the delay")
+	}
+	b.flush()
+
+	got := sent()
+	if len(got) != 2 {
+		t.Fatalf("got %d batches, want 2", len(got))
+	}
+	battery := got[0]
+	if len(battery) != 2 || battery[absKey(ble.TypeBattery, ble.TypeBatterySlot1Charge)] != uint16(11) ||
+		battery[absKey(ble.TypeBattery, ble.TypeBatterySlot2Charge)] != uint16(20) {
+		t.Errorf("battery batch = %v", battery)
+	}
+	if len(got[1]) != 1 {
+		t.Errorf("vehicle batch = %v", got[1])
+	}
+}
+
+func TestBatchWriterDelay(t *testing.T) {
+	b, sent := recordBatches(10 * time.Millisecond)
+	b.add(ble.TypeBattery, ble.TypeBatterySlot1Charge, uint16(10))
+	b.add(ble.TypeBattery, ble.TypeBatterySlot2Charge, uint16(20))
+	deadline := time.Now().Add(time.Second)
+	for len(sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := sent(); len(got) != 1 || len(got[0]) != 2 {
+		t.Errorf("got batches %v, want one with both values", got)
+	}
+
+	b.cfg.Delay = 0
+	b.add(ble.TypeBattery, ble.TypeBatterySlot1Charge, uint16(12))
+	if got := sent(); len(got) != 2 || len(got[1]) != 1 {
+		t.Errorf("value not sent at once without a delay: %v", got)
+	}
+	if err := b.add(ble.TypeBattery, ble.TypeBatterySlot1Charge, 1.5); err == nil {
+		t.Errorf("unsupported value accepted")
+	}
+}
+
+func TestBatchWriterHold(t *testing.T) {
+	for _, delay := range []time.Duration{0, time.Millisecond} {
+		b, sent := recordBatches(delay)
+		b.hold()
+		b.add(ble.TypeBattery, ble.TypeBatterySlot1Charge, uint16(10))
+		time.Sleep(5 * time.Millisecond)
+		b.add(ble.TypeBattery, ble.TypeBatterySlot2Charge, uint16(20))
+		if len(sent()) != 0 {
+			t.Fatalf("delay %v: values sent while held", delay)
+		}
+		b.release()
+		if got := sent(); len(got) != 1 || len(got[0]) != 2 {
+			t.Errorf("delay %v: got batches %v, want one with both values", delay, got)
+		}
+	}
+}
+
+func TestBatchWriterNestedHold(t *testing.T) {
+	b, sent := recordBatches(0)
+	b.hold()
+	b.add(ble.TypeBattery, ble.TypeBatterySlot1Charge, uint16(10))
+	b.hold()
+	b.release()
+	if len(sent()) != 0 {
+		t.Fatalf("values sent before the outer hold was released")
+	}
+	b.add(ble.TypeBattery, ble.TypeBatterySlot2Charge, uint16(20))
+	b.release()
+	if got := sent(); len(got) != 1 || len(got[0]) != 2 {
+		t.Errorf("got batches %v, want one with both values", got)
+	}
+}
+
+func TestSplitBatch(t *testing.T) {
+	long := strings.Repeat("x", 400)
+	values := map[uint16]interface{}{}
+	for i := uint16(1); i <= 8; i++ {
+		values[0xA060+i] = long
+	}
+	values[0xA070] = strings.Repeat("y", usock.MaxPayloadLength) // Too long for any frame
+
+	frames, err := splitBatch(ble.TypeBatteryInfo, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[uint16]bool{}
+	for i, f := range frames {
+		var msg map[uint16]map[uint16]string
+		if err := cbor.Unmarshal(f.data, &msg); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		inner := msg[uint16(ble.TypeBatteryInfo)]
+		if len(f.data) > usock.MaxPayloadLength && len(inner) != 1 {
+			t.Errorf("frame %d: %d bytes with %d values", i, len(f.data), len(inner))
+		}
+		for k := range inner {
+			if seen[k] {
+				t.Errorf("subtype 0x%04x sent twice", k)
+			}
+			seen[k] = true
+		}
+	}
+	if len(seen) != len(values) {
+		t.Errorf("%d of %d values sent", len(seen), len(values))
+	}
+	// Two 400 byte strings fit into a frame, three do not
+	if len(frames) != 5 {
+		t.Errorf("got %d frames, want 5", len(frames))
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+)
+
+// sentCache remembers the last value exchanged with the nRF52 per (type,
+// subtype), i.e. the value the nRF52 is known to have. Outbound values equal
+// to it are not sent again, and for fields synced both ways a received value
+// equal to it is an echo of one sent. Values of frames the nRF52 did not
+// acknowledge are forgotten. It is reset by every full state push, which also
+// runs periodically to make up for writes that got lost anyway.
+type sentCache struct {
+	mu      sync.Mutex
+	values  map[uint16]cachedValue // By absolute subtype
+	skipped uint64                 // Outbound values not sent because unchanged
+}
+
+// cachedValue is the last value of a field exchanged with the nRF52
+type cachedValue struct {
+	field    *ble.Field
+	value    string // Redis representation
+	at       time.Time
+	received bool // Sent by the nRF52 rather than to it
+}
+
+func newSentCache() *sentCache {
+	return &sentCache{values: make(map[uint16]cachedValue)}
+}
+
+// exchange records value as the last one exchanged and tells whether it
+// differs from the previous one. An unchanged outbound value is counted as
+// skipped.
+func (c *sentCache) exchange(f *ble.Field, value string, received bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.values[f.Key()]; ok && last.value == value {
+		if !received {
+			c.skipped++
+		}
+		return false
+	}
+	c.values[f.Key()] = cachedValue{field: f, value: value, at: time.Now(), received: received}
+	return true
+}
+
+// forget drops the value of a field, so the next one is passed on whatever
+// it is
+func (c *sentCache) forget(f *ble.Field) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, f.Key())
+}
+
+// forgetKeys drops the values of fields by absolute subtype, e.g. those of a
+// frame that was not delivered
+func (c *sentCache) forgetKeys(keys []uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		delete(c.values, k)
+	}
+}
+
+// reset forgets every value, e.g. before a full state push
+func (c *sentCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = make(map[uint16]cachedValue)
+}
+
+// Skipped returns how many outbound values were not sent because unchanged
+func (c *sentCache) Skipped() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skipped
+}
+
+// entries returns the cached values by absolute subtype
+func (c *sentCache) entries() []cachedValue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]cachedValue, 0, len(c.values))
+	for _, v := range c.values {
+		entries = append(entries, v)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].field.Key() < entries[j].field.Key() })
+	return entries
+}
+
+// DumpSentCache logs the last value exchanged with the nRF52 per field
+func (s *Service) DumpSentCache() {
+	entries := s.sent.entries()
+	log.Printf("Sent cache dump: %d values, %d unchanged values not sent", len(entries), s.sent.Skipped())
+	for _, v := range entries {
+		dir := "sent"
+		if v.received {
+			dir = "received"
+		}
+		log.Printf("Sent cache: 0x%04x %s = %q, %s %v ago", v.field.Key(), v.field, v.value, dir, time.Since(v.at).Round(time.Millisecond))
+	}
+}
+
+// WatchReconcile pushes the full state every interval until the service is
+// stopped, so values the nRF52 missed despite the cache are sent again
+func (s *Service) WatchReconcile(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			log.Printf("Reconciling the nRF52 state")
+			s.requestPush()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+func TestSentCache(t *testing.T) {
+	c := newSentCache()
+	f := ble.FieldBatterySlot1Charge
+
+	if !c.exchange(f, "87", false) {
+		t.Errorf("first value not sent")
+	}
+	if c.exchange(f, "87", false) || c.exchange(f, "87", false) {
+		t.Errorf("unchanged value sent")
+	}
+	if c.Skipped() != 2 {
+		t.Errorf("got %d skipped values, want 2", c.Skipped())
+	}
+
+	// Synced both ways: a received value is not sent back, its echo is not stored
+	if !c.exchange(f, "90", true) || c.exchange(f, "90", false) {
+		t.Errorf("received value not passed on exactly once")
+	}
+	if !c.exchange(f, "91", false) || c.exchange(f, "91", true) {
+		t.Errorf("sent value not passed on exactly once")
+	}
+	if c.Skipped() != 3 {
+		t.Errorf("got %d skipped values, want 3: echoes received are not skipped writes", c.Skipped())
+	}
+
+	c.forget(f)
+	if !c.exchange(f, "91", false) {
+		t.Errorf("value not sent after forget")
+	}
+	c.exchange(ble.FieldVehicleStateState, "parked", false)
+	if entries := c.entries(); len(entries) != 2 || entries[0].field != ble.FieldVehicleStateState || entries[1].value != "91" {
+		t.Errorf("got entries %+v", entries)
+	}
+	c.reset()
+	if !c.exchange(f, "91", false) || len(c.entries()) != 1 {
+		t.Errorf("value not sent after reset")
+	}
+}
+
+// TestSentCacheUndelivered sends a value the nRF52 does not acknowledge: it
+// must not be skipped as unchanged when sent again
+func TestSentCacheUndelivered(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := New(client)
+	s.SetBatchConfig(BatchConfig{Delay: 0})
+	a, b := usock.NewPipe()
+	sock := usock.NewWithTransport(a, nil)
+	defer sock.Close()
+	sock.SetAckConfig(usock.AckConfig{Timeout: 50 * time.Millisecond})
+	s.SetUSock(sock)
+	frames := make(chan *usock.Payload, 8)
+	nrf := usock.NewWithTransport(b, func(p *usock.Payload) { frames <- p }) // Never acknowledges
+	defer nrf.Close()
+
+	f := ble.FieldVehicleStateState
+	if err := client.WriteString(f.RedisKey, f.RedisField, "parked"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.SendField(f); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-frames:
+		case <-time.After(time.Second):
+			t.Fatalf("send %d: value not sent", i+1)
+		}
+		time.Sleep(200 * time.Millisecond) // Past the ACK timeout
+	}
+	if s.sent.Skipped() != 0 {
+		t.Errorf("got %d skipped values, want 0", s.sent.Skipped())
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// capabilitiesTimeout is how long the nRF52 gets to answer the capability
+// handshake before it is treated as legacy firmware
+const capabilitiesTimeout = 500 * time.Millisecond
+
+// NegotiateCapabilities announces the service's protocol version and message
+// types and waits for the nRF52 to answer with its own. Firmware that does
+// not answer within capabilitiesTimeout is assumed to support every known
+// message type.
+func (s *Service) NegotiateCapabilities() ble.Capabilities {
+	// Forget answers to an earlier handshake
+	select {
+	case <-s.capsCh:
+	default:
+	}
+
+	// Posted rather than acknowledged: firmware predating the handshake
+	// does not acknowledge it, and the answer tells enough
+	if err := s.postCapabilities(); err != nil {
+		log.Printf("Warning: failed to send capability handshake: %v", err)
+	} else {
+		select {
+		case caps := <-s.capsCh:
+			return caps
+		case <-time.After(capabilitiesTimeout):
+		}
+	}
+
+	log.Printf("nRF52 did not answer the capability handshake, assuming legacy firmware")
+	caps := ble.LegacyCapabilities()
+	s.setCapabilities(caps)
+	return caps
+}
+
+// postCapabilities queues the capability handshake without waiting for it
+// to be written or acknowledged
+func (s *Service) postCapabilities() error {
+	sock := s.usock.Load()
+	if sock == nil {
+		return fmt.Errorf("USOCK connection is not initialized")
+	}
+	hello := ble.ServiceCapabilities().Encode()
+	frameID, data, err := encodeUARTMessage(ble.TypeBLEVersion, ble.TypeBLEVersionCapabilities, hello)
+	if err != nil {
+		return err
+	}
+	return sock.Post(usock.PriorityControl, "", frameID, data)
+}
+
+// handleCapabilities applies the capabilities reported by the nRF52 and
+// passes them on to a waiting handshake
+func (s *Service) handleCapabilities(value interface{}) {
+	caps, err := ble.DecodeCapabilities(value)
+	if err != nil {
+		s.reportProtocolError(byte(ble.TypeBLEVersion&0xFF), nil, &ble.ProtocolError{
+			Kind:   ble.ErrInvalidValue,
+			Type:   ble.TypeBLEVersion,
+			Detail: fmt.Sprintf("capabilities %v: %v", value, err),
+		})
+		return
+	}
+	s.setCapabilities(caps)
+
+	select {
+	case <-s.capsCh:
+	default:
+	}
+	select {
+	case s.capsCh <- caps:
+	default:
+	}
+}
+
+// setCapabilities switches features to the given capabilities and stores
+// them in the ble hash
+func (s *Service) setCapabilities(caps ble.Capabilities) {
+	s.capsMu.Lock()
+	s.caps = caps
+	s.capsMu.Unlock()
+
+	log.Printf("nRF52 protocol version %d, message types: %s", caps.Version, caps)
+	if err := s.redis.WriteHash(KeyBLEStatus, map[string]interface{}{
+		"protocol-version": caps.Version,
+		"capabilities":     caps.String(),
+	}); err != nil {
+		log.Printf("Failed to write capabilities to Redis: %v", err)
+	}
+}
+
+// supports tells whether the nRF52 firmware handles messages of type t.
+// Version messages are always supported, the handshake depends on them.
+func (s *Service) supports(t ble.MessageType) bool {
+	if t == ble.TypeBLEVersion {
+		return true
+	}
+	s.capsMu.Lock()
+	defer s.capsMu.Unlock()
+	return s.caps.Supports(t)
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// TestNegotiateCapabilities runs the handshake against firmware that answers
+// it and against firmware that neither answers nor acknowledges it, which
+// must only cost capabilitiesTimeout
+func TestNegotiateCapabilities(t *testing.T) {
+	answered := ble.Capabilities{Version: 2, Types: map[ble.MessageType]bool{ble.TypeVehicleState: true}}
+	for _, tc := range []struct {
+		name   string
+		answer bool
+		want   string
+	}{
+		{"answering", true, answered.String()},
+		{"legacy", false, ble.LegacyCapabilities().String()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, client := newFakeRedis(t)
+			s := New(client)
+			a, b := usock.NewPipe()
+			sock := usock.NewWithTransport(a, nil)
+			defer sock.Close()
+			sock.SetAckConfig(usock.AckConfig{Timeout: 250 * time.Millisecond, Retries: 2})
+			s.SetUSock(sock)
+			probes := make(chan struct{}, 1)
+			nrf := usock.NewWithTransport(b, func(p *usock.Payload) {
+				probes <- struct{}{}
+				if !tc.answer {
+					return
+				}
+				var value interface{}
+				data, _ := cbor.Marshal(answered.Encode())
+				cbor.Unmarshal(data, &value)
+				s.handleCapabilities(value)
+			})
+			defer nrf.Close()
+
+			start := time.Now()
+			caps := s.NegotiateCapabilities()
+			if elapsed := time.Since(start); elapsed > capabilitiesTimeout+200*time.Millisecond {
+				t.Errorf("handshake took %v, want at most the capabilities timeout of %v", elapsed, capabilitiesTimeout)
+			}
+			if caps.String() != tc.want {
+				t.Errorf("got capabilities %s, want %s", caps, tc.want)
+			}
+			select {
+			case <-probes:
+			default:
+				t.Errorf("handshake not sent")
+			}
+		})
+	}
+}
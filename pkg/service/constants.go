@@ -16,9 +16,13 @@ const (
 	KeyCBBatteryFault    = "cb-battery:fault" // For PROTSTATUS and BATTSTATUS faults
 
 	KeyBLECommandList = "scooter:bluetooth"
+	KeyBLELink        = "ble:link" // Link quality counters
+
+	KeyBLEProtocolErrors = "ble:protocol-errors" // Malformed payload counters and the last error
 )
 
-// Battery state constants
+// Battery state constants, the wire values of the battery-state enum in
+// pkg/ble/protocol.yaml
 const (
 	BatteryStateUnknown = 0
 	BatteryStateAsleep  = 1
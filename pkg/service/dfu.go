@@ -0,0 +1,158 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+	"github.com/librescoot/bluetooth-service/pkg/dfu"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// DFU timing
+const (
+	dfuBootloaderTimeout = 10 * time.Second // Time for the nRF52 to come up in its bootloader
+	dfuBootDelay         = 2 * time.Second  // Time for the new firmware to boot before USOCK resumes
+	dfuVersionTimeout    = 15 * time.Second // Time for the new firmware to report its version
+)
+
+// DFU states reported as nrf-dfu-state in the ble hash
+const (
+	DFUStateStarting     = "starting"
+	DFUStateBootloader   = "entering-bootloader"
+	DFUStateTransferring = "transferring"
+	DFUStateVerifying    = "verifying"
+	DFUStateDone         = "done"
+	DFUStateFailed       = "failed"
+)
+
+// UpdateNRF52Firmware flashes the nRF52 with the DFU package at path and
+// verifies that the new firmware reports expectedVersion at 0xA001. If
+// expectedVersion is empty, the fw_version of the package's application
+// init packet is expected. Progress is reported in the ble hash.
+func (s *Service) UpdateNRF52Firmware(path, expectedVersion string) error {
+	err := s.updateNRF52Firmware(path, expectedVersion)
+	if err != nil {
+		log.Printf("nRF52 firmware update failed: %v", err)
+		s.setDFUState(DFUStateFailed)
+		if err := s.redis.WriteString(KeyBLEStatus, "nrf-dfu-error", err.Error()); err != nil {
+			log.Printf("Failed to write DFU error to Redis: %v", err)
+		}
+		return err
+	}
+	s.setDFUState(DFUStateDone)
+	return nil
+}
+
+func (s *Service) updateNRF52Firmware(path, expectedVersion string) error {
+	log.Printf("Starting nRF52 firmware update from %s", path)
+	s.setDFUState(DFUStateStarting)
+	s.setDFUProgress(0)
+	if _, err := s.redis.HDel(KeyBLEStatus, "nrf-dfu-error"); err != nil {
+		log.Printf("Failed to clear DFU error in Redis: %v", err)
+	}
+
+	sock := s.usock.Load()
+	if sock == nil {
+		return fmt.Errorf("USOCK connection is not initialized")
+	}
+	pkg, err := dfu.OpenPackage(path)
+	if err != nil {
+		return err
+	}
+	if expectedVersion == "" {
+		if app := pkg.Application(); app != nil {
+			if v, err := app.FirmwareVersion(); err == nil {
+				expectedVersion = strconv.FormatUint(uint64(v), 10)
+			}
+		}
+	}
+
+	// Forget versions reported before the update
+	select {
+	case <-s.nrfVersionCh:
+	default:
+	}
+
+	s.setDFUState(DFUStateBootloader)
+	frameID, data, err := encodeUARTMessage(ble.TypeBLECommand, ble.SubType(ble.BLECommandEnterBootloader), 0)
+	if err != nil {
+		return err
+	}
+	// Not acknowledged: the nRF52 resets right away
+	if err := sock.Send(usock.PriorityControl, frameID, data); err != nil {
+		return fmt.Errorf("failed to send enter bootloader command: %v", err)
+	}
+
+	err = sock.Hijack(func(t usock.Transport) error {
+		conn, ok := t.(dfu.Conn)
+		if !ok {
+			return fmt.Errorf("transport %T does not support DFU", t)
+		}
+		client := dfu.NewClient(conn)
+		if err := client.WaitForBootloader(dfuBootloaderTimeout); err != nil {
+			return err
+		}
+
+		s.setDFUState(DFUStateTransferring)
+		lastPercent := 0
+		client.Progress = func(sent, total int) {
+			if percent := sent * 100 / total; percent != lastPercent {
+				lastPercent = percent
+				s.setDFUProgress(percent)
+			}
+		}
+		if err := client.Update(pkg); err != nil {
+			return err
+		}
+
+		log.Printf("DFU transfer complete, waiting for the new firmware to boot")
+		time.Sleep(dfuBootDelay)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Handing the transport back reports the link as up, which re-runs
+	// InitializeNRF52 and thereby requests the new version
+	s.setDFUState(DFUStateVerifying)
+	select {
+	case version := <-s.nrfVersionCh:
+		if expectedVersion != "" && version != expectedVersion {
+			return fmt.Errorf("nRF52 reports version %s after update, expected %s", version, expectedVersion)
+		}
+		log.Printf("nRF52 firmware updated to version %s", version)
+		return nil
+	case <-time.After(dfuVersionTimeout):
+		return fmt.Errorf("nRF52 did not report its version within %v after update", dfuVersionTimeout)
+	}
+}
+
+// noteNRFVersion passes the version reported at 0xA001 on to a firmware
+// update waiting for it, keeping only the latest one
+func (s *Service) noteNRFVersion(version string) {
+	select {
+	case <-s.nrfVersionCh:
+	default:
+	}
+	select {
+	case s.nrfVersionCh <- version:
+	default:
+	}
+}
+
+func (s *Service) setDFUState(state string) {
+	log.Printf("nRF52 DFU state: %s", state)
+	if err := s.redis.WriteString(KeyBLEStatus, "nrf-dfu-state", state); err != nil {
+		log.Printf("Failed to write DFU state to Redis: %v", err)
+	}
+}
+
+func (s *Service) setDFUProgress(percent int) {
+	if err := s.redis.WriteInt(KeyBLEStatus, "nrf-dfu-progress", percent); err != nil {
+		log.Printf("Failed to write DFU progress to Redis: %v", err)
+	}
+}
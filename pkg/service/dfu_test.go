@@ -0,0 +1,36 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+// TestUpdateNRF52FirmwareInvalidPackage checks that a package that cannot be
+// read fails the update before the nRF52 is sent to its bootloader
+func TestUpdateNRF52FirmwareInvalidPackage(t *testing.T) {
+	fake, client := newFakeRedis(t)
+	s := New(client)
+	a, b := usock.NewPipe()
+	sock := usock.NewWithTransport(a, nil)
+	defer sock.Close()
+	s.SetUSock(sock)
+	frames := make(chan *usock.Payload, 8)
+	nrf := usock.NewWithTransport(b, func(p *usock.Payload) { frames <- p })
+	defer nrf.Close()
+
+	if err := s.UpdateNRF52Firmware(filepath.Join(t.TempDir(), "missing.zip"), ""); err == nil {
+		t.Fatal("update from a missing package succeeded")
+	}
+	status := fake.Hash(KeyBLEStatus)
+	if status["nrf-dfu-state"] != DFUStateFailed || status["nrf-dfu-error"] == "" {
+		t.Errorf("got state %q and error %q, want the failure recorded", status["nrf-dfu-state"], status["nrf-dfu-error"])
+	}
+	select {
+	case p := <-frames:
+		t.Errorf("sent frame ID 0x%02x % x for a failed update", p.ID, p.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
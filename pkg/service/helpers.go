@@ -10,155 +10,130 @@ import (
 	"github.com/librescoot/bluetooth-service/pkg/usock"
 )
 
-// Convert battery state string to integer
-func batteryStateToInt(state string) int {
-	switch state {
-	case "unknown":
-		return BatteryStateUnknown
-	case "asleep":
-		return BatteryStateAsleep
-	case "idle":
-		return BatteryStateIdle
-	case "active":
-		return BatteryStateActive
+// messagePriority returns the outbound scheduling class of a message type
+func messagePriority(messageType ble.MessageType) usock.Priority {
+	switch messageType {
+	case ble.TypeVehicleState, ble.TypePowerManagement:
+		return usock.PriorityState
+	case ble.TypeBattery, ble.TypeScooterInfo:
+		return usock.PriorityTelemetry
 	default:
-		log.Printf("Unknown battery state: %s, defaulting to Unknown", state)
-		return BatteryStateUnknown // Default to unknown
+		// BLE commands, pairing, debug and data stream control
+		return usock.PriorityControl
 	}
 }
 
-// Convert integer battery state to string
-func batteryStateToString(state int) string {
-	switch state {
-	case BatteryStateUnknown:
-		return "unknown"
-	case BatteryStateAsleep:
-		return "asleep"
-	case BatteryStateIdle:
-		return "idle"
-	case BatteryStateActive:
-		return "active"
-	default:
-		log.Printf("Unknown battery state code: %d", state)
-		return "unknown"
-	}
+// coalesceKey identifies a (type, subtype) value so that a queued update is
+// replaced by a newer one instead of both being sent
+func coalesceKey(messageType ble.MessageType, subType ble.SubType) string {
+	return fmt.Sprintf("%04x/%04x", uint16(messageType), uint16(subType))
+}
+
+// absKey returns the absolute subtype of a relative one
+func absKey(messageType ble.MessageType, subType ble.SubType) uint16 {
+	return uint16(messageType) + uint16(subType)
 }
 
-// writeUARTMessage sends a message with an integer value.
-// It now calculates the absolute subtype key.
-func writeUARTMessage(sock *usock.USOCK, messageType ble.MessageType, subType ble.SubType, value uint16) error {
+// writeUARTCommand sends a message and waits for the nRF52 to acknowledge
+// it, retransmitting if the ACK does not arrive in time.
+func writeUARTCommand(sock *usock.USOCK, messageType ble.MessageType, subType ble.SubType, value interface{}) error {
 	if sock == nil {
 		return fmt.Errorf("USOCK connection is not initialized")
 	}
-	absoluteKey := uint16(messageType) + uint16(subType)
-	message := map[uint16]map[uint16]uint16{
-		uint16(messageType): {
-			absoluteKey: value,
-		},
-	}
-
-	cborData, err := cbor.Marshal(message)
+	frameID, cborData, err := encodeUARTMessage(messageType, subType, value)
 	if err != nil {
-		log.Printf("Failed to marshal CBOR message: %v", err)
-		return fmt.Errorf("failed to marshal CBOR: %w", err)
+		return err
 	}
 
-	// Use the lower byte of the MessageType as the Frame ID, matching observed logs.
-	frameID := byte(messageType & 0xFF)
-
-	log.Printf("Sending message: Frame ID=0x%02x, CBOR Data=%s", frameID, hex.EncodeToString(cborData))
-	return sock.WriteWithFrameID(frameID, cborData)
+	log.Printf("Sending command: Frame ID=0x%02x, CBOR Data=%s", frameID, hex.EncodeToString(cborData))
+	return sock.WriteAndWaitAck(frameID, cborData)
 }
 
-// writeUARTMessageString sends a message with a string value.
-// It now calculates the absolute subtype key.
-func writeUARTMessageString(sock *usock.USOCK, messageType ble.MessageType, subType ble.SubType, value string) error {
+// postUARTCommand queues a message without waiting for it to be written or
+// acknowledged, for requests answered by data frames of their own. A NACK
+// or a missing ACK is only logged.
+func postUARTCommand(sock *usock.USOCK, messageType ble.MessageType, subType ble.SubType, value interface{}) error {
 	if sock == nil {
 		return fmt.Errorf("USOCK connection is not initialized")
 	}
-	absoluteKey := uint16(messageType) + uint16(subType)
-	message := map[uint16]map[uint16]string{
+	frameID, cborData, err := encodeUARTMessage(messageType, subType, value)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Posting command: Frame ID=0x%02x, CBOR Data=%s", frameID, hex.EncodeToString(cborData))
+	return sock.PostFunc(usock.PriorityControl, "", frameID, cborData, func(err error) {
+		if err != nil {
+			log.Printf("Warning: command 0x%04x not delivered: %v", absKey(messageType, subType), err)
+		}
+	})
+}
+
+// encodeUARTMessage builds the CBOR payload {type: {absoluteSubtype: value}}
+// and returns it together with the frame ID to send it with.
+func encodeUARTMessage(messageType ble.MessageType, subType ble.SubType, value interface{}) (byte, []byte, error) {
+	if err := checkUARTValue(value); err != nil {
+		return 0, nil, fmt.Errorf("cannot send 0x%04x: %v", absKey(messageType, subType), err)
+	}
+	message := map[uint16]map[uint16]interface{}{
 		uint16(messageType): {
-			absoluteKey: value,
+			absKey(messageType, subType): value,
 		},
 	}
 
 	cborData, err := cbor.Marshal(message)
 	if err != nil {
-		log.Printf("Failed to marshal CBOR string message: %v", err)
-		return fmt.Errorf("failed to marshal CBOR string: %w", err)
+		log.Printf("Failed to marshal CBOR message: %v", err)
+		return 0, nil, fmt.Errorf("failed to marshal CBOR: %w", err)
 	}
 
 	// Use the lower byte of the MessageType as the Frame ID, matching observed logs.
 	frameID := byte(messageType & 0xFF)
-
-	log.Printf("Sending string message: Frame ID=0x%02x, CBOR Data=%s", frameID, hex.EncodeToString(cborData))
-	return sock.WriteWithFrameID(frameID, cborData)
+	return frameID, cborData, nil
 }
 
-// Helper function to safely convert interface{} to int
-func convertToInt(value interface{}) (int, bool) {
+// checkUARTValue reports values the nRF52 cannot decode: anything but
+// integers, booleans, text and byte strings, and arrays and maps of those.
+// Map keys must be integers or strings.
+func checkUARTValue(value interface{}) error {
 	switch v := value.(type) {
-	case int:
-		return v, true
-	case int8:
-		return int(v), true
-	case int16:
-		return int(v), true
-	case int32:
-		return int(v), true
-	case int64:
-		if v <= int64(^uint(0)>>1) && v >= int64(int(^uint(0)>>1)*-1-1) {
-			return int(v), true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+		bool, string, []byte:
+		return nil
+	case []interface{}:
+		for i, elem := range v {
+			if err := checkUARTValue(elem); err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
 		}
-		log.Printf("Integer value %d out of range for int type", v)
-		return 0, false
-	case uint:
-		if uint64(v) <= uint64(^uint(0)>>1) {
-			return int(v), true
+		return nil
+	case map[uint16]interface{}:
+		for k, elem := range v {
+			if err := checkUARTValue(elem); err != nil {
+				return fmt.Errorf("key %d: %v", k, err)
+			}
 		}
-		log.Printf("Unsigned integer value %d out of range for int type", v)
-		return 0, false
-	case uint8:
-		return int(v), true
-	case uint16:
-		return int(v), true
-	case uint32:
-		if uint64(v) <= uint64(^uint(0)>>1) {
-			return int(v), true
+		return nil
+	case map[string]interface{}:
+		for k, elem := range v {
+			if err := checkUARTValue(elem); err != nil {
+				return fmt.Errorf("key %q: %v", k, err)
+			}
 		}
-		log.Printf("Unsigned integer value %d out of range for int type", v)
-		return 0, false
-	case uint64:
-		if v <= uint64(^uint(0)>>1) {
-			return int(v), true
+		return nil
+	case map[interface{}]interface{}:
+		for k, elem := range v {
+			switch k.(type) {
+			case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, string:
+			default:
+				return fmt.Errorf("unsupported map key type %T", k)
+			}
+			if err := checkUARTValue(elem); err != nil {
+				return fmt.Errorf("key %v: %v", k, err)
+			}
 		}
-		log.Printf("Unsigned integer value %d out of range for int type", v)
-		return 0, false
+		return nil
 	default:
-		log.Printf("Value is not a convertible integer type: %T", value)
-		return 0, false
-	}
-}
-
-// Helper function to safely convert interface{} to string
-func convertToString(value interface{}) (string, bool) {
-	switch v := value.(type) {
-	case string:
-		return v, true
-	case []byte:
-		return string(v), true
-	default:
-		log.Printf("Value is not a string or []byte type: %T", value)
-		return "", false
-	}
-}
-
-// Helper function to safely convert interface{} to byte slice
-func convertToBytes(value interface{}) ([]byte, bool) {
-	if v, ok := value.([]byte); ok {
-		return v, true
+		return fmt.Errorf("unsupported value type %T", value)
 	}
-	log.Printf("Value is not a []byte type: %T", value)
-	return nil, false
 }
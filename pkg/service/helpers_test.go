@@ -0,0 +1,86 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+)
+
+func TestEncodeUARTMessage(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		want  interface{} // As decoded by the nRF52 side
+	}{
+		{uint16(7), uint64(7)},
+		{uint32(123456), uint64(123456)},
+		{int32(-120), int64(-120)},
+		{int64(1) << 40, uint64(1) << 40},
+		{true, true},
+		{[]byte{0xc0, 0xff, 0xee}, []byte{0xc0, 0xff, 0xee}},
+		{"v1.2.3", "v1.2.3"},
+		{[]interface{}{uint16(1), "two", false}, []interface{}{uint64(1), "two", false}},
+		{map[uint16]interface{}{1: int32(-1), 2: []byte{1}}, map[interface{}]interface{}{uint64(1): int64(-1), uint64(2): []byte{1}}},
+	} {
+		frameID, data, err := encodeUARTMessage(ble.TypeScooterInfo, ble.TypeMileage, tc.value)
+		if err != nil {
+			t.Errorf("%#v: %v", tc.value, err)
+			continue
+		}
+		if frameID != 0x40 {
+			t.Errorf("%#v: frame ID 0x%02x, want 0x40", tc.value, frameID)
+		}
+		var msg map[uint16]map[uint16]interface{}
+		if err := cbor.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("%#v: %v", tc.value, err)
+		}
+		if got := msg[0xA040][0xA042]; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%#v: decoded %#v, want %#v", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestEncodeUARTMessageUnsupported(t *testing.T) {
+	for _, value := range []interface{}{
+		1.5,
+		nil,
+		struct{}{},
+		[]interface{}{uint16(1), 2.5},
+		map[interface{}]interface{}{1.5: uint16(1)},
+		map[string]interface{}{"nested": map[uint16]interface{}{1: float32(1)}},
+	} {
+		if _, _, err := encodeUARTMessage(ble.TypeScooterInfo, ble.TypeMileage, value); err == nil {
+			t.Errorf("%#v accepted", value)
+		}
+	}
+}
+
+func TestBatteryStateConstants(t *testing.T) {
+	for name, want := range map[string]int{
+		"unknown": BatteryStateUnknown,
+		"asleep":  BatteryStateAsleep,
+		"idle":    BatteryStateIdle,
+		"active":  BatteryStateActive,
+	} {
+		value, err := ble.FieldBatterySlot1State.Encode(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ble.ToInt(value); err != nil || got != want {
+			t.Errorf("%s: encoded as %v, constant is %d", name, value, want)
+		}
+	}
+}
+
+func TestBatteryField(t *testing.T) {
+	for slot, want := range map[int]*ble.Field{
+		0: ble.FieldBatterySlot1Charge,
+		1: ble.FieldBatterySlot1Charge,
+		2: ble.FieldBatterySlot2Charge,
+	} {
+		if got := batteryField(slot, ble.FieldBatterySlot1Charge, ble.FieldBatterySlot2Charge); got != want {
+			t.Errorf("slot %d: got %s, want %s", slot, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"log"
+	"time"
+)
+
+// HandleLinkState records the nRF52 link state in Redis and schedules a
+// resync when the link comes (back) up. It is called from the USOCK read
+// loop and therefore must not block on the UART.
+func (s *Service) HandleLinkState(up bool) {
+	state := "down"
+	if up {
+		state = "up"
+	}
+	log.Printf("nRF52 link is %s", state)
+
+	if err := s.redis.WriteString(KeyBLEStatus, "link", state); err != nil {
+		log.Printf("Failed to write link state to Redis: %v", err)
+	}
+
+	if up {
+		s.requestSync("link up")
+	}
+}
+
+// requestSync schedules the initialization sequence and a full state push,
+// see WatchLink. Requests made while one is pending are merged into it.
+func (s *Service) requestSync(reason string) {
+	select {
+	case s.syncCh <- struct{}{}:
+		log.Printf("Scheduled nRF52 resync: %s", reason)
+	default:
+		log.Printf("nRF52 resync already pending (%s)", reason)
+	}
+}
+
+// requestPush schedules a full state push, see WatchLink
+func (s *Service) requestPush() {
+	select {
+	case s.pushCh <- struct{}{}:
+	default: // A push is already pending
+	}
+}
+
+// WatchLink re-initializes the nRF52 and pushes the full state every time
+// the link comes up, including the first connection after startup, and
+// whenever the nRF52 reset or asked for it. Pushes requested otherwise, e.g.
+// after Redis was restored, wait for the link.
+func (s *Service) WatchLink() {
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-s.syncCh:
+			s.SyncNRF52()
+		case <-s.pushCh:
+			if sock := s.usock.Load(); sock == nil || !sock.IsConnected() {
+				log.Printf("Not pushing the state: nRF52 link is down, it is pushed once the link is up")
+				continue
+			}
+			log.Printf("Pushing the full state...")
+			s.PushFullState()
+		}
+	}
+}
+
+// SyncNRF52 runs the initialization sequence and sends the initial state
+func (s *Service) SyncNRF52() {
+	log.Printf("Initializing communication with nRF52...")
+	if err := s.InitializeNRF52(); err != nil {
+		// Log the error but continue, initialization might partially succeed
+		log.Printf("Error during nRF52 initialization sequence: %v", err)
+	} else {
+		log.Printf("nRF52 initialization sequence sent successfully.")
+	}
+
+	// Wait a bit for nRF52 to process initialization commands before sending state updates
+	log.Printf("Waiting briefly before sending initial state updates...")
+	time.Sleep(200 * time.Millisecond)
+
+	log.Printf("Sending initial state updates...")
+	s.PushFullState()
+	log.Printf("Initial state updates sent.")
+}
+
+// PublishLinkStats writes the USOCK link counters to the ble:link hash
+// every interval until the service is stopped
+func (s *Service) PublishLinkStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if err := s.publishLinkStats(); err != nil {
+				log.Printf("Failed to publish link stats: %v", err)
+			}
+		}
+	}
+}
+
+func (s *Service) publishLinkStats() error {
+	sock := s.usock.Load()
+	if sock == nil {
+		return nil // Nothing to publish before SetUSock
+	}
+	stats := sock.Stats()
+
+	var lastRX int64
+	if !stats.LastRX.IsZero() {
+		lastRX = stats.LastRX.Unix()
+	}
+
+	return s.redis.WriteHash(KeyBLELink, map[string]interface{}{
+		"tx-frames":          stats.TXFrames,
+		"tx-bytes":           stats.TXBytes,
+		"rx-frames":          stats.RXFrames,
+		"rx-bytes":           stats.RXBytes,
+		"header-crc-errors":  stats.HeaderCRCErrors,
+		"payload-crc-errors": stats.PayloadCRCErrors,
+		"oversize-errors":    stats.OversizeErrors,
+		"resyncs":            stats.Resyncs,
+		"write-errors":       stats.WriteErrors,
+		"rx-dropped":         stats.DroppedFrames,
+		"tx-coalesced":       stats.CoalescedFrames,
+		"chunk-errors":       stats.ChunkErrors,
+		"tx-unchanged":       s.sent.Skipped(),
+		"last-rx":            lastRX,
+	})
+}
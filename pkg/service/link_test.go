@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+func TestDataStreamSyncRequest(t *testing.T) {
+	s := New(nil)
+
+	// The answer to our own sync command is no request
+	s.lastSyncSent = time.Now()
+	s.handleDataStreamSync(1)
+	if len(s.syncCh) != 0 {
+		t.Errorf("reported back sync scheduled a resync")
+	}
+
+	s.lastSyncSent = time.Now().Add(-2 * syncEchoWindow)
+	s.handleDataStreamSync(1)
+	s.handleDataStreamSync(1) // Merged into the pending one
+	if len(s.syncCh) != 1 {
+		t.Errorf("got %d pending resyncs, want 1", len(s.syncCh))
+	}
+}
+
+// TestSetUSockWhileHandling writes from a handler while the USOCK is set, as
+// with frames received before main calls SetUSock; run with -race
+func TestSetUSockWhileHandling(t *testing.T) {
+	s := New(nil)
+	s.SetBatchConfig(BatchConfig{Delay: 0})
+	a, b := usock.NewPipe()
+	sock := usock.NewWithTransport(a, nil)
+	defer sock.Close()
+	nrf := usock.NewWithTransport(b, nil)
+	defer nrf.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.writeUARTMessage(ble.TypeBLEVersion, ble.TypeBLEVersionString, 0)
+		}
+	}()
+	s.SetUSock(sock)
+	<-done
+	if err := s.writeUARTMessage(ble.TypeBLEVersion, ble.TypeBLEVersionString, 0); err != nil {
+		t.Errorf("after SetUSock: %v", err)
+	}
+}
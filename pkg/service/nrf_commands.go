@@ -8,52 +8,67 @@ import (
 	"github.com/librescoot/bluetooth-service/pkg/ble"
 )
 
-// InitializeNRF52 initializes communication with the nRF52
+// InitializeNRF52 initializes communication with the nRF52.
+// The commands are spaced by the minimum inter-frame gap of the USOCK writer.
+// The data stream commands and the advertising restart wait for their ACK,
+// so a lost one is retransmitted; nothing else would send them again before
+// the next sync. The version and MAC address requests are only posted,
+// their answers arrive as data frames.
 func (s *Service) InitializeNRF52() error {
 	log.Println("Starting nRF52 initialization...")
 
+	// 0. Agree on the protocol version and message types
+	caps := s.NegotiateCapabilities()
+	dataStream := caps.Supports(ble.TypeDataStream)
+
 	// 1. Disable data streaming
-	if err := writeUARTMessage(s.usock, ble.TypeDataStream, ble.TypeDataStreamEnable, 0); err != nil {
+	if !dataStream {
+		log.Println("Data streaming not supported by the nRF52 firmware, skipping")
+	} else if err := writeUARTCommand(s.usock.Load(), ble.TypeDataStream, ble.TypeDataStreamEnable, 0); err != nil {
 		log.Printf("Warning: failed to disable data streaming: %v", err)
 	} else {
 		log.Println("Sent Disable Data Streaming command")
 	}
-	time.Sleep(50 * time.Millisecond)
 
-	// 2. Request BLE firmware version
-	if err := writeUARTMessage(s.usock, ble.TypeBLEVersion, ble.TypeBLEVersionString, 0); err != nil {
+	// 2. Request BLE firmware version, answered by a version frame
+	if err := postUARTCommand(s.usock.Load(), ble.TypeBLEVersion, ble.TypeBLEVersionString, 0); err != nil {
 		log.Printf("Warning: failed to request BLE firmware version: %v", err)
 	} else {
-		log.Println("Sent Request BLE Firmware Version command")
+		log.Println("Posted Request BLE Firmware Version command")
 	}
-	time.Sleep(50 * time.Millisecond)
 
-	// 3. Request BLE MAC address
-	if err := writeUARTMessage(s.usock, ble.TypeBLEParam, ble.TypeBLEParamMACAddress, 0); err != nil {
+	// 3. Request BLE MAC address, answered by a parameter frame
+	if !caps.Supports(ble.TypeBLEParam) {
+		log.Println("BLE parameters not supported by the nRF52 firmware, skipping MAC address request")
+	} else if err := postUARTCommand(s.usock.Load(), ble.TypeBLEParam, ble.TypeBLEParamMACAddress, 0); err != nil {
 		log.Printf("Warning: failed to request BLE MAC address: %v", err)
 	} else {
-		log.Println("Sent Request BLE MAC Address command")
+		log.Println("Posted Request BLE MAC Address command")
 	}
-	time.Sleep(50 * time.Millisecond)
 
-	// 4. Enable data streaming
-	if err := writeUARTMessage(s.usock, ble.TypeDataStream, ble.TypeDataStreamEnable, 1); err != nil {
-		log.Printf("Warning: failed to enable data streaming: %v", err)
-	} else {
-		log.Println("Sent Enable Data Streaming command")
-	}
-	time.Sleep(50 * time.Millisecond)
+	if dataStream {
+		// 4. Enable data streaming
+		if err := writeUARTCommand(s.usock.Load(), ble.TypeDataStream, ble.TypeDataStreamEnable, 1); err != nil {
+			log.Printf("Warning: failed to enable data streaming: %v", err)
+		} else {
+			log.Println("Sent Enable Data Streaming command")
+		}
 
-	// 5. Sync data stream
-	if err := writeUARTMessage(s.usock, ble.TypeDataStream, ble.TypeDataStreamSync, 1); err != nil {
-		log.Printf("Warning: Failed to sync data stream: %v", err)
-	} else {
-		log.Println("Sent Data Stream Sync command")
+		// 5. Sync data stream
+		s.syncMu.Lock()
+		s.lastSyncSent = time.Now()
+		s.syncMu.Unlock()
+		if err := writeUARTCommand(s.usock.Load(), ble.TypeDataStream, ble.TypeDataStreamSync, 1); err != nil {
+			log.Printf("Warning: Failed to sync data stream: %v", err)
+		} else {
+			log.Println("Sent Data Stream Sync command")
+		}
 	}
-	time.Sleep(50 * time.Millisecond)
 
 	// 6. Start advertising (No Whitelist)
-	if err := writeUARTMessage(s.usock, ble.TypeBLECommand, ble.SubType(ble.BLECommandAdvRestartNoWhitelist), 0); err != nil {
+	if !caps.Supports(ble.TypeBLECommand) {
+		log.Println("BLE commands not supported by the nRF52 firmware, skipping advertising start")
+	} else if err := writeUARTCommand(s.usock.Load(), ble.TypeBLECommand, ble.SubType(ble.BLECommandAdvRestartNoWhitelist), 0); err != nil {
 		log.Printf("Warning: failed to send command to restart advertising without whitelist: %v", err)
 	} else {
 		log.Println("Sent command to restart advertising without whitelist")
@@ -66,7 +81,7 @@ func (s *Service) InitializeNRF52() error {
 
 // RestartAdvertisingWithoutWhitelist sends command to restart advertising without whitelist
 func (s *Service) RestartAdvertisingWithoutWhitelist() error {
-	if err := writeUARTMessage(s.usock, ble.TypeBLECommand, ble.SubType(ble.BLECommandAdvRestartNoWhitelist), 0); err != nil {
+	if err := writeUARTCommand(s.usock.Load(), ble.TypeBLECommand, ble.SubType(ble.BLECommandAdvRestartNoWhitelist), 0); err != nil {
 		return fmt.Errorf("failed to send advertising restart command: %v", err)
 	}
 	log.Println("Sent command to restart advertising without whitelist")
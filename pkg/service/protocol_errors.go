@@ -0,0 +1,48 @@
+package service
+
+import (
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+)
+
+// protocolErrors counts malformed payloads by kind
+type protocolErrors struct {
+	mu     sync.Mutex
+	counts map[ble.ProtocolErrorKind]uint64
+	total  uint64
+}
+
+// reportProtocolError logs a malformed part of a received payload and
+// records it in the ble:protocol-errors hash: a counter per kind, the total
+// and the details of the last error.
+func (s *Service) reportProtocolError(frameID byte, payload []byte, err *ble.ProtocolError) {
+	log.Printf("Protocol error in frame ID 0x%02x: %v (payload %x)", frameID, err, payload)
+
+	s.protoErrors.mu.Lock()
+	if s.protoErrors.counts == nil {
+		s.protoErrors.counts = make(map[ble.ProtocolErrorKind]uint64)
+	}
+	s.protoErrors.counts[err.Kind]++
+	s.protoErrors.total++
+	fields := map[string]interface{}{
+		"total":         s.protoErrors.total,
+		"last-kind":     err.Kind.String(),
+		"last-error":    err.Detail,
+		"last-frame-id": int(frameID),
+		"last-type":     uint16(err.Type),
+		"last-payload":  hex.EncodeToString(payload),
+		"last-time":     time.Now().Unix(),
+	}
+	for _, kind := range ble.ProtocolErrorKinds {
+		fields[kind.String()] = s.protoErrors.counts[kind]
+	}
+	s.protoErrors.mu.Unlock()
+
+	if err := s.redis.WriteHash(KeyBLEProtocolErrors, fields); err != nil {
+		log.Printf("Failed to write protocol errors to Redis: %v", err)
+	}
+}
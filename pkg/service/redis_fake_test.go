@@ -0,0 +1,19 @@
+package service
+
+import (
+	"testing"
+
+	redisclient "github.com/librescoot/bluetooth-service/pkg/redis"
+	"github.com/librescoot/bluetooth-service/pkg/redis/redistest"
+)
+
+// newFakeRedis starts an in-memory Redis and returns a client connected to it
+func newFakeRedis(t *testing.T) (*redistest.Server, *redisclient.Client) {
+	srv := redistest.NewServer(t)
+	client, err := redisclient.New(srv.Addr(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return srv, client
+}
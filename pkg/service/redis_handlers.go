@@ -1,26 +1,23 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/librescoot/bluetooth-service/pkg/ble"
 )
 
-// SubscribeToRedisChannels subscribes to Redis channels for characteristic writes
+// SubscribeToRedisChannels subscribes to the Redis channels of all outbound
+// fields in the registry and sends a field to the nRF52 whenever it changes.
+// The subscription is restored when the connection to Redis was lost, and
+// the full state is pushed again since updates may have been missed.
 func (s *Service) SubscribeToRedisChannels() {
-	// Define channels based on observed subscriptions
-	channels := []string{
-		KeyVehicle,           // "vehicle"
-		KeyBatterySlot1,      // "battery:0"
-		KeyBatterySlot2,      // "battery:1"
-			KeyPowerManager,      // "power-manager"
-		KeyMileage,           // "engine-ecu"
-		KeyFirmwareVersion,   // "system"
-			KeyBLEPairingPin,     // "ble" - Keep for pin removal notification
-	}
+	// KeyBLEPairingPin is kept for pin removal notifications
+	channels := append(s.outboundRedisKeys(), KeyBLEPairingPin)
 
 	// Ensure only unique keys are subscribed
 	processedKeys := make(map[string]bool)
@@ -32,136 +29,121 @@ func (s *Service) SubscribeToRedisChannels() {
 		}
 	}
 
-	for _, channel := range uniqueChannels {
-		go func(chName string) {
-			pubsub, closeFunc := s.redis.Subscribe(chName)
-			defer closeFunc()
-
-			for msg := range pubsub {
-				log.Printf("Received Redis message on channel %s: %s", chName, msg.Payload)
-				field := msg.Payload // Payload is the field name that changed
-
-				switch chName {
-				case KeyVehicle:
-					switch field {
-					case "state":
-						if err := s.UpdateVehicleState(); err != nil {
-							log.Printf("Error sending vehicle state update triggered by Redis: %v", err)
-						}
-					case "seatbox:lock":
-						if err := s.UpdateSeatboxLock(); err != nil {
-							log.Printf("Error sending seatbox lock update triggered by Redis: %v", err)
-						}
-					case "handlebar:lock-sensor":
-						if err := s.UpdateHandlebarLock(); err != nil {
-							log.Printf("Error sending handlebar lock update triggered by Redis: %v", err)
-						}
-					default:
-						log.Printf("Unhandled field '%s' for channel '%s'", field, chName)
-					}
-
-				case KeyBatterySlot1:
-					switch field {
-					case "state":
-						if err := s.UpdateBatteryActiveStatus(1); err != nil {
-							log.Printf("Error sending battery slot 1 state update triggered by Redis: %v", err)
-						}
-					case "present":
-						if err := s.UpdateBatteryPresentStatus(1); err != nil {
-							log.Printf("Error sending battery slot 1 presence update triggered by Redis: %v", err)
-						}
-						if err := s.UpdateBatteryCycleCount(1); err != nil {
-							log.Printf("Error sending battery slot 1 cycle count update triggered by Redis: %v", err)
-						}
-					case "charge":
-						if err := s.UpdateBatteryRemainingCharge(1); err != nil {
-							log.Printf("Error sending battery slot 1 charge update triggered by Redis: %v", err)
-						}
-					case "cycle-count":
-						if err := s.UpdateBatteryCycleCount(1); err != nil {
-							log.Printf("Error sending battery slot 1 cycle count update triggered by Redis: %v", err)
-						}
-					default:
-						log.Printf("Unhandled field '%s' for channel '%s'", field, chName)
-					}
-
-				case KeyBatterySlot2:
-					switch field {
-					case "state":
-						if err := s.UpdateBatteryActiveStatus(2); err != nil {
-							log.Printf("Error sending battery slot 2 state update triggered by Redis: %v", err)
-						}
-					case "present":
-						if err := s.UpdateBatteryPresentStatus(2); err != nil {
-							log.Printf("Error sending battery slot 2 presence update triggered by Redis: %v", err)
-						}
-						if err := s.UpdateBatteryCycleCount(2); err != nil {
-							log.Printf("Error sending battery slot 2 cycle count update triggered by Redis: %v", err)
-						}
-					case "charge":
-						if err := s.UpdateBatteryRemainingCharge(2); err != nil {
-							log.Printf("Error sending battery slot 2 charge update triggered by Redis: %v", err)
-						}
-					case "cycle-count":
-						if err := s.UpdateBatteryCycleCount(2); err != nil {
-							log.Printf("Error sending battery slot 2 cycle count update triggered by Redis: %v", err)
-						}
-					default:
-						log.Printf("Unhandled field '%s' for channel '%s'", field, chName)
-					}
-
-				case KeyPowerManager:
-					if field == "state" {
-						if err := s.UpdatePowerManagementState(); err != nil {
-							log.Printf("Error sending power management state update triggered by Redis: %v", err)
-						}
-					} else {
-						log.Printf("Unhandled field '%s' for channel '%s'", field, chName)
-					}
-
-				case KeyMileage:
-					if field == "odometer" {
-						if err := s.UpdateMileage(); err != nil {
-							log.Printf("Error sending mileage update triggered by Redis: %v", err)
-						}
-					} else {
-						log.Printf("Unhandled field '%s' for channel '%s'", field, chName)
-					}
-
-				case KeyFirmwareVersion:
-					if field == "mdb-version" {
-						if err := s.UpdateFirmwareVersion(); err != nil {
-							log.Printf("Error sending firmware version update triggered by Redis: %v", err)
-						}
-					} else {
-						log.Printf("Unhandled field '%s' for channel '%s'", field, chName)
-					}
-				
-				case KeyBLEPairingPin:
-					if field == "pin-code" {
-						pin, err := s.redis.GetString(KeyBLEPairingPin, "pin-code")
-						if (err != nil && err != redis.Nil) || pin == "" { 
-							log.Printf("Pin code removed notification received for channel '%s'. Sending removal command.", chName)
-							if err := writeUARTMessage(s.usock, ble.TypeBLEPairingPinRemove, 0, 1); err != nil {
-								log.Printf("Error sending pairing pin removal command: %v", err)
-							}
-						} else {
-							log.Printf("Pin code set/updated notification received for channel '%s'. No action needed.", chName)
-						}
-					} else {
-						log.Printf("Unhandled field '%s' for channel '%s'", field, chName)
-					}
-
-				default:
-					log.Printf("Unhandled Redis channel in subscription: %s", chName)
-				}
-			}
-		}(channel) // Pass channel name to the goroutine
+	s.hashes.mu.Lock()
+	trigger := s.hashes.cfg.Trigger
+	s.hashes.mu.Unlock()
+
+	if trigger == TriggerKeyspace {
+		s.subscribeToKeyspace(uniqueChannels)
+		return
 	}
 
+	go s.redis.Subscribe(uniqueChannels, func(chName, payload string) {
+		log.Printf("Received Redis message on channel %s: %s", chName, payload)
+		s.handleRedisField(chName, s.notifiedField(chName, payload))
+	}, s.HandleRedisState)
+
 	log.Println("Subscribed to Redis channels") // Log after setting up all subscriptions
 }
 
+// subscribeToKeyspace watches the hashes with keyspace notifications
+// instead of published messages. Every (re)connection takes a new snapshot
+// of the hashes, as changes while disconnected are covered by the full
+// state push.
+func (s *Service) subscribeToKeyspace(keys []string) {
+	if enabled, err := s.redis.KeyspaceEventsEnabled(); err != nil {
+		log.Printf("Warning: Failed to check notify-keyspace-events: %v", err)
+	} else if !enabled {
+		log.Printf("Warning: Keyspace notifications of hashes are disabled, set notify-keyspace-events to Kgh")
+	}
+
+	patterns := make([]string, len(keys))
+	for i, key := range keys {
+		patterns[i] = s.redis.KeyspaceChannel(key)
+	}
+	go s.redis.PSubscribe(patterns, s.handleKeyspaceEvent, func(up bool) {
+		if up {
+			s.snapshotHashes(keys)
+		}
+		s.HandleRedisState(up)
+	})
+
+	log.Println("Subscribed to Redis keyspace notifications")
+}
+
+// HandleRedisState records the state of the Redis connection in the ble
+// hash, and schedules a state push when it was restored. Outages can only be
+// written once they are over, so their start is kept until then.
+func (s *Service) HandleRedisState(up bool) {
+	now := time.Now()
+	s.redisMu.Lock()
+	if !up {
+		s.redisDownSince = now
+		s.redisMu.Unlock()
+		log.Printf("Redis connection lost, the nRF52 gets no updates until it is restored")
+		return
+	}
+	downSince := s.redisDownSince
+	s.redisDownSince = time.Time{}
+	s.redisMu.Unlock()
+
+	reconnects := s.redis.Reconnects()
+	fields := map[string]interface{}{
+		"redis-up-since":   now.Unix(),
+		"redis-reconnects": reconnects,
+	}
+	if !downSince.IsZero() {
+		fields["redis-last-down-since"] = downSince.Unix()
+		fields["redis-last-outage"] = int(now.Sub(downSince).Seconds())
+		log.Printf("Redis was unreachable for %v", now.Sub(downSince).Round(time.Millisecond))
+	}
+	if err := s.redis.WriteHash(KeyBLEStatus, fields); err != nil {
+		log.Printf("Failed to write Redis connection state: %v", err)
+	}
+	if reconnects == 0 {
+		return // The link comes up with a push of its own
+	}
+	log.Printf("Redis connection restored, scheduling a full state push")
+	s.requestPush()
+}
+
+// handleRedisField sends the outbound fields stored in a changed Redis field
+func (s *Service) handleRedisField(key, field string) {
+	if s.ownChange(key, field) {
+		log.Printf("Ignoring change of Redis field %s of %s made by the service itself", field, key)
+		return
+	}
+	if key == KeyBLEPairingPin && field == "pin-code" {
+		pin, err := s.redis.GetString(KeyBLEPairingPin, "pin-code")
+		if (err != nil && err != redis.Nil) || pin == "" {
+			log.Printf("Pin code removed notification received for channel '%s'. Sending removal command.", key)
+			if !s.supports(ble.TypeBLEPairingPinRemove) {
+				log.Printf("Pairing pin removal is not supported by the nRF52 firmware")
+			} else if err := s.writeUARTMessage(ble.TypeBLEPairingPinRemove, 0, 1); err != nil {
+				log.Printf("Error sending pairing pin removal command: %v", err)
+			}
+		} else {
+			log.Printf("Pin code set/updated notification received for channel '%s'. No action needed.", key)
+		}
+		return
+	}
+
+	fields := s.outboundFields(key, field)
+	if field == "present" {
+		// A newly inserted battery brings its own cycle count
+		fields = append(fields, s.outboundFields(key, "cycle-count")...)
+	}
+	if len(fields) == 0 {
+		log.Printf("Unhandled field '%s' for channel '%s'", field, key)
+		return
+	}
+	for _, f := range fields {
+		if err := s.SendField(f); err != nil {
+			log.Printf("Error sending %s update triggered by Redis: %v", f, err)
+		}
+	}
+}
+
 // WatchRedisCommands listens for commands on a Redis list (using BRPOP)
 // and sends the corresponding command to the nRF52.
 func (s *Service) WatchRedisCommands() {
@@ -193,275 +175,214 @@ func (s *Service) WatchRedisCommands() {
 			command := result[1] // The actual command string
 			log.Printf("Received command from Redis list %s: %s", KeyBLECommandList, command)
 
-			var msgType ble.MessageType
-			var subType ble.SubType
-			var valueInt uint16 = 0 // Most commands have 0 value
-			sendCmd := true
-
-			switch command {
-			case "advertising-start-with-whitelisting":
-				msgType = ble.TypeBLECommand
-				subType = ble.SubType(ble.BLECommandAdvStartWithWhitelist)
-			case "advertising-restart-no-whitelisting":
-				msgType = ble.TypeBLECommand
-				subType = ble.SubType(ble.BLECommandAdvRestartNoWhitelist)
-			case "advertising-stop":
-				msgType = ble.TypeBLECommand
-				subType = ble.SubType(ble.BLECommandAdvStop)
-			case "delete-bond":
-				msgType = ble.TypeBLECommand
-				subType = ble.SubType(ble.BLECommandDeleteBond)
-			case "delete-all-bonds":
-				msgType = ble.TypeBLECommand
-				subType = ble.SubType(ble.BLECommandDeleteAllBonds)
-			case "remove": 
-				msgType = ble.TypeBLEPairingPinRemove
-				subType = 0 // No specific subtype needed
-				valueInt = 1 // Value doesn't matter, use 1
-				log.Printf("Mapping list command 'remove' to TypeBLEPairingPinRemove")
-			default:
-				log.Printf("Unknown command received from Redis list: %s", command)
-				sendCmd = false
+			// nrf-dfu <package.zip> [expected-version]
+			// Runs to completion; commands pushed meanwhile wait in the list.
+			if fields := strings.Fields(command); len(fields) > 0 && fields[0] == "nrf-dfu" {
+				if len(fields) < 2 || len(fields) > 3 {
+					log.Printf("Invalid nrf-dfu command, expected: nrf-dfu <package.zip> [expected-version]")
+					continue
+				}
+				expectedVersion := ""
+				if len(fields) == 3 {
+					expectedVersion = fields[2]
+				}
+				s.UpdateNRF52Firmware(fields[1], expectedVersion)
+				continue
 			}
 
-			if sendCmd {
-				if err := writeUARTMessage(s.usock, msgType, subType, valueInt); err != nil {
-					log.Printf("Failed to send command '%s' (Type: 0x%04x, SubType: 0x%04x) to nRF: %v", command, msgType, subType, err)
-				} else {
-					log.Printf("Sent command '%s' (Type: 0x%04x, SubType: 0x%04x) to nRF", command, msgType, subType)
-				}
+			if command == "dump-sent-cache" {
+				s.DumpSentCache()
+				continue
+			}
+
+			f, value, ok := listCommand(command)
+			if !ok {
+				log.Printf("Unknown command received from Redis list: %s", command)
+				continue
+			}
+			if !s.supports(f.Type) {
+				log.Printf("Command '%s' is not supported by the nRF52 firmware", command)
+				continue
+			}
+			if err := writeUARTCommand(s.usock.Load(), f.Type, f.SubType, value); err != nil {
+				log.Printf("Failed to send command '%s' (Type: 0x%04x, SubType: 0x%04x) to nRF: %v", command, uint16(f.Type), uint16(f.SubType), err)
+			} else {
+				log.Printf("Sent command '%s' (Type: 0x%04x, SubType: 0x%04x) to nRF", command, uint16(f.Type), uint16(f.SubType))
 			}
 		}
 	}
 }
 
-// UpdateVehicleState sends the current vehicle state from Redis to nRF52
-func (s *Service) UpdateVehicleState() error {
-	state, err := s.redis.GetStateInt(KeyVehicle, "state")
-	if err != nil {
-		log.Printf("Warning: failed to get vehicle state from Redis: %v. Sending default (0).", err)
-		state = 0 // Default if not found
+// listCommand returns the field and value sent for a command from the
+// scooter:bluetooth list. BLE commands are sent by name, except for entering
+// the bootloader, which only nrf-dfu may do.
+func listCommand(command string) (*ble.Field, int, bool) {
+	if command == "remove" {
+		f, ok := ble.FieldByName(ble.TypeBLEPairingPinRemove, command)
+		return f, 1, ok // Value doesn't matter, use 1
 	}
-	// Pass the relative subtype
-	if err := writeUARTMessage(s.usock, ble.TypeVehicleState, ble.TypeVehicleStateState, uint16(state)); err != nil {
-		return fmt.Errorf("failed to send vehicle state: %v", err)
+	f, ok := ble.FieldByName(ble.TypeBLECommand, command)
+	if !ok || f.SubType == ble.SubType(ble.BLECommandEnterBootloader) {
+		return nil, 0, false
 	}
-	log.Printf("Sent vehicle state: %d", state)
-	return nil
+	return f, 0, true
 }
 
-// UpdateSeatboxLock sends the current seatbox lock state from Redis to nRF52
-func (s *Service) UpdateSeatboxLock() error {
-	state, err := s.redis.GetStateInt(KeyVehicle, "seatbox:lock")
+// SendField sends the Redis value of an outbound field to the nRF52. A
+// missing or invalid value is replaced by the field's default. Values are
+// not sent when they are the last one exchanged with the nRF52, i.e. sent
+// before or, for fields synced both ways, sent by the nRF52 itself.
+func (s *Service) SendField(f *ble.Field) error {
+	s.pushMu.Lock()
+	defer s.pushMu.Unlock()
+	return s.sendField(f)
+}
+
+// sendField is SendField with pushMu held
+func (s *Service) sendField(f *ble.Field) error {
+	if !s.supports(f.Type) {
+		log.Printf("Not sending %s: message type not in the nRF52 capabilities", f)
+		return nil
+	}
+	raw, err := s.redis.GetString(f.RedisKey, f.RedisField)
 	if err != nil {
-		log.Printf("Warning: failed to get seatbox lock state from Redis: %v. Sending default (0).", err)
-		state = 0 // Default if not found
+		log.Printf("Warning: failed to get %s from Redis: %v. Sending default (%q).", f, err, f.Default)
+		raw = f.Default
 	}
-	// Pass the relative subtype
-	if err := writeUARTMessage(s.usock, ble.TypeVehicleState, ble.TypeVehicleStateSeatbox, uint16(state)); err != nil {
-		return fmt.Errorf("failed to send seatbox lock state: %v", err)
+	value, err := f.Encode(raw)
+	if errors.Is(err, ble.ErrOverflow) {
+		// Sending the default would be as wrong as a wrapped value
+		return err
 	}
-	log.Printf("Sent seatbox lock state: %d", state)
-	return nil
-}
-
-// UpdateHandlebarLock sends the current handlebar lock state from Redis to nRF52
-func (s *Service) UpdateHandlebarLock() error {
-	stateStr, err := s.redis.GetString(KeyVehicle, "handlebar:lock-sensor") // Read as string first
-	var stateInt uint16 = 0 // Default to 0 (locked?)
-
 	if err != nil {
-		log.Printf("Warning: failed to get handlebar lock state from Redis: %v. Sending default (0).", err)
-		// stateInt remains 0
-	} else {
-		switch stateStr {
-		case "locked":
-			stateInt = 0
-		case "unlocked":
-			stateInt = 1
-		default:
-			log.Printf("Warning: unknown handlebar lock state string from Redis: '%s'. Sending default (0).", stateStr)
-			stateInt = 0
+		log.Printf("Warning: %v. Sending default (%q).", err, f.Default)
+		if value, err = f.Encode(f.Default); err != nil {
+			return fmt.Errorf("failed to encode %s: %v", f, err)
 		}
 	}
 
-	// Pass the relative subtype and the converted integer state
-	if err := writeUARTMessage(s.usock, ble.TypeVehicleState, ble.TypeVehicleStateHandlebar, stateInt); err != nil {
-		return fmt.Errorf("failed to send handlebar lock state: %v", err)
+	if !s.sent.exchange(f, raw, false) {
+		log.Printf("Not sending %s: unchanged since last exchanged", f)
+		return nil
+	}
+	if err := s.writeUARTMessage(f.Type, f.SubType, value); err != nil {
+		s.sent.forget(f)
+		return fmt.Errorf("failed to send %s: %v", f, err)
+	}
+	log.Printf("Sent %s: %v (from %q)", f, value, raw)
+
+	// Hibernation level L2 is requested separately from the state
+	if f.Type == ble.TypePowerManagement && f.SubType == ble.TypePowerManagementState && raw == "hibernating-l2" {
+		if err := s.writeUARTMessage(ble.TypePowerManagement, ble.TypePowerManagementPowerRequest, 1); err != nil {
+			log.Printf("Warning: failed to send power management level L2 request: %v", err)
+		} else {
+			log.Printf("Sent power management hibernation level request: L2")
+		}
 	}
-	log.Printf("Sent handlebar lock state: %d", stateInt)
 	return nil
 }
 
+// UpdateVehicleState sends the current vehicle state from Redis to nRF52
+//
+// Deprecated: use SendField(ble.FieldVehicleStateState).
+func (s *Service) UpdateVehicleState() error {
+	return s.SendField(ble.FieldVehicleStateState)
+}
+
+// UpdateSeatboxLock sends the current seatbox lock state from Redis to nRF52
+//
+// Deprecated: use SendField(ble.FieldVehicleStateSeatbox).
+func (s *Service) UpdateSeatboxLock() error {
+	return s.SendField(ble.FieldVehicleStateSeatbox)
+}
+
+// UpdateHandlebarLock sends the current handlebar lock state from Redis to nRF52
+//
+// Deprecated: use SendField(ble.FieldVehicleStateHandlebar).
+func (s *Service) UpdateHandlebarLock() error {
+	return s.SendField(ble.FieldVehicleStateHandlebar)
+}
+
 // UpdateMileage sends the current mileage from Redis to nRF52
+//
+// Deprecated: use SendField(ble.FieldMileage).
 func (s *Service) UpdateMileage() error {
-	mileage, err := s.redis.GetInt(KeyMileage, "odometer")
-	if err != nil {
-		log.Printf("Warning: failed to get mileage from Redis: %v. Sending 0.", err)
-		mileage = 0 // Default if not found
-	}
-	// Pass the relative subtype
-	if err := writeUARTMessage(s.usock, ble.TypeScooterInfo, ble.TypeMileage, uint16(mileage)); err != nil {
-		return fmt.Errorf("failed to send mileage: %v", err)
-	}
-	log.Printf("Sent mileage: %d", mileage)
-	return nil
+	return s.SendField(ble.FieldMileage)
 }
 
 // UpdateFirmwareVersion sends the current firmware version from Redis to nRF52
+//
+// Deprecated: use SendField(ble.FieldSoftwareVersion).
 func (s *Service) UpdateFirmwareVersion() error {
-	version, err := s.redis.GetString(KeyFirmwareVersion, "mdb-version")
-	if err != nil {
-		log.Printf("Warning: failed to get firmware version from Redis: %v. Sending empty string.", err)
-		version = "" // Default if not found
-	}
-	// Pass the relative subtype
-	if err := writeUARTMessageString(s.usock, ble.TypeScooterInfo, ble.TypeSoftwareVersion, version); err != nil {
-		return fmt.Errorf("failed to send firmware version: %v", err)
-	}
-	log.Printf("Sent firmware version: %s", version)
-	return nil
+	return s.SendField(ble.FieldSoftwareVersion)
 }
 
 // UpdateBatteryActiveStatus sends the battery active status from Redis to nRF52
+//
+// Deprecated: use SendField(ble.FieldBatterySlot1State) or
+// SendField(ble.FieldBatterySlot2State).
 func (s *Service) UpdateBatteryActiveStatus(slot int) error {
-	key := KeyBatterySlot1
-	var baseSubType ble.SubType = ble.TypeBatterySlot1State
-	if slot == 2 {
-		key = KeyBatterySlot2
-		baseSubType = ble.TypeBatterySlot2State
-	}
-
-	stateStr, err := s.redis.GetString(key, "state")
-	if err != nil {
-		log.Printf("Warning: failed to get battery status for slot %d from Redis: %v. Sending default.", slot, err)
-		stateStr = "unknown"
-	}
-
-	status := batteryStateToInt(stateStr)
-
-	// Pass the relative subtype
-	if err := writeUARTMessage(s.usock, ble.TypeBattery, baseSubType, uint16(status)); err != nil {
-		return fmt.Errorf("failed to send battery status for slot %d: %v", slot, err)
-	}
-	log.Printf("Sent battery status for slot %d: %d (from %s)", slot, status, stateStr)
-	return nil
+	return s.SendField(batteryField(slot, ble.FieldBatterySlot1State, ble.FieldBatterySlot2State))
 }
 
 // UpdateBatteryPresentStatus sends the battery presence status from Redis to nRF52
+//
+// Deprecated: use SendField(ble.FieldBatterySlot1Presence) or
+// SendField(ble.FieldBatterySlot2Presence).
 func (s *Service) UpdateBatteryPresentStatus(slot int) error {
-	key := KeyBatterySlot1
-	var baseSubType ble.SubType = ble.TypeBatterySlot1Presence
-	if slot == 2 {
-		key = KeyBatterySlot2
-		baseSubType = ble.TypeBatterySlot2Presence
-	}
-
-	present, err := s.redis.GetInt(key, "present")
-	if err != nil {
-		presentStr, strErr := s.redis.GetString(key, "present")
-		if strErr != nil {
-			log.Printf("Warning: Failed to get battery presence status for slot %d from Redis: %v. Sending default (0).", slot, strErr)
-			present = 0
-		} else {
-			switch presentStr {
-			case "true", "1": present = 1
-			default: present = 0
-			}
-		}
-	}
-	// Pass the relative subtype
-	if err := writeUARTMessage(s.usock, ble.TypeBattery, baseSubType, uint16(present)); err != nil {
-		return fmt.Errorf("failed to send battery presence status for slot %d: %v", slot, err)
-	}
-	log.Printf("Sent battery presence status for slot %d: %d", slot, present)
-	return nil
+	return s.SendField(batteryField(slot, ble.FieldBatterySlot1Presence, ble.FieldBatterySlot2Presence))
 }
 
 // UpdateBatteryCycleCount sends the battery cycle count from Redis to nRF52
+//
+// Deprecated: use SendField(ble.FieldBatterySlot1CycleCount) or
+// SendField(ble.FieldBatterySlot2CycleCount).
 func (s *Service) UpdateBatteryCycleCount(slot int) error {
-	key := KeyBatterySlot1
-	var baseSubType ble.SubType = ble.TypeBatterySlot1CycleCount
-	if slot == 2 {
-		key = KeyBatterySlot2
-		baseSubType = ble.TypeBatterySlot2CycleCount
-	}
-
-	cycles, err := s.redis.GetInt(key, "cycle-count")
-	if err != nil {
-		log.Printf("Warning: failed to get battery cycle count for slot %d from Redis: %v. Sending 0.", slot, err)
-		cycles = 0
-	}
-	// Pass the relative subtype
-	if err := writeUARTMessage(s.usock, ble.TypeBattery, baseSubType, uint16(cycles)); err != nil {
-		return fmt.Errorf("failed to send battery cycle count for slot %d: %v", slot, err)
-	}
-	log.Printf("Sent battery cycle count for slot %d: %d", slot, cycles)
-	return nil
+	return s.SendField(batteryField(slot, ble.FieldBatterySlot1CycleCount, ble.FieldBatterySlot2CycleCount))
 }
 
 // UpdateBatteryRemainingCharge sends the battery remaining charge from Redis to nRF52
+//
+// Deprecated: use SendField(ble.FieldBatterySlot1Charge) or
+// SendField(ble.FieldBatterySlot2Charge).
 func (s *Service) UpdateBatteryRemainingCharge(slot int) error {
-	key := KeyBatterySlot1
-	var baseSubType ble.SubType = ble.TypeBatterySlot1Charge
-	if slot == 2 {
-		key = KeyBatterySlot2
-		baseSubType = ble.TypeBatterySlot2Charge
-	}
-
-	charge, err := s.redis.GetInt(key, "charge")
-	if err != nil {
-		log.Printf("Warning: failed to get battery charge for slot %d from Redis: %v. Sending 0.", slot, err)
-		charge = 0
-	}
-	// Pass the relative subtype
-	if err := writeUARTMessage(s.usock, ble.TypeBattery, baseSubType, uint16(charge)); err != nil {
-		return fmt.Errorf("failed to send battery charge for slot %d: %v", slot, err)
-	}
-	log.Printf("Sent battery charge for slot %d: %d", slot, charge)
-	return nil
+	return s.SendField(batteryField(slot, ble.FieldBatterySlot1Charge, ble.FieldBatterySlot2Charge))
 }
 
 // UpdatePowerManagementState sends the power management state from Redis to nRF52
+//
+// Deprecated: use SendField(ble.FieldPowerManagementState).
 func (s *Service) UpdatePowerManagementState() error {
-	stateStr, err := s.redis.GetString(KeyPowerManager, "state")
-	if err != nil {
-		log.Printf("Warning: failed to get power management state from Redis: %v. Sending default (running).", err)
-		stateStr = "running"
-	}
-
-	var stateInt uint16
-	switch stateStr {
-	case "running": stateInt = 1
-	case "suspending": stateInt = 0
-	case "hibernating": stateInt = 2
-	case "hibernating-l2": stateInt = 2 // Send base state
-	case "suspending-imminent": stateInt = 3
-	case "hibernating-imminent": stateInt = 4
-	case "reboot": stateInt = 5
-	case "reboot-imminent": stateInt = 1
-		log.Printf("Info: Reboot-imminent state detected, sending 'running' state to nRF.")
-	default:
-		log.Printf("Unknown power management state string: %s. Sending default (running).", stateStr)
-		stateInt = 1
-	}
+	return s.SendField(ble.FieldPowerManagementState)
+}
 
-	// Pass the relative subtype
-	if err := writeUARTMessage(s.usock, ble.TypePowerManagement, ble.TypePowerManagementState, stateInt); err != nil {
-		return fmt.Errorf("failed to send power management state: %v", err)
+// batteryField returns the field of a battery slot as numbered by the
+// deprecated Update* methods: slot 2 is battery:1, any other slot battery:0
+func batteryField(slot int, slot1, slot2 *ble.Field) *ble.Field {
+	if slot == 2 {
+		return slot2
 	}
-	log.Printf("Sent power management state: %d (from %s)", stateInt, stateStr)
+	return slot1
+}
 
-	// Handle hibernation level separately if needed
-	if stateStr == "hibernating-l2" {
-		level := uint16(1)
-		// Pass the relative subtype
-		if err := writeUARTMessage(s.usock, ble.TypePowerManagement, ble.TypePowerManagementPowerRequest, level); err != nil {
-			log.Printf("Warning: failed to send power management level L2 request: %v", err)
-		} else {
-			log.Printf("Sent power management hibernation level request: L2")
+// PushFullState sends every state value tracked in Redis to the nRF52 in one
+// batch, one frame per message type: vehicle state and locks, scooter info,
+// batteries and power management. Unchanged values and values synced both
+// ways are sent as well, so Redis wins after (re)connecting. Single values
+// are not sent meanwhile, so none of them can refill the cache between its
+// reset and the push.
+func (s *Service) PushFullState() {
+	s.pushMu.Lock()
+	defer s.pushMu.Unlock()
+	s.sent.reset()
+	s.batch.hold()
+	defer s.batch.release()
+	for _, f := range ble.Fields() {
+		if s.direction(f)&ble.Outbound == 0 || f.RedisKey == "" {
+			continue
+		}
+		if err := s.sendField(f); err != nil {
+			log.Printf("Warning during state push: %v", err)
 		}
 	}
-
-	return nil
-} 
\ No newline at end of file
+}
@@ -1,28 +1,70 @@
 package service
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/ble"
 	redisclient "github.com/librescoot/bluetooth-service/pkg/redis"
 	"github.com/librescoot/bluetooth-service/pkg/usock"
 )
 
 // Service represents the MDB Bluetooth service
 type Service struct {
-	usock  *usock.USOCK
+	usock  atomic.Pointer[usock.USOCK] // Nil until SetUSock, which can follow the first received frames
 	redis  *redisclient.Client
 	stopCh chan struct{}
+	syncCh chan struct{} // Re-initialization and full state push requested, see requestSync
+	pushCh chan struct{} // Full state push requested, see WatchLink
+
+	syncMu       sync.Mutex
+	lastSyncSent time.Time // Of the last DataStreamSync command, see handleDataStreamSync
+
+	redisMu        sync.Mutex
+	redisDownSince time.Time // Start of the current Redis outage, see HandleRedisState
+
+	nrfVersionCh chan string // Latest version reported at 0xA001, see noteNRFVersion
+
+	batch *batchWriter // Groups outbound values into frames
+	sync  *syncState   // Field directions, see SetSyncConfig
+	sent  *sentCache   // Last value exchanged per field, see SendField
+
+	pushMu sync.Mutex // Held while sending outbound values, see PushFullState
+
+	hashes *hashSnapshots // Watched Redis hashes, see SetSubscribeConfig
+
+	protoErrors protocolErrors // Malformed received payloads, see reportProtocolError
+
+	capsMu sync.Mutex
+	caps   ble.Capabilities      // Of the nRF52 firmware, legacy until negotiated
+	capsCh chan ble.Capabilities // Latest capabilities reported, see handleCapabilities
 }
 
 // New creates a new Service instance
 func New(redisClient *redisclient.Client) *Service {
-	return &Service{
+	s := &Service{
 		redis:  redisClient,
 		stopCh: make(chan struct{}),
+		syncCh: make(chan struct{}, 1),
+		pushCh: make(chan struct{}, 1),
+
+		nrfVersionCh: make(chan string, 1),
+
+		caps:   ble.LegacyCapabilities(),
+		capsCh: make(chan ble.Capabilities, 1),
 	}
+	s.batch = newBatchWriter(s.sendBatch)
+	s.sync = newSyncState()
+	s.sent = newSentCache()
+	s.hashes = newHashSnapshots()
+	return s
 }
 
-// SetUSock sets the USOCK connection for the service
+// SetUSock sets the USOCK connection for the service. The USOCK may
+// already be handing received frames to the service.
 func (s *Service) SetUSock(sock *usock.USOCK) {
-	s.usock = sock
+	s.usock.Store(sock)
 }
 
 // Stop stops the service
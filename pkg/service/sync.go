@@ -0,0 +1,140 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+)
+
+// SyncConfig selects which side is the source of truth of a field
+type SyncConfig struct {
+	// Directions overrides the direction of fields from protocol.yaml.
+	// Outbound values are sent to the nRF52 when their Redis field changes,
+	// inbound values are stored in Redis and published when the nRF52 sends
+	// them, and with Both the last writer wins.
+	Directions map[*ble.Field]ble.Direction
+}
+
+// DefaultSyncConfig keeps the directions of protocol.yaml
+var DefaultSyncConfig = SyncConfig{}
+
+// syncState holds the sync configuration
+type syncState struct {
+	mu  sync.Mutex
+	cfg SyncConfig
+}
+
+func newSyncState() *syncState {
+	return &syncState{cfg: DefaultSyncConfig}
+}
+
+// SetSyncConfig replaces the sync configuration; call it before
+// SubscribeToRedisChannels
+func (s *Service) SetSyncConfig(cfg SyncConfig) {
+	s.sync.mu.Lock()
+	defer s.sync.mu.Unlock()
+	s.sync.cfg = cfg
+}
+
+// direction returns the configured direction of a field
+func (s *Service) direction(f *ble.Field) ble.Direction {
+	s.sync.mu.Lock()
+	defer s.sync.mu.Unlock()
+	if d, ok := s.sync.cfg.Directions[f]; ok {
+		return d
+	}
+	return f.Direction
+}
+
+// overridden tells whether the direction of a field is configured
+func (s *Service) overridden(f *ble.Field) bool {
+	s.sync.mu.Lock()
+	defer s.sync.mu.Unlock()
+	_, ok := s.sync.cfg.Directions[f]
+	return ok
+}
+
+// outboundFields returns the fields sent when the given Redis hash field
+// changes, in registration order
+func (s *Service) outboundFields(redisKey, redisField string) []*ble.Field {
+	var fields []*ble.Field
+	for _, f := range ble.Fields() {
+		if s.direction(f)&ble.Outbound != 0 && f.RedisKey == redisKey && f.RedisField == redisField {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// outboundRedisKeys returns the Redis hashes holding outbound fields, in
+// registration order
+func (s *Service) outboundRedisKeys() []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, f := range ble.Fields() {
+		if s.direction(f)&ble.Outbound != 0 && f.RedisKey != "" && !seen[f.RedisKey] {
+			seen[f.RedisKey] = true
+			keys = append(keys, f.RedisKey)
+		}
+	}
+	return keys
+}
+
+// ParseSyncDirections parses a comma separated list of TYPE=direction or
+// TYPE/field=direction, e.g. "BATTERY=both,SCOOTER_STATE/state=inbound".
+// A message type stands for all of its fields stored in Redis.
+func ParseSyncDirections(spec string) (map[*ble.Field]ble.Direction, error) {
+	directions := make(map[*ble.Field]ble.Direction)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q: expected TYPE[/field]=direction", entry)
+		}
+		d, err := ble.ParseDirection(strings.TrimSpace(entry[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", entry, err)
+		}
+		typeName, fieldName := strings.TrimSpace(entry[:i]), ""
+		if j := strings.Index(typeName, "/"); j >= 0 {
+			typeName, fieldName = typeName[:j], typeName[j+1:]
+		}
+		msgType, ok := ble.ParseMessageType(typeName)
+		if !ok {
+			return nil, fmt.Errorf("%q: unknown message type %s", entry, typeName)
+		}
+
+		var fields []*ble.Field
+		if fieldName != "" {
+			f, ok := ble.FieldByName(msgType, fieldName)
+			if !ok {
+				return nil, fmt.Errorf("%q: %s has no field %q", entry, msgType, fieldName)
+			}
+			if f.RedisKey == "" {
+				return nil, fmt.Errorf("%q: %s is not stored in Redis", entry, f)
+			}
+			fields = append(fields, f)
+		} else {
+			for _, f := range ble.Fields() {
+				if f.Type == msgType && f.RedisKey != "" {
+					fields = append(fields, f)
+				}
+			}
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("%q: %s has no fields stored in Redis", entry, msgType)
+			}
+		}
+		for _, f := range fields {
+			if d&ble.Outbound != 0 && (f.Kind == ble.KindArray || f.Kind == ble.KindAny) {
+				return nil, fmt.Errorf("%q: %s values cannot be sent", entry, f)
+			}
+			directions[f] = d
+		}
+	}
+	return directions, nil
+}
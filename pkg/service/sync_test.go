@@ -0,0 +1,37 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+)
+
+func TestParseSyncDirections(t *testing.T) {
+	got, err := ParseSyncDirections("BATTERY=both, SCOOTER_STATE/state=inbound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 9 {
+		t.Errorf("got %d fields, want 8 battery fields and the vehicle state", len(got))
+	}
+	if got[ble.FieldBatterySlot2Charge] != ble.Both || got[ble.FieldVehicleStateState] != ble.Inbound {
+		t.Errorf("got %v", got)
+	}
+	if _, ok := got[ble.FieldVehicleStateSeatbox]; ok {
+		t.Errorf("seatbox is not configured")
+	}
+
+	for spec, want := range map[string]string{
+		"BATTERY":                            "expected TYPE[/field]=direction",
+		"BATTERY=sideways":                   "unknown direction",
+		"NOPE=both":                          "unknown message type",
+		"BATTERY/slot3 charge=both":          "has no field",
+		"BLE_COMMANDS/advertising-stop=both": "not stored in Redis",
+		"DEBUG=inbound":                      "no fields stored in Redis",
+	} {
+		if _, err := ParseSyncDirections(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want an error containing %q", spec, err, want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trigger selects how the service learns about changed Redis fields
+type Trigger int
+
+const (
+	TriggerPublish  Trigger = iota // PUBLISH on a channel named after the hash, with "field" or "field:value"
+	TriggerKeyspace                // Keyspace notifications of the hash, which is diffed with its last contents
+)
+
+// String returns the flag name of the trigger
+func (t Trigger) String() string {
+	switch t {
+	case TriggerPublish:
+		return "publish"
+	case TriggerKeyspace:
+		return "keyspace"
+	default:
+		return fmt.Sprintf("Trigger(%d)", int(t))
+	}
+}
+
+// ParseTrigger parses "publish" or "keyspace"
+func ParseTrigger(s string) (Trigger, error) {
+	switch s {
+	case "publish":
+		return TriggerPublish, nil
+	case "keyspace":
+		return TriggerKeyspace, nil
+	default:
+		return 0, fmt.Errorf("unknown trigger: %s", s)
+	}
+}
+
+// SubscribeConfig controls how changes of Redis fields are noticed
+type SubscribeConfig struct {
+	// Trigger is TriggerPublish for services publishing their changes, or
+	// TriggerKeyspace to also notice writers that do not publish. The latter
+	// needs notify-keyspace-events to include K, h and g.
+	Trigger Trigger
+}
+
+// DefaultSubscribeConfig is used until SetSubscribeConfig is called
+var DefaultSubscribeConfig = SubscribeConfig{
+	Trigger: TriggerPublish,
+}
+
+// SetSubscribeConfig selects how changes of Redis fields are noticed; call
+// it before SubscribeToRedisChannels
+func (s *Service) SetSubscribeConfig(cfg SubscribeConfig) {
+	s.hashes.mu.Lock()
+	defer s.hashes.mu.Unlock()
+	s.hashes.cfg = cfg
+}
+
+// hashSnapshots are the last known contents of the hashes watched with
+// keyspace notifications, and the values the service wrote to them itself
+type hashSnapshots struct {
+	mu     sync.Mutex
+	cfg    SubscribeConfig
+	hashes map[string]map[string]string
+	own    map[string]ownWrite // By "hash field"
+}
+
+// ownWrite is a value the service wrote to a Redis field
+type ownWrite struct {
+	value string // "" for a removed field
+	at    time.Time
+}
+
+// ownWriteWindow is how long after writing a Redis field the service takes
+// changes to the value it wrote for its own. A single write can notify
+// several times, e.g. HDEL and HSET of a removal in keyspace mode.
+const ownWriteWindow = 2 * time.Second
+
+func newHashSnapshots() *hashSnapshots {
+	return &hashSnapshots{
+		cfg:    DefaultSubscribeConfig,
+		hashes: make(map[string]map[string]string),
+		own:    make(map[string]ownWrite),
+	}
+}
+
+// noteOwnWrite records a value the service is about to write to a Redis
+// field, so the notification of the change is not acted upon like one made
+// by another service
+func (s *Service) noteOwnWrite(key, field, value string) {
+	s.hashes.mu.Lock()
+	defer s.hashes.mu.Unlock()
+	now := time.Now()
+	for k, w := range s.hashes.own {
+		if now.Sub(w.at) > ownWriteWindow {
+			delete(s.hashes.own, k)
+		}
+	}
+	s.hashes.own[key+" "+field] = ownWrite{value: value, at: now}
+}
+
+// ownChange tells whether a Redis field holds the value the service wrote to
+// it within ownWriteWindow, i.e. whether its change was made by the service
+func (s *Service) ownChange(key, field string) bool {
+	s.hashes.mu.Lock()
+	w, ok := s.hashes.own[key+" "+field]
+	s.hashes.mu.Unlock()
+	if !ok || time.Since(w.at) > ownWriteWindow {
+		return false
+	}
+	hash, err := s.redis.GetAll(key)
+	return err == nil && hash[field] == w.value
+}
+
+// knownField tells whether a change of a Redis field is acted upon
+func (s *Service) knownField(key, field string) bool {
+	return (key == KeyBLEPairingPin && field == "pin-code") || len(s.outboundFields(key, field)) > 0
+}
+
+// notifiedField returns the field of a publish payload, which is either the
+// field name or "field:value". Field names may contain colons themselves,
+// so a payload that is no known field is cut at the last colon leaving a
+// known one.
+func (s *Service) notifiedField(key, payload string) string {
+	if s.knownField(key, payload) {
+		return payload
+	}
+	for i := len(payload) - 1; i > 0; i-- {
+		if payload[i] == ':' && s.knownField(key, payload[:i]) {
+			return payload[:i]
+		}
+	}
+	return payload
+}
+
+// snapshotHashes reads the current contents of the watched hashes, the base
+// of the next diff
+func (s *Service) snapshotHashes(keys []string) {
+	for _, key := range keys {
+		hash, err := s.redis.GetAll(key)
+		if err != nil {
+			log.Printf("Failed to read Redis hash %s: %v", key, err)
+			continue
+		}
+		s.hashes.mu.Lock()
+		s.hashes.hashes[key] = hash
+		s.hashes.mu.Unlock()
+	}
+}
+
+// handleKeyspaceEvent diffs a hash after a keyspace notification and
+// handles the fields that changed, were added or were removed
+func (s *Service) handleKeyspaceEvent(channel, event string) {
+	i := strings.Index(channel, "__:")
+	if i < 0 {
+		log.Printf("Unexpected keyspace channel %s", channel)
+		return
+	}
+	key := channel[i+3:]
+
+	hash, err := s.redis.GetAll(key)
+	if err != nil {
+		log.Printf("Failed to read Redis hash %s after %s: %v", key, event, err)
+		return
+	}
+	s.hashes.mu.Lock()
+	old := s.hashes.hashes[key]
+	s.hashes.hashes[key] = hash
+	s.hashes.mu.Unlock()
+
+	for _, field := range diffHash(old, hash) {
+		if !s.knownField(key, field) {
+			continue // E.g. the service's own status fields
+		}
+		log.Printf("Redis field %s of %s changed (%s)", field, key, event)
+		s.handleRedisField(key, field)
+	}
+}
+
+// diffHash returns the fields that differ between two contents of a hash,
+// in alphabetical order
+func diffHash(old, new map[string]string) []string {
+	var fields []string
+	for field, value := range new {
+		if oldValue, ok := old[field]; !ok || oldValue != value {
+			fields = append(fields, field)
+		}
+	}
+	for field := range old {
+		if _, ok := new[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/bluetooth-service/pkg/ble"
+	"github.com/librescoot/bluetooth-service/pkg/usock"
+)
+
+func TestNotifiedField(t *testing.T) {
+	s := New(nil)
+	for _, tc := range []struct{ key, payload, want string }{
+		{"vehicle", "state", "state"},
+		{"vehicle", "state:parked", "state"},
+		{"vehicle", "seatbox:lock", "seatbox:lock"},
+		{"vehicle", "seatbox:lock:open", "seatbox:lock"},
+		{"vehicle", "handlebar:lock-sensor:unlocked", "handlebar:lock-sensor"},
+		{"battery:1", "charge:87", "charge"},
+		{"system", "mdb-version:v1.2:3", "mdb-version"},
+		{"ble", "pin-code:123456", "pin-code"},
+		{"vehicle", "blinker:left", "blinker:left"}, // Unknown, passed on as is
+	} {
+		if got := s.notifiedField(tc.key, tc.payload); got != tc.want {
+			t.Errorf("%s %q: got %q, want %q", tc.key, tc.payload, got, tc.want)
+		}
+	}
+}
+
+func TestDiffHash(t *testing.T) {
+	old := map[string]string{"state": "parked", "seatbox:lock": "closed", "blinker": "off"}
+	new := map[string]string{"state": "ready-to-drive", "seatbox:lock": "closed", "kickstand": "up"}
+	want := []string{"blinker", "kickstand", "state"}
+	if got := diffHash(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := diffHash(nil, map[string]string{"state": "parked"}); !reflect.DeepEqual(got, []string{"state"}) {
+		t.Errorf("from nothing: got %v", got)
+	}
+	if got := diffHash(new, new); got != nil {
+		t.Errorf("unchanged: got %v", got)
+	}
+}
+
+func TestParseTrigger(t *testing.T) {
+	for _, trigger := range []Trigger{TriggerPublish, TriggerKeyspace} {
+		if got, err := ParseTrigger(trigger.String()); err != nil || got != trigger {
+			t.Errorf("%s: got %v, %v", trigger, got, err)
+		}
+	}
+	if _, err := ParseTrigger("poll"); err == nil {
+		t.Errorf("poll: expected an error")
+	}
+}
+
+// TestPinRemovalNotEchoed removes the pairing PIN from the nRF52 side: the
+// notifications of the service's own Redis writes must not send the removal
+// back, while a removal by another service still does
+func TestPinRemovalNotEchoed(t *testing.T) {
+	fake, client := newFakeRedis(t)
+	s := New(client)
+	a, b := usock.NewPipe()
+	sock := usock.NewWithTransport(a, nil)
+	defer sock.Close()
+	s.SetUSock(sock)
+	frames := make(chan *usock.Payload, 8)
+	nrf := usock.NewWithTransport(b, func(p *usock.Payload) { frames <- p })
+	defer nrf.Close()
+
+	s.storeField(ble.FieldBLEPairingPinDisplay, "123456")
+	s.snapshotHashes([]string{KeyBLEPairingPin})
+	fake.TakePublished()
+
+	// The nRF52 reports the removal; both triggers notify about it
+	s.handlePinRemove(1)
+	published := fake.TakePublished()
+	if len(published) == 0 {
+		t.Fatal("pin removal not published")
+	}
+	for _, msg := range published {
+		channel, payload, _ := strings.Cut(msg, " ")
+		s.handleRedisField(channel, s.notifiedField(channel, payload))
+	}
+	s.handleKeyspaceEvent("__keyspace@0__:ble", "hdel")
+	s.handleKeyspaceEvent("__keyspace@0__:ble", "hset")
+	select {
+	case p := <-frames:
+		t.Fatalf("sent frame ID 0x%02x % x for the service's own removal", p.ID, p.Data)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// A new PIN shown by the nRF52 and removed by another service
+	s.storeField(ble.FieldBLEPairingPinDisplay, "654321")
+	if _, err := client.HDel(KeyBLEPairingPin, "pin-code"); err != nil {
+		t.Fatal(err)
+	}
+	s.handleRedisField(KeyBLEPairingPin, "pin-code")
+	select {
+	case p := <-frames:
+		if p.ID != byte(ble.TypeBLEPairingPinRemove&0xFF) {
+			t.Errorf("got frame ID 0x%02x, want the pin removal", p.ID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("removal by another service not sent")
+	}
+}
@@ -0,0 +1,251 @@
+package usock
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// The nRF52 acknowledges a frame by echoing its frame ID as a single-entry
+// CBOR map: {frameID: {}} encoded as a1 18 <id> a0. An echo whose value is
+// not a map (e.g. an error code) is a negative acknowledgment. Data frames
+// always carry a map value and are never mistaken for either.
+const (
+	cborMap1    = 0xa1 // map with one entry
+	cborUint8   = 0x18 // unsigned integer, one byte follows
+	cborMapMask = 0xe0 // major type bits
+	cborMapType = 0xa0 // major type 5 (map)
+)
+
+// AckConfig controls how WriteAndWaitAck waits for acknowledgments
+type AckConfig struct {
+	Timeout time.Duration // Time to wait for the ACK of each attempt
+	Retries int           // Number of retransmissions after the first attempt
+}
+
+// DefaultAckConfig is used until SetAckConfig is called
+var DefaultAckConfig = AckConfig{
+	Timeout: 250 * time.Millisecond,
+	Retries: 2,
+}
+
+// ErrClosed is returned when the USOCK is closed while waiting for an ACK
+var ErrClosed = errors.New("usock: connection closed")
+
+// AckTimeoutError is returned when no acknowledgment arrived for any attempt
+type AckTimeoutError struct {
+	FrameID  byte
+	Attempts int
+}
+
+func (e *AckTimeoutError) Error() string {
+	return fmt.Sprintf("no ACK for frame ID 0x%02x after %d attempts", e.FrameID, e.Attempts)
+}
+
+// NackError is returned when the nRF52 rejected a frame
+type NackError struct {
+	FrameID byte
+	Data    []byte // Raw CBOR of the rejection
+}
+
+func (e *NackError) Error() string {
+	return fmt.Sprintf("frame ID 0x%02x rejected by nRF52: %s", e.FrameID, hex.EncodeToString(e.Data))
+}
+
+// SetAckConfig changes the timeout and retransmission count for acknowledged writes
+func (u *USOCK) SetAckConfig(cfg AckConfig) {
+	u.ackMu.Lock()
+	defer u.ackMu.Unlock()
+	u.ackConfig = cfg
+}
+
+// WriteAndWaitAck sends data with the given frame ID and waits for the nRF52
+// to acknowledge it, retransmitting on timeout. Since an ACK only carries the
+// frame ID, acknowledged requests sharing a frame ID (e.g. 0x00, 0x20, 0x40)
+// are serialised, and ACKs are matched to every frame written on the ID, see
+// ackTracker.
+func (u *USOCK) WriteAndWaitAck(frameID byte, data []byte) error {
+	return u.WriteAndWaitAckContext(context.Background(), frameID, data)
+}
+
+// WriteAndWaitAckContext is like WriteAndWaitAck but gives up when ctx is done
+func (u *USOCK) WriteAndWaitAckContext(ctx context.Context, frameID byte, data []byte) error {
+	lock := &u.frameLocks[frameID]
+	lock.Lock()
+	defer lock.Unlock()
+
+	u.ackMu.Lock()
+	cfg := u.ackConfig
+	u.ackMu.Unlock()
+
+	ch := make(chan []byte, 1)
+	attempts := cfg.Retries + 1
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			log.Printf("No ACK for frame ID 0x%02x within %v, retransmitting (attempt %d/%d)",
+				frameID, cfg.Timeout, attempt, attempts)
+		}
+		item := &outbound{frameID: frameID, data: data, ack: ch}
+		if err := u.send(ctx, PriorityControl, item); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(cfg.Timeout)
+		select {
+		case resp := <-ch:
+			timer.Stop()
+			if resp != nil {
+				return &NackError{FrameID: frameID, Data: resp}
+			}
+			return nil
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-u.stopChan:
+			timer.Stop()
+			return ErrClosed
+		case <-timer.C:
+		}
+	}
+
+	return &AckTimeoutError{FrameID: frameID, Attempts: attempts}
+}
+
+// ackTracker matches ACKs to the frames written on their frame ID. The nRF52
+// acknowledges every frame it receives in order, so an ACK belongs to the
+// oldest frame on its ID that is still unacknowledged, whether anyone waits
+// for it or not. Frames whose ACK did not arrive within the ACK timeout are
+// given up on, so a lost ACK does not shift all later ones.
+type ackTracker struct {
+	pending [256][]*pendingAck // Oldest first, guarded by USOCK.ackMu
+}
+
+// pendingAck is a frame waiting to be written and acknowledged
+type pendingAck struct {
+	ch       chan []byte // Receives the ACK or NACK, nil if nobody waits for it
+	notify   func(error) // Called with the outcome of a posted frame, see PostFunc
+	deadline time.Time
+	finished atomic.Bool
+}
+
+// finish calls notify with the outcome of the frame, at most once: nil for
+// an ACK, otherwise the write error, NackError or AckTimeoutError
+func (p *pendingAck) finish(err error) {
+	if p.notify != nil && p.finished.CompareAndSwap(false, true) {
+		p.notify(err)
+	}
+}
+
+// expire drops the frames of an ID whose ACK is overdue and returns them
+func (t *ackTracker) expire(frameID byte, now time.Time) []*pendingAck {
+	q := t.pending[frameID]
+	var expired []*pendingAck
+	for len(q) > 0 && now.After(q[0].deadline) {
+		expired = append(expired, q[0])
+		q[0] = nil
+		q = q[1:]
+	}
+	t.pending[frameID] = q
+	return expired
+}
+
+// finishExpired reports the ACK timeout of expired frames
+func finishExpired(frameID byte, expired []*pendingAck) {
+	for _, p := range expired {
+		p.finish(&AckTimeoutError{FrameID: frameID, Attempts: 1})
+	}
+}
+
+// expectAck records a frame that is about to be written. p.ch, if not nil,
+// receives its ACK (nil) or NACK (the raw payload); p.notify, if not nil, is
+// called with the outcome once the ACK arrived or the ACK timeout passed.
+func (u *USOCK) expectAck(frameID byte, p *pendingAck) {
+	u.ackMu.Lock()
+	now := time.Now()
+	expired := u.acks.expire(frameID, now)
+	timeout := u.ackConfig.Timeout
+	p.deadline = now.Add(timeout)
+	u.acks.pending[frameID] = append(u.acks.pending[frameID], p)
+	u.ackMu.Unlock()
+
+	finishExpired(frameID, expired)
+	if p.notify != nil {
+		time.AfterFunc(timeout, func() {
+			if u.cancelAck(frameID, p) {
+				p.finish(&AckTimeoutError{FrameID: frameID, Attempts: 1})
+			}
+		})
+	}
+}
+
+// cancelAck forgets a frame that could not be written or was not
+// acknowledged in time, and tells whether it was still waiting for its ACK
+func (u *USOCK) cancelAck(frameID byte, p *pendingAck) bool {
+	u.ackMu.Lock()
+	defer u.ackMu.Unlock()
+	q := u.acks.pending[frameID]
+	for i := range q {
+		if q[i] == p {
+			u.acks.pending[frameID] = append(q[:i:i], q[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// AckPayload tells whether a payload received with the given frame ID is the
+// ACK {frameID: {}} or a NACK {frameID: code} of a frame sent with that ID
+func AckPayload(frameID byte, data []byte) (isAck, isNack bool) {
+	if len(data) < 4 || data[0] != cborMap1 || data[1] != cborUint8 || data[2] != frameID {
+		return false, false
+	}
+	if len(data) == 4 && data[3] == cborMapType {
+		return true, false
+	}
+	// A map is a data frame that happens to use its frame ID as message type
+	return false, data[3]&cborMapMask != cborMapType
+}
+
+// deliverAck hands an ACK or NACK frame to the oldest unacknowledged frame
+// on its frame ID. ACKs are delivered as nil, NACKs as their raw payload.
+func (u *USOCK) deliverAck(frameID byte, data []byte) {
+	isAck, isNack := AckPayload(frameID, data)
+	if !isAck && !isNack {
+		return
+	}
+	var resp []byte
+	if isNack {
+		resp = data
+	}
+
+	u.ackMu.Lock()
+	expired := u.acks.expire(frameID, time.Now())
+	q := u.acks.pending[frameID]
+	if len(q) == 0 {
+		u.ackMu.Unlock()
+		finishExpired(frameID, expired)
+		return
+	}
+	p := q[0]
+	q[0] = nil
+	u.acks.pending[frameID] = q[1:]
+	u.ackMu.Unlock()
+
+	finishExpired(frameID, expired)
+	if isNack {
+		p.finish(&NackError{FrameID: frameID, Data: data})
+	} else {
+		p.finish(nil)
+	}
+	if p.ch == nil {
+		return // Posted frame
+	}
+	select {
+	case p.ch <- resp:
+	default: // Already answered, e.g. the ACK of a retransmission
+	}
+}
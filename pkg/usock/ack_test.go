@@ -0,0 +1,68 @@
+package usock
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestAckSharedFrameID posts a frame and then waits for the ACK of another
+// one on the same frame ID: the ACK of the posted frame must not complete
+// the acknowledged request.
+func TestAckSharedFrameID(t *testing.T) {
+	posted := []byte{0xa1, 0x19, 0x08, 0x00, 0x01} // {0x0800: 1}
+	acked := []byte{0xa1, 0x19, 0xa0, 0x00, 0x01}  // {0xA000: 1}
+
+	a, b := NewPipe()
+	sender := NewWithTransport(a, nil)
+	defer sender.Close()
+	sender.SetAckConfig(AckConfig{Timeout: time.Second, Retries: 0})
+
+	// The nRF52 side rejects the acknowledged request after acknowledging
+	// the posted frame
+	seen := make(chan struct{}, 1)
+	var nrf *USOCK
+	nrf = NewWithTransport(b, func(p *Payload) {
+		switch {
+		case bytes.Equal(p.Data, posted):
+			seen <- struct{}{}
+		case bytes.Equal(p.Data, acked):
+			nrf.Send(PriorityControl, 0x00, []byte{cborMap1, cborUint8, 0x00, cborMapType})
+			nrf.Send(PriorityControl, 0x00, []byte{cborMap1, cborUint8, 0x00, 0x01})
+		}
+	})
+	defer nrf.Close()
+
+	if err := sender.Post(PriorityState, "", 0x00, posted); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-seen:
+	case <-time.After(2 * time.Second):
+		t.Fatal("posted frame not received")
+	}
+
+	err := sender.WriteAndWaitAck(0x00, acked)
+	var nack *NackError
+	if !errors.As(err, &nack) {
+		t.Fatalf("got %v, want the NACK of the acknowledged request", err)
+	}
+}
+
+func TestAckPayload(t *testing.T) {
+	for _, tc := range []struct {
+		data          []byte
+		isAck, isNack bool
+	}{
+		{[]byte{0xa1, 0x18, 0x20, 0xa0}, true, false},
+		{[]byte{0xa1, 0x18, 0x20, 0x01}, false, true},
+		{[]byte{0xa1, 0x18, 0x20, 0xa1, 0x01, 0x02}, false, false}, // Data frame
+		{[]byte{0xa1, 0x18, 0x40, 0xa0}, false, false},             // Other frame ID
+		{[]byte{0xa1, 0x18}, false, false},
+	} {
+		if isAck, isNack := AckPayload(0x20, tc.data); isAck != tc.isAck || isNack != tc.isNack {
+			t.Errorf("% x: got %v, %v; want %v, %v", tc.data, isAck, isNack, tc.isAck, tc.isNack)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package usock
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction tells whether a frame was received from or sent to the nRF52
+type Direction string
+
+const (
+	DirRX Direction = "rx"
+	DirTX Direction = "tx"
+)
+
+// CapturedFrame is one line of a JSONL frame capture
+type CapturedFrame struct {
+	Time time.Time `json:"ts"`
+	Dir  Direction `json:"dir"`
+	ID   byte      `json:"id"`
+	Data string    `json:"data"`          // Hex encoded payload
+	Err  string    `json:"err,omitempty"` // Set for frames rejected by the parser
+}
+
+// Payload decodes the hex encoded payload
+func (f CapturedFrame) Payload() ([]byte, error) {
+	return hex.DecodeString(f.Data)
+}
+
+// CaptureWriter appends frames to a JSONL capture
+type CaptureWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewCaptureWriter creates a CaptureWriter writing to w
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	return &CaptureWriter{enc: json.NewEncoder(w)}
+}
+
+// Write appends a single frame
+func (c *CaptureWriter) Write(f CapturedFrame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(f)
+}
+
+// ReadCapture calls fn for every frame in a JSONL capture. Anything before the
+// first '{' on a line is ignored, so flight recorder dumps can be fed straight
+// from the service log.
+func ReadCapture(r io.Reader, fn func(CapturedFrame) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		start := strings.IndexByte(line, '{')
+		if start < 0 {
+			continue
+		}
+		var f CapturedFrame
+		if err := json.Unmarshal([]byte(line[start:]), &f); err != nil {
+			return fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// FlightRecorder keeps the last frames in memory so they can be dumped when
+// the link misbehaves
+type FlightRecorder struct {
+	mu     sync.Mutex
+	frames []CapturedFrame
+	next   int
+	full   bool
+}
+
+// NewFlightRecorder creates a recorder holding the last size frames
+func NewFlightRecorder(size int) *FlightRecorder {
+	if size < 1 {
+		size = 1
+	}
+	return &FlightRecorder{frames: make([]CapturedFrame, size)}
+}
+
+// Record adds a frame, overwriting the oldest one when full
+func (r *FlightRecorder) Record(f CapturedFrame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames[r.next] = f
+	r.next = (r.next + 1) % len(r.frames)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Snapshot returns the recorded frames, oldest first
+func (r *FlightRecorder) Snapshot() []CapturedFrame {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]CapturedFrame(nil), r.frames[:r.next]...)
+	}
+	out := make([]CapturedFrame, 0, len(r.frames))
+	out = append(out, r.frames[r.next:]...)
+	return append(out, r.frames[:r.next]...)
+}
+
+// Dump writes the recorded frames to the log in capture format
+func (r *FlightRecorder) Dump(reason string) {
+	frames := r.Snapshot()
+	log.Printf("Flight recorder dump (%s): %d frames", reason, len(frames))
+	for _, f := range frames {
+		line, err := json.Marshal(f)
+		if err != nil {
+			continue
+		}
+		log.Printf("Flight recorder: %s", line)
+	}
+}
+
+// CRC error bursts that trigger a flight recorder dump
+const (
+	FlightRecorderSize = 64
+	CRCBurstThreshold  = 5
+	CRCBurstWindow     = 2 * time.Second
+)
+
+// SetCapture records every received and sent frame to w. Pass nil to stop capturing.
+func (u *USOCK) SetCapture(w *CaptureWriter) {
+	u.capture.Store(w)
+}
+
+// FlightRecorder returns the in-memory recorder of the last frames
+func (u *USOCK) FlightRecorder() *FlightRecorder {
+	return u.recorder
+}
+
+// recordFrame feeds a frame to the flight recorder and the capture, if any
+func (u *USOCK) recordFrame(dir Direction, frameID byte, data []byte, parseErr string) {
+	f := CapturedFrame{
+		Time: time.Now(),
+		Dir:  dir,
+		ID:   frameID,
+		Data: hex.EncodeToString(data),
+		Err:  parseErr,
+	}
+	u.recorder.Record(f)
+	if c := u.capture.Load(); c != nil {
+		if err := c.Write(f); err != nil {
+			log.Printf("Failed to write frame capture: %v", err)
+		}
+	}
+}
+
+// noteCRCError records a rejected frame and dumps the flight recorder when
+// CRC errors come in bursts. Only called from the read loop.
+func (u *USOCK) noteCRCError(frameID byte, data []byte, parseErr string) {
+	u.recordFrame(DirRX, frameID, data, parseErr)
+
+	now := time.Now()
+	u.crcErrors = append(u.crcErrors, now)
+	for len(u.crcErrors) > 0 && now.Sub(u.crcErrors[0]) > CRCBurstWindow {
+		u.crcErrors = u.crcErrors[1:]
+	}
+	if len(u.crcErrors) >= CRCBurstThreshold {
+		u.recorder.Dump(fmt.Sprintf("%d CRC errors within %v", len(u.crcErrors), CRCBurstWindow))
+		u.crcErrors = u.crcErrors[:0]
+	}
+}
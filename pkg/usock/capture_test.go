@@ -0,0 +1,130 @@
+package usock
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logBuffer collects the log output of a test
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestFlightRecorderCRCBurst sends a burst of frames with a broken payload
+// CRC: the flight recorder is dumped once CRCBurstThreshold of them arrived,
+// in a form ReadCapture reads back
+func TestFlightRecorderCRCBurst(t *testing.T) {
+	logs := &logBuffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	a, b := NewPipe()
+	u := NewWithTransport(a, nil)
+	defer u.Close()
+	defer b.Close()
+
+	send := func(data byte, corrupt bool) {
+		frame, err := EncodeFrame(0x20, []byte{0xa1, 0x18, 0x20, data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if corrupt {
+			frame[len(frame)-1] ^= 0xff
+		}
+		if _, err := b.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Valid frames are handled after every error before them
+	waitRX := func(n uint64) {
+		deadline := time.Now().Add(2 * time.Second)
+		for u.Stats().RXFrames < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d valid frames, want %d", u.Stats().RXFrames, n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	send(0x00, false)
+	for i := 1; i < CRCBurstThreshold; i++ {
+		send(byte(i), true)
+	}
+	send(0x10, false)
+	waitRX(2)
+	if strings.Contains(logs.String(), "Flight recorder dump") {
+		t.Fatalf("dumped after %d CRC errors", CRCBurstThreshold-1)
+	}
+
+	send(byte(CRCBurstThreshold), true)
+	send(0x11, false)
+	waitRX(3)
+
+	var dump []string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "Flight recorder: ") {
+			dump = append(dump, line)
+		}
+	}
+	var frames []CapturedFrame
+	err := ReadCapture(strings.NewReader(strings.Join(dump, "\n")), func(f CapturedFrame) error {
+		frames = append(frames, f)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != CRCBurstThreshold+2 {
+		t.Fatalf("dumped %d frames, want %d", len(frames), CRCBurstThreshold+2)
+	}
+	rejected := 0
+	for _, f := range frames {
+		if f.Dir != DirRX || f.ID != 0x20 {
+			t.Errorf("dumped %+v", f)
+		}
+		if f.Err != "" {
+			if f.Err != DecodeErrPayloadCRC.String() {
+				t.Errorf("got error %q, want %q", f.Err, DecodeErrPayloadCRC)
+			}
+			rejected++
+		}
+	}
+	if rejected != CRCBurstThreshold {
+		t.Errorf("dumped %d rejected frames, want %d", rejected, CRCBurstThreshold)
+	}
+	if last, _ := frames[len(frames)-1].Payload(); !bytes.Equal(last, []byte{0xa1, 0x18, 0x20, byte(CRCBurstThreshold)}) {
+		t.Errorf("last dumped frame % x, want the one completing the burst", last)
+	}
+}
+
+func TestFlightRecorderWrap(t *testing.T) {
+	r := NewFlightRecorder(3)
+	for id := byte(1); id <= 5; id++ {
+		r.Record(CapturedFrame{ID: id})
+	}
+	var ids []byte
+	for _, f := range r.Snapshot() {
+		ids = append(ids, f.ID)
+	}
+	if !bytes.Equal(ids, []byte{3, 4, 5}) {
+		t.Errorf("got frames %v, want the last three oldest first", ids)
+	}
+}
@@ -0,0 +1,205 @@
+package usock
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"time"
+)
+
+// Payloads longer than MaxPayloadLength are split into chunks, each sent as
+// its own frame with frame ID ChunkFrameID. A chunk payload starts with a
+// little-endian header:
+//
+//	frame ID    1 byte  Frame ID of the reassembled payload
+//	transfer    1 byte  Transfer ID, the same for all chunks of a payload
+//	sequence    2 bytes Chunk number, starting at 0
+//	total       4 bytes Length of the reassembled payload
+//	CRC         4 bytes CRC-32 (IEEE) of the reassembled payload
+//
+// followed by the chunk data. Chunks are sent in order; the receiver checks
+// the CRC once all bytes arrived and then handles the payload as if it had
+// been received in a single frame.
+const (
+	ChunkFrameID      = 0xFE
+	ChunkHeaderLength = 12
+	MaxChunkData      = MaxPayloadLength - ChunkHeaderLength
+
+	// MaxTransferLength bounds the memory a single transfer can use
+	MaxTransferLength = 64 * 1024
+	// ChunkTimeout is how long an incomplete transfer is kept without new chunks
+	ChunkTimeout = 5 * time.Second
+)
+
+// chunkHeader is the header of a chunk frame
+type chunkHeader struct {
+	FrameID  byte
+	Transfer byte
+	Sequence uint16
+	Total    uint32
+	CRC      uint32
+}
+
+// splitChunks returns the chunk payloads that carry data
+func splitChunks(frameID, transfer byte, data []byte) [][]byte {
+	crc := crc32.ChecksumIEEE(data)
+	var chunks [][]byte
+	for seq, offset := 0, 0; offset < len(data); seq, offset = seq+1, offset+MaxChunkData {
+		part := data[offset:min(offset+MaxChunkData, len(data))]
+		chunk := make([]byte, ChunkHeaderLength, ChunkHeaderLength+len(part))
+		chunk[0] = frameID
+		chunk[1] = transfer
+		binary.LittleEndian.PutUint16(chunk[2:], uint16(seq))
+		binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+		binary.LittleEndian.PutUint32(chunk[8:], crc)
+		chunks = append(chunks, append(chunk, part...))
+	}
+	return chunks
+}
+
+// parseChunk splits a chunk payload into header and data
+func parseChunk(payload []byte) (chunkHeader, []byte, error) {
+	if len(payload) < ChunkHeaderLength {
+		return chunkHeader{}, nil, fmt.Errorf("chunk of %d bytes is shorter than its header", len(payload))
+	}
+	h := chunkHeader{
+		FrameID:  payload[0],
+		Transfer: payload[1],
+		Sequence: binary.LittleEndian.Uint16(payload[2:]),
+		Total:    binary.LittleEndian.Uint32(payload[4:]),
+		CRC:      binary.LittleEndian.Uint32(payload[8:]),
+	}
+	return h, payload[ChunkHeaderLength:], nil
+}
+
+// transfer is a payload being reassembled
+type transfer struct {
+	header  chunkHeader
+	next    uint16 // Expected sequence number
+	data    []byte
+	updated time.Time
+}
+
+// reassembler collects chunks into payloads. It is only used by the read loop.
+type reassembler struct {
+	transfers map[byte]*transfer
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{transfers: make(map[byte]*transfer)}
+}
+
+// add processes a chunk payload received at now. It returns the reassembled
+// payload once the last chunk of a transfer arrived, nil before that. A
+// broken transfer is discarded and reported as an error.
+func (r *reassembler) add(payload []byte, now time.Time) (*Payload, error) {
+	// Forget transfers the sender gave up on
+	for id, t := range r.transfers {
+		if now.Sub(t.updated) > ChunkTimeout {
+			log.Printf("Discarding incomplete transfer %d after %v: %d of %d bytes received",
+				id, ChunkTimeout, len(t.data), t.header.Total)
+			delete(r.transfers, id)
+		}
+	}
+
+	h, data, err := parseChunk(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.transfers[h.Transfer]
+	if h.Sequence == 0 {
+		// A new transfer replaces an unfinished one with the same ID
+		if h.Total == 0 || h.Total > MaxTransferLength {
+			return nil, fmt.Errorf("transfer %d: invalid length %d", h.Transfer, h.Total)
+		}
+		t = &transfer{header: h, data: make([]byte, 0, h.Total)}
+		r.transfers[h.Transfer] = t
+	} else if t == nil {
+		return nil, fmt.Errorf("transfer %d: chunk %d without a start", h.Transfer, h.Sequence)
+	}
+
+	if h.FrameID != t.header.FrameID || h.Total != t.header.Total || h.CRC != t.header.CRC {
+		delete(r.transfers, h.Transfer)
+		return nil, fmt.Errorf("transfer %d: chunk %d does not match the transfer", h.Transfer, h.Sequence)
+	}
+	if h.Sequence != t.next {
+		delete(r.transfers, h.Transfer)
+		return nil, fmt.Errorf("transfer %d: expected chunk %d, got %d", h.Transfer, t.next, h.Sequence)
+	}
+	if len(t.data)+len(data) > int(t.header.Total) {
+		delete(r.transfers, h.Transfer)
+		return nil, fmt.Errorf("transfer %d: chunk %d exceeds the length of %d bytes", h.Transfer, h.Sequence, t.header.Total)
+	}
+
+	t.data = append(t.data, data...)
+	t.next++
+	t.updated = now
+	if len(t.data) < int(t.header.Total) {
+		return nil, nil
+	}
+
+	delete(r.transfers, h.Transfer)
+	if crc := crc32.ChecksumIEEE(t.data); crc != t.header.CRC {
+		return nil, fmt.Errorf("transfer %d: CRC mismatch, expected 0x%08x, got 0x%08x", h.Transfer, t.header.CRC, crc)
+	}
+	return &Payload{ID: t.header.FrameID, Data: t.data, Size: len(t.data)}, nil
+}
+
+// WriteChunked sends data of up to MaxTransferLength bytes with the given
+// frame ID. Data that fits into a single frame is sent like WriteContext;
+// longer data is split into chunks queued at PriorityTelemetry, so other
+// traffic is not held up by a long transfer. It returns once the last chunk
+// has been written.
+func (u *USOCK) WriteChunked(ctx context.Context, frameID byte, data []byte) error {
+	if len(data) <= MaxPayloadLength {
+		return u.WriteContext(ctx, frameID, data)
+	}
+	if len(data) > MaxTransferLength {
+		return fmt.Errorf("payload size exceeds maximum transfer length of %d bytes", MaxTransferLength)
+	}
+
+	id := byte(u.nextTransfer.Add(1))
+	chunks := splitChunks(frameID, id, data)
+	log.Printf("TX Transfer %d: ID=0x%02x, Len=%d in %d chunks", id, frameID, len(data), len(chunks))
+	for i, chunk := range chunks {
+		if err := u.SendContext(ctx, PriorityTelemetry, ChunkFrameID, chunk); err != nil {
+			return fmt.Errorf("transfer %d: failed to send chunk %d of %d: %w", id, i+1, len(chunks), err)
+		}
+	}
+	return nil
+}
+
+// Reassembler turns received frames into the payloads USOCK hands to its
+// handler, collecting chunks into the payload they carry. It is meant for
+// frames received elsewhere as well, e.g. those of a capture, and is not
+// safe for concurrent use.
+type Reassembler struct {
+	chunks *reassembler
+}
+
+// NewReassembler creates a Reassembler without pending transfers
+func NewReassembler() *Reassembler {
+	return &Reassembler{chunks: newReassembler()}
+}
+
+// Add returns the payload of a frame received at now: the frame's own
+// payload, or for chunks the reassembled payload once the last chunk of a
+// transfer arrived and nil before that. Chunk ACKs return nil as well. A
+// broken transfer is discarded and reported as an error.
+func (r *Reassembler) Add(frameID byte, data []byte, now time.Time) (*Payload, error) {
+	if frameID != ChunkFrameID {
+		return &Payload{ID: frameID, Data: data, Size: len(data)}, nil
+	}
+	// Anything shorter than a chunk header is the ACK of a chunk
+	if len(data) < ChunkHeaderLength {
+		return nil, nil
+	}
+	payload, err := r.chunks.add(data, now)
+	if payload != nil {
+		log.Printf("RX Transfer complete: ID=0x%02x, Len=%d", payload.ID, payload.Size)
+	}
+	return payload, err
+}
@@ -0,0 +1,183 @@
+package usock
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + 3)
+	}
+	return data
+}
+
+// reassemble feeds chunks into a new reassembler and returns the completed
+// payloads and the number of errors
+func reassemble(chunks ...[]byte) ([]*Payload, int) {
+	r := newReassembler()
+	now := time.Now()
+	var payloads []*Payload
+	errors := 0
+	for _, c := range chunks {
+		p, err := r.add(c, now)
+		if err != nil {
+			errors++
+		}
+		if p != nil {
+			payloads = append(payloads, p)
+		}
+	}
+	return payloads, errors
+}
+
+func TestChunkRoundTrip(t *testing.T) {
+	for _, n := range []int{1, MaxChunkData, MaxChunkData + 1, 3 * MaxChunkData, 10000, MaxTransferLength} {
+		data := testData(n)
+		chunks := splitChunks(0x20, 7, data)
+		if want := (n + MaxChunkData - 1) / MaxChunkData; len(chunks) != want {
+			t.Errorf("%d bytes: got %d chunks, want %d", n, len(chunks), want)
+		}
+		for i, c := range chunks {
+			if len(c) > MaxPayloadLength {
+				t.Errorf("%d bytes: chunk %d has %d bytes", n, i, len(c))
+			}
+		}
+
+		payloads, errors := reassemble(chunks...)
+		if errors != 0 || len(payloads) != 1 {
+			t.Fatalf("%d bytes: got %d payloads and %d errors", n, len(payloads), errors)
+		}
+		if payloads[0].ID != 0x20 || !bytes.Equal(payloads[0].Data, data) {
+			t.Errorf("%d bytes: payload ID 0x%02x does not match", n, payloads[0].ID)
+		}
+	}
+}
+
+func TestChunkInterleavedTransfers(t *testing.T) {
+	a, b := testData(3000), testData(2500)
+	ca, cb := splitChunks(0x20, 1, a), splitChunks(0x40, 2, b)
+	payloads, errors := reassemble(ca[0], cb[0], ca[1], cb[1], cb[2], ca[2])
+	if errors != 0 || len(payloads) != 2 {
+		t.Fatalf("got %d payloads and %d errors", len(payloads), errors)
+	}
+	if payloads[0].ID != 0x40 || !bytes.Equal(payloads[0].Data, b) {
+		t.Errorf("first payload is not transfer 2")
+	}
+	if payloads[1].ID != 0x20 || !bytes.Equal(payloads[1].Data, a) {
+		t.Errorf("second payload is not transfer 1")
+	}
+}
+
+func TestChunkMissing(t *testing.T) {
+	chunks := splitChunks(0x20, 1, testData(3000))
+	payloads, errors := reassemble(chunks[0], chunks[2])
+	if len(payloads) != 0 || errors != 1 {
+		t.Errorf("got %d payloads and %d errors, want 0 and 1", len(payloads), errors)
+	}
+}
+
+func TestChunkWithoutStart(t *testing.T) {
+	chunks := splitChunks(0x20, 1, testData(3000))
+	payloads, errors := reassemble(chunks[1], chunks[2])
+	if len(payloads) != 0 || errors != 2 {
+		t.Errorf("got %d payloads and %d errors, want 0 and 2", len(payloads), errors)
+	}
+}
+
+func TestChunkRestart(t *testing.T) {
+	// A sender that starts over replaces the unfinished transfer
+	old := splitChunks(0x20, 1, testData(3000))
+	data := testData(2000)
+	restarted := splitChunks(0x20, 1, data)
+	payloads, errors := reassemble(old[0], old[1], restarted[0], restarted[1])
+	if errors != 0 || len(payloads) != 1 || !bytes.Equal(payloads[0].Data, data) {
+		t.Errorf("got %d payloads and %d errors, want the restarted transfer", len(payloads), errors)
+	}
+}
+
+func TestChunkCRCMismatch(t *testing.T) {
+	chunks := splitChunks(0x20, 1, testData(2000))
+	chunks[1][ChunkHeaderLength] ^= 0xFF
+	payloads, errors := reassemble(chunks...)
+	if len(payloads) != 0 || errors != 1 {
+		t.Errorf("got %d payloads and %d errors, want 0 and 1", len(payloads), errors)
+	}
+}
+
+func TestChunkHeaderMismatch(t *testing.T) {
+	a := splitChunks(0x20, 1, testData(3000))
+	b := splitChunks(0x20, 1, testData(2500))
+	payloads, errors := reassemble(a[0], b[1])
+	if len(payloads) != 0 || errors != 1 {
+		t.Errorf("got %d payloads and %d errors, want 0 and 1", len(payloads), errors)
+	}
+}
+
+func TestChunkInvalidLength(t *testing.T) {
+	chunk := splitChunks(0x20, 1, testData(100))[0]
+	chunk[4], chunk[5], chunk[6], chunk[7] = 0xFF, 0xFF, 0xFF, 0x00
+	if _, errors := reassemble(chunk); errors != 1 {
+		t.Errorf("oversize transfer accepted")
+	}
+	if _, errors := reassemble(chunk[:ChunkHeaderLength-1]); errors != 1 {
+		t.Errorf("short chunk accepted")
+	}
+}
+
+func TestChunkTimeout(t *testing.T) {
+	chunks := splitChunks(0x20, 1, testData(3000))
+	r := newReassembler()
+	now := time.Now()
+	if _, err := r.add(chunks[0], now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.add(chunks[1], now.Add(ChunkTimeout+time.Second)); err == nil {
+		t.Errorf("chunk of an expired transfer accepted")
+	}
+}
+
+func TestWriteChunked(t *testing.T) {
+	a, b := NewPipe()
+	received := make(chan *Payload, 4)
+	sender := NewWithTransport(a, nil)
+	defer sender.Close()
+	sender.SetWriteConfig(WriteConfig{Timeout: time.Second})
+	receiver := NewWithTransport(b, func(p *Payload) { received <- p })
+	defer receiver.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	short, long := testData(100), testData(5000)
+	if err := sender.WriteChunked(ctx, 0x20, short); err != nil {
+		t.Fatalf("WriteChunked: %v", err)
+	}
+	if err := sender.WriteChunked(ctx, 0x40, long); err != nil {
+		t.Fatalf("WriteChunked: %v", err)
+	}
+	if err := sender.WriteChunked(ctx, 0x40, testData(MaxTransferLength+1)); err == nil {
+		t.Errorf("WriteChunked accepted more than MaxTransferLength bytes")
+	}
+
+	for _, want := range []struct {
+		id   byte
+		data []byte
+	}{{0x20, short}, {0x40, long}} {
+		select {
+		case p := <-received:
+			if p.ID != want.id || !bytes.Equal(p.Data, want.data) {
+				t.Errorf("got payload ID 0x%02x with %d bytes, want ID 0x%02x with %d bytes",
+					p.ID, len(p.Data), want.id, len(want.data))
+			}
+		case <-ctx.Done():
+			t.Fatalf("payload ID 0x%02x not received", want.id)
+		}
+	}
+	if stats := receiver.Stats(); stats.ChunkErrors != 0 {
+		t.Errorf("got %d chunk errors", stats.ChunkErrors)
+	}
+}
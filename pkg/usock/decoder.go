@@ -0,0 +1,254 @@
+package usock
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// HeaderLength is the size of sync bytes, frame ID and payload length
+const HeaderLength = 5
+
+// DecodeErrorKind tells why a frame candidate was rejected
+type DecodeErrorKind int
+
+const (
+	DecodeErrOversize   DecodeErrorKind = iota + 1 // Payload length above MaxPayloadLength
+	DecodeErrHeaderCRC                             // Header CRC mismatch
+	DecodeErrPayloadCRC                            // Payload CRC mismatch
+)
+
+// String returns a short name of the error kind
+func (k DecodeErrorKind) String() string {
+	switch k {
+	case DecodeErrOversize:
+		return "oversize"
+	case DecodeErrHeaderCRC:
+		return "header crc"
+	case DecodeErrPayloadCRC:
+		return "payload crc"
+	default:
+		return fmt.Sprintf("DecodeErrorKind(%d)", int(k))
+	}
+}
+
+// DecodeError describes a rejected frame candidate
+type DecodeError struct {
+	Kind       DecodeErrorKind
+	FrameID    byte
+	PayloadLen uint16
+	Calculated uint16 // CRC computed over the received bytes (CRC errors only)
+	Received   uint16 // CRC found in the stream (CRC errors only)
+	Data       []byte // Header or payload bytes the CRC was computed over
+}
+
+func (e *DecodeError) Error() string {
+	switch e.Kind {
+	case DecodeErrOversize:
+		return fmt.Sprintf("invalid payload length: %d (max: %d)", e.PayloadLen, MaxPayloadLength)
+	case DecodeErrHeaderCRC:
+		return fmt.Sprintf("invalid header CRC: calculated=0x%04x, received=0x%04x", e.Calculated, e.Received)
+	default:
+		return fmt.Sprintf("invalid payload CRC: calculated=0x%04x, received=0x%04x", e.Calculated, e.Received)
+	}
+}
+
+// Decoder reassembles USOCK frames from a byte stream. It can be fed
+// arbitrarily sized chunks; frames split across chunks are carried over.
+//
+// When a frame candidate is rejected the decoder resynchronises on the byte
+// after its first sync byte, so a valid frame hidden inside a corrupted or
+// truncated one is still found.
+type Decoder struct {
+	// OnError, if set, is called for every rejected frame candidate
+	OnError func(*DecodeError)
+
+	// OnResync, if set, is called when the decoder loses frame alignment,
+	// i.e. it discards bytes or rejects a candidate after a valid frame
+	OnResync func()
+
+	state  State
+	frame  Frame
+	raw    []byte // All bytes of the current candidate, starting at SyncByte1
+	replay []byte // Bytes of a rejected candidate that still have to be rescanned
+	lost   bool   // Alignment was lost and no valid frame has been seen since
+}
+
+// NewDecoder creates a new Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{
+		state: StateSync1,
+		raw:   make([]byte, 0, 256),
+	}
+}
+
+// Reset drops any partially received frame
+func (d *Decoder) Reset() {
+	d.state = StateSync1
+	d.raw = d.raw[:0]
+	d.replay = nil
+	d.lost = false
+}
+
+// Buffered returns the number of bytes of an incomplete frame candidate,
+// e.g. of a truncated frame at the end of the stream
+func (d *Decoder) Buffered() int {
+	return len(d.raw) + len(d.replay)
+}
+
+// Feed processes a chunk of the stream and returns the frames it completed
+func (d *Decoder) Feed(data []byte) []Frame {
+	var frames []Frame
+	for len(data) > 0 || len(d.replay) > 0 {
+		var b byte
+		if len(d.replay) > 0 {
+			b = d.replay[0]
+			d.replay = d.replay[1:]
+		} else {
+			b = data[0]
+			data = data[1:]
+		}
+
+		if frame, ok := d.step(b); ok {
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+// step advances the state machine by one byte
+func (d *Decoder) step(b byte) (Frame, bool) {
+	switch d.state {
+	case StateSync1:
+		if b == SyncByte1 {
+			d.raw = append(d.raw[:0], b)
+			d.state = StateSync2
+		} else {
+			d.loseSync()
+		}
+	case StateSync2:
+		switch b {
+		case SyncByte2:
+			d.raw = append(d.raw, b)
+			d.state = StateFrameID
+		case SyncByte1:
+			// F6 F6 D9: the second F6 may start the real frame
+			d.raw = append(d.raw[:0], b)
+			d.loseSync()
+		default:
+			d.state = StateSync1
+			d.loseSync()
+		}
+	case StateFrameID:
+		d.raw = append(d.raw, b)
+		d.frame = Frame{ID: b}
+		d.state = StatePayloadLen1
+	case StatePayloadLen1:
+		d.raw = append(d.raw, b)
+		d.state = StatePayloadLen2
+	case StatePayloadLen2:
+		d.raw = append(d.raw, b)
+		d.frame.PayloadLen = binary.LittleEndian.Uint16(d.raw[3:5])
+		if d.frame.PayloadLen > MaxPayloadLength {
+			d.reject(&DecodeError{
+				Kind:       DecodeErrOversize,
+				FrameID:    d.frame.ID,
+				PayloadLen: d.frame.PayloadLen,
+			})
+			return Frame{}, false
+		}
+		d.state = StateHeaderCRC1
+	case StateHeaderCRC1:
+		d.raw = append(d.raw, b)
+		d.state = StateHeaderCRC2
+	case StateHeaderCRC2:
+		d.raw = append(d.raw, b)
+		d.frame.HeaderCRC = binary.LittleEndian.Uint16(d.raw[HeaderLength:])
+		if calculated := calculateCRC16(d.raw[:HeaderLength], 0); calculated != d.frame.HeaderCRC {
+			d.reject(&DecodeError{
+				Kind:       DecodeErrHeaderCRC,
+				FrameID:    d.frame.ID,
+				PayloadLen: d.frame.PayloadLen,
+				Calculated: calculated,
+				Received:   d.frame.HeaderCRC,
+				Data:       append([]byte(nil), d.raw[:HeaderLength]...),
+			})
+			return Frame{}, false
+		}
+		if d.frame.PayloadLen == 0 {
+			d.state = StatePayloadCRC1
+		} else {
+			d.state = StatePayload
+		}
+	case StatePayload:
+		d.raw = append(d.raw, b)
+		if len(d.raw)-HeaderLength-2 >= int(d.frame.PayloadLen) {
+			d.state = StatePayloadCRC1
+		}
+	case StatePayloadCRC1:
+		d.raw = append(d.raw, b)
+		d.state = StatePayloadCRC2
+	case StatePayloadCRC2:
+		d.raw = append(d.raw, b)
+		payload := d.raw[HeaderLength+2 : len(d.raw)-2]
+		d.frame.PayloadCRC = binary.LittleEndian.Uint16(d.raw[len(d.raw)-2:])
+		if calculated := calculateCRC16(payload, 0); calculated != d.frame.PayloadCRC {
+			d.reject(&DecodeError{
+				Kind:       DecodeErrPayloadCRC,
+				FrameID:    d.frame.ID,
+				PayloadLen: d.frame.PayloadLen,
+				Calculated: calculated,
+				Received:   d.frame.PayloadCRC,
+				Data:       append([]byte(nil), payload...),
+			})
+			return Frame{}, false
+		}
+
+		frame := d.frame
+		frame.Payload = append([]byte(nil), payload...)
+		d.state = StateSync1
+		d.raw = d.raw[:0]
+		d.lost = false
+		return frame, true
+	}
+	return Frame{}, false
+}
+
+// reject reports a bad candidate and rescans everything after its first sync byte
+func (d *Decoder) reject(err *DecodeError) {
+	if d.OnError != nil {
+		d.OnError(err)
+	}
+	d.loseSync()
+
+	rest := make([]byte, 0, len(d.raw)-1+len(d.replay))
+	rest = append(rest, d.raw[1:]...)
+	d.replay = append(rest, d.replay...)
+	d.raw = d.raw[:0]
+	d.state = StateSync1
+}
+
+// loseSync reports the first loss of alignment since the last valid frame
+func (d *Decoder) loseSync() {
+	if d.lost {
+		return
+	}
+	d.lost = true
+	if d.OnResync != nil {
+		d.OnResync()
+	}
+}
+
+// EncodeFrame builds the wire representation of a frame
+func EncodeFrame(frameID byte, payload []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadLength {
+		return nil, fmt.Errorf("payload size exceeds maximum length of %d bytes", MaxPayloadLength)
+	}
+
+	out := make([]byte, 0, HeaderLength+2+len(payload)+2)
+	out = append(out, SyncByte1, SyncByte2, frameID)
+	out = binary.LittleEndian.AppendUint16(out, uint16(len(payload)))
+	out = binary.LittleEndian.AppendUint16(out, calculateCRC16(out, 0))
+	out = append(out, payload...)
+	out = binary.LittleEndian.AppendUint16(out, calculateCRC16(payload, 0))
+	return out, nil
+}
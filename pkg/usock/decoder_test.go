@@ -0,0 +1,241 @@
+package usock
+
+import (
+	"bytes"
+	"testing"
+)
+
+func mustEncode(t testing.TB, frameID byte, payload []byte) []byte {
+	t.Helper()
+	frame, err := EncodeFrame(frameID, payload)
+	if err != nil {
+		t.Fatalf("EncodeFrame: %v", err)
+	}
+	return frame
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+type decodeResult struct {
+	frames []Frame
+	errors []DecodeErrorKind
+}
+
+func decodeAll(chunks ...[]byte) decodeResult {
+	var res decodeResult
+	d := NewDecoder()
+	d.OnError = func(err *DecodeError) {
+		res.errors = append(res.errors, err.Kind)
+	}
+	for _, c := range chunks {
+		res.frames = append(res.frames, d.Feed(c)...)
+	}
+	return res
+}
+
+func checkFrames(t *testing.T, got []Frame, want ...Frame) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d frames, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || !bytes.Equal(got[i].Payload, want[i].Payload) {
+			t.Errorf("frame %d: got ID=0x%02x payload=%x, want ID=0x%02x payload=%x",
+				i, got[i].ID, got[i].Payload, want[i].ID, want[i].Payload)
+		}
+	}
+}
+
+func checkErrors(t *testing.T, got []DecodeErrorKind, want ...DecodeErrorKind) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got errors %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got errors %v, want %v", got, want)
+		}
+	}
+}
+
+var (
+	ackPayload     = []byte{0xa1, 0x18, 0xc0, 0xa0}
+	versionPayload = []byte{0xa1, 0x19, 0xa0, 0x00, 0xa1, 0x19, 0xa0, 0x01, 0x63, 0x31, 0x2e, 0x30}
+)
+
+func TestDecoderSingleFrame(t *testing.T) {
+	res := decodeAll(mustEncode(t, 0xc0, ackPayload))
+	checkFrames(t, res.frames, Frame{ID: 0xc0, Payload: ackPayload})
+	checkErrors(t, res.errors)
+	if res.frames[0].PayloadLen != uint16(len(ackPayload)) {
+		t.Errorf("PayloadLen = %d, want %d", res.frames[0].PayloadLen, len(ackPayload))
+	}
+}
+
+func TestDecoderByteByByte(t *testing.T) {
+	stream := concat(mustEncode(t, 0xc0, ackPayload), mustEncode(t, 0x00, versionPayload))
+	var chunks [][]byte
+	for i := range stream {
+		chunks = append(chunks, stream[i:i+1])
+	}
+	res := decodeAll(chunks...)
+	checkFrames(t, res.frames, Frame{ID: 0xc0, Payload: ackPayload}, Frame{ID: 0x00, Payload: versionPayload})
+	checkErrors(t, res.errors)
+}
+
+func TestDecoderZeroLengthPayload(t *testing.T) {
+	res := decodeAll(concat(mustEncode(t, 0x20, nil), mustEncode(t, 0xc0, ackPayload)))
+	checkFrames(t, res.frames, Frame{ID: 0x20, Payload: []byte{}}, Frame{ID: 0xc0, Payload: ackPayload})
+	checkErrors(t, res.errors)
+}
+
+func TestDecoderResyncAfterGarbage(t *testing.T) {
+	garbage := []byte{0x00, 0xff, SyncByte1, 0x12, SyncByte1, SyncByte1, 0x42}
+	res := decodeAll(concat(garbage, mustEncode(t, 0xc0, ackPayload), garbage, mustEncode(t, 0x40, versionPayload)))
+	checkFrames(t, res.frames, Frame{ID: 0xc0, Payload: ackPayload}, Frame{ID: 0x40, Payload: versionPayload})
+}
+
+func TestDecoderRepeatedSyncByte(t *testing.T) {
+	res := decodeAll(concat([]byte{SyncByte1}, mustEncode(t, 0xc0, ackPayload)))
+	checkFrames(t, res.frames, Frame{ID: 0xc0, Payload: ackPayload})
+	checkErrors(t, res.errors)
+}
+
+func TestDecoderTruncatedPayload(t *testing.T) {
+	// The first frame is cut off halfway through its payload, so the decoder
+	// takes the start of the next frames as the rest of it. The payload CRC
+	// check fails and the frames hidden inside are recovered.
+	truncated := mustEncode(t, 0x00, versionPayload)[:HeaderLength+2+4]
+	next := mustEncode(t, 0xc0, ackPayload)
+	last := mustEncode(t, 0xe0, ackPayload)
+	res := decodeAll(concat(truncated, next, last))
+	checkFrames(t, res.frames, Frame{ID: 0xc0, Payload: ackPayload}, Frame{ID: 0xe0, Payload: ackPayload})
+	checkErrors(t, res.errors, DecodeErrPayloadCRC)
+}
+
+func TestDecoderTruncatedHeader(t *testing.T) {
+	truncated := mustEncode(t, 0x00, versionPayload)[:4]
+	res := decodeAll(concat(truncated, mustEncode(t, 0xc0, ackPayload)))
+	checkFrames(t, res.frames, Frame{ID: 0xc0, Payload: ackPayload})
+}
+
+func TestDecoderOversizeLength(t *testing.T) {
+	oversize := []byte{SyncByte1, SyncByte2, 0x00, 0x01, 0x08} // 0x0801 bytes
+	res := decodeAll(concat(oversize, mustEncode(t, 0xc0, ackPayload)))
+	checkFrames(t, res.frames, Frame{ID: 0xc0, Payload: ackPayload})
+	checkErrors(t, res.errors, DecodeErrOversize)
+}
+
+func TestDecoderMaxLength(t *testing.T) {
+	payload := bytes.Repeat([]byte{0x5a}, MaxPayloadLength)
+	res := decodeAll(mustEncode(t, 0x80, payload))
+	checkFrames(t, res.frames, Frame{ID: 0x80, Payload: payload})
+
+	if _, err := EncodeFrame(0x80, append(payload, 0)); err == nil {
+		t.Errorf("EncodeFrame accepted a payload above MaxPayloadLength")
+	}
+}
+
+func TestDecoderHeaderCRCFailure(t *testing.T) {
+	bad := mustEncode(t, 0xc0, ackPayload)
+	bad[HeaderLength] ^= 0xff
+	res := decodeAll(concat(bad, mustEncode(t, 0xe0, ackPayload)))
+	checkFrames(t, res.frames, Frame{ID: 0xe0, Payload: ackPayload})
+	checkErrors(t, res.errors, DecodeErrHeaderCRC)
+}
+
+func TestDecoderPayloadCRCFailure(t *testing.T) {
+	bad := mustEncode(t, 0xc0, ackPayload)
+	bad[len(bad)-1] ^= 0xff
+	res := decodeAll(bad, mustEncode(t, 0xe0, ackPayload))
+	checkFrames(t, res.frames, Frame{ID: 0xe0, Payload: ackPayload})
+	checkErrors(t, res.errors, DecodeErrPayloadCRC)
+}
+
+func TestDecoderReset(t *testing.T) {
+	d := NewDecoder()
+	frame := mustEncode(t, 0xc0, ackPayload)
+	d.Feed(frame[:6])
+	d.Reset()
+	checkFrames(t, d.Feed(frame), Frame{ID: 0xc0, Payload: ackPayload})
+}
+
+func FuzzDecoder(f *testing.F) {
+	f.Add(mustEncode(f, 0xc0, ackPayload), 3)
+	f.Add(concat([]byte{SyncByte1, SyncByte1}, mustEncode(f, 0x00, versionPayload)), 1)
+	f.Add(concat(mustEncode(f, 0x00, versionPayload)[:9], mustEncode(f, 0xc0, ackPayload)), 7)
+	f.Add([]byte{SyncByte1, SyncByte2, 0x00, 0xff, 0xff, 0x00, 0x00}, 2)
+
+	f.Fuzz(func(t *testing.T, data []byte, chunk int) {
+		whole := decodeAll(data)
+
+		// Chunking must not change the result
+		if chunk < 1 {
+			chunk = 1
+		}
+		var chunks [][]byte
+		for i := 0; i < len(data); i += chunk {
+			chunks = append(chunks, data[i:min(i+chunk, len(data))])
+		}
+		split := decodeAll(chunks...)
+		checkFrames(t, split.frames, whole.frames...)
+		checkErrors(t, split.errors, whole.errors...)
+
+		for _, frame := range whole.frames {
+			if len(frame.Payload) > MaxPayloadLength || int(frame.PayloadLen) != len(frame.Payload) {
+				t.Fatalf("invalid frame: len=%d payload=%d", frame.PayloadLen, len(frame.Payload))
+			}
+		}
+	})
+}
+
+func FuzzDecoderRoundTrip(f *testing.F) {
+	f.Add(byte(0xc0), ackPayload, []byte{0x00, 0x01})
+	f.Add(byte(0x00), versionPayload, []byte{SyncByte2, SyncByte2})
+	f.Add(byte(0x20), []byte{}, []byte{})
+
+	f.Fuzz(func(t *testing.T, frameID byte, payload []byte, garbage []byte) {
+		if len(payload) > MaxPayloadLength {
+			payload = payload[:MaxPayloadLength]
+		}
+		// Garbage without sync bytes can never start a frame candidate
+		garbage = bytes.ReplaceAll(garbage, []byte{SyncByte1}, nil)
+
+		res := decodeAll(concat(garbage, mustEncode(t, frameID, payload)))
+		checkFrames(t, res.frames, Frame{ID: frameID, Payload: payload})
+		checkErrors(t, res.errors)
+	})
+}
+
+func TestDecoderResyncs(t *testing.T) {
+	resyncs := 0
+	d := NewDecoder()
+	d.OnResync = func() { resyncs++ }
+
+	bad := mustEncode(t, 0xc0, ackPayload)
+	bad[len(bad)-1] ^= 0xff
+	good := mustEncode(t, 0xe0, ackPayload)
+
+	// Clean frames never lose alignment
+	d.Feed(concat(good, good))
+	if resyncs != 0 {
+		t.Fatalf("resyncs = %d after clean frames, want 0", resyncs)
+	}
+
+	// Garbage and a corrupted frame before the next valid one are one resync
+	d.Feed(concat([]byte{0x00, 0x01}, bad, []byte{0x02}, good))
+	if resyncs != 1 {
+		t.Fatalf("resyncs = %d, want 1", resyncs)
+	}
+
+	d.Feed(concat(bad, good))
+	if resyncs != 2 {
+		t.Fatalf("resyncs = %d, want 2", resyncs)
+	}
+}
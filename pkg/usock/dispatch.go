@@ -0,0 +1,165 @@
+package usock
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// OverflowPolicy decides what happens to a received frame when the dispatch queue is full
+type OverflowPolicy int
+
+const (
+	OverflowDropOldest OverflowPolicy = iota // Discard the oldest queued frame to make room
+	OverflowBlock                            // Stop reading until the handler catches up
+	OverflowDropNewest                       // Discard the frame that just arrived
+)
+
+// String returns the flag name of the policy
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop-newest"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+// ParseOverflowPolicy parses "drop-oldest", "block" or "drop-newest"
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "drop-oldest":
+		return OverflowDropOldest, nil
+	case "block":
+		return OverflowBlock, nil
+	case "drop-newest":
+		return OverflowDropNewest, nil
+	default:
+		return 0, fmt.Errorf("unknown overflow policy: %s", s)
+	}
+}
+
+// DispatchConfig controls the queue between the read loop and the frame handler
+type DispatchConfig struct {
+	QueueSize int
+	Overflow  OverflowPolicy
+}
+
+// DefaultDispatchConfig is used until SetDispatchConfig is called
+var DefaultDispatchConfig = DispatchConfig{
+	QueueSize: 64,
+	Overflow:  OverflowDropOldest,
+}
+
+// dispatcher hands received frames to the handler one at a time, in arrival order
+type dispatcher struct {
+	handler  func(*Payload)
+	stopChan chan struct{}
+
+	mu       sync.Mutex
+	cfg      DispatchConfig
+	queue    []*Payload
+	dropped  uint64
+	notEmpty chan struct{}
+	notFull  chan struct{}
+}
+
+func newDispatcher(handler func(*Payload), stopChan chan struct{}) *dispatcher {
+	return &dispatcher{
+		handler:  handler,
+		stopChan: stopChan,
+		cfg:      DefaultDispatchConfig,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+}
+
+// enqueue adds a frame to the queue, applying the overflow policy if it is full
+func (d *dispatcher) enqueue(p *Payload) {
+	d.mu.Lock()
+	for len(d.queue) >= d.cfg.QueueSize {
+		switch d.cfg.Overflow {
+		case OverflowDropNewest:
+			d.dropped++
+			d.mu.Unlock()
+			log.Printf("RX queue full, dropping new frame ID=0x%02x", p.ID)
+			return
+		case OverflowBlock:
+			d.mu.Unlock()
+			select {
+			case <-d.notFull:
+			case <-d.stopChan:
+				return
+			}
+			d.mu.Lock()
+		default:
+			log.Printf("RX queue full, dropping oldest frame ID=0x%02x", d.queue[0].ID)
+			d.queue[0] = nil
+			d.queue = d.queue[1:]
+			d.dropped++
+		}
+	}
+	d.queue = append(d.queue, p)
+	d.mu.Unlock()
+
+	wake(d.notEmpty)
+}
+
+// run calls the handler for every queued frame until stopChan is closed
+func (d *dispatcher) run() {
+	for {
+		d.mu.Lock()
+		if len(d.queue) == 0 {
+			d.mu.Unlock()
+			select {
+			case <-d.notEmpty:
+				continue
+			case <-d.stopChan:
+				return
+			}
+		}
+		p := d.queue[0]
+		d.queue[0] = nil
+		d.queue = d.queue[1:]
+		d.mu.Unlock()
+
+		wake(d.notFull)
+		d.handler(p)
+	}
+}
+
+// wake wakes up a waiter on ch without blocking
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// SetDispatchConfig changes the size and overflow policy of the receive queue
+func (u *USOCK) SetDispatchConfig(cfg DispatchConfig) {
+	if u.dispatcher == nil {
+		return
+	}
+	if cfg.QueueSize < 1 {
+		cfg.QueueSize = 1
+	}
+	u.dispatcher.mu.Lock()
+	u.dispatcher.cfg = cfg
+	u.dispatcher.mu.Unlock()
+	wake(u.dispatcher.notFull)
+}
+
+// DroppedFrames returns the number of received frames discarded because the queue was full
+func (u *USOCK) DroppedFrames() uint64 {
+	if u.dispatcher == nil {
+		return 0
+	}
+	u.dispatcher.mu.Lock()
+	defer u.dispatcher.mu.Unlock()
+	return u.dispatcher.dropped
+}
@@ -0,0 +1,126 @@
+package usock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDispatchOrder(t *testing.T) {
+	a, b := NewPipe()
+	sender := NewWithTransport(a, nil)
+	defer sender.Close()
+	sender.SetWriteConfig(WriteConfig{})
+	handled := make(chan byte, 100)
+	receiver := NewWithTransport(b, func(p *Payload) { handled <- p.Data[0] })
+	defer receiver.Close()
+	receiver.SetDispatchConfig(DispatchConfig{QueueSize: 8, Overflow: OverflowBlock})
+
+	for i := 0; i < 100; i++ {
+		if err := sender.Post(PriorityState, "", 0x20, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case got := <-handled:
+			if got != byte(i) {
+				t.Fatalf("frame %d handled as frame %d", got, i)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("frame %d not handled", i)
+		}
+	}
+	if n := receiver.DroppedFrames(); n != 0 {
+		t.Errorf("got %d dropped frames, want 0", n)
+	}
+}
+
+// TestDispatchOverflow fills a queue of two frames with four more while the
+// handler is busy with the first one
+func TestDispatchOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy  OverflowPolicy
+		handled []byte // After the first frame
+		dropped uint64
+	}{
+		{OverflowDropOldest, []byte{4, 5}, 2},
+		{OverflowDropNewest, []byte{2, 3}, 2},
+		{OverflowBlock, []byte{2, 3, 4, 5}, 0},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			stop := make(chan struct{})
+			defer close(stop)
+			gate := make(chan struct{})
+			handled := make(chan byte, 8)
+			d := newDispatcher(func(p *Payload) {
+				handled <- p.ID
+				if p.ID == 1 {
+					<-gate
+				}
+			}, stop)
+			d.cfg = DispatchConfig{QueueSize: 2, Overflow: tc.policy}
+			go d.run()
+
+			d.enqueue(&Payload{ID: 1})
+			if got := <-handled; got != 1 {
+				t.Fatalf("got frame %d first", got)
+			}
+			enqueued := make(chan struct{})
+			go func() {
+				defer close(enqueued)
+				for id := byte(2); id <= 5; id++ {
+					d.enqueue(&Payload{ID: id})
+				}
+			}()
+
+			select {
+			case <-enqueued:
+				if tc.policy == OverflowBlock {
+					t.Fatal("enqueued into a full queue without blocking")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tc.policy != OverflowBlock {
+					t.Fatal("enqueue blocked")
+				}
+			}
+			d.mu.Lock()
+			dropped := d.dropped
+			d.mu.Unlock()
+			if dropped != tc.dropped {
+				t.Errorf("got %d dropped frames, want %d", dropped, tc.dropped)
+			}
+
+			close(gate)
+			<-enqueued
+			var got []byte
+			for len(got) < len(tc.handled) {
+				select {
+				case id := <-handled:
+					got = append(got, id)
+				case <-time.After(time.Second):
+					t.Fatalf("handled %v, want %v", got, tc.handled)
+				}
+			}
+			select {
+			case id := <-handled:
+				t.Fatalf("handled %v and then %d, want %v", got, id, tc.handled)
+			case <-time.After(50 * time.Millisecond):
+			}
+			if !reflect.DeepEqual(got, tc.handled) {
+				t.Errorf("handled %v, want %v", got, tc.handled)
+			}
+		})
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDropOldest, OverflowBlock, OverflowDropNewest} {
+		if got, err := ParseOverflowPolicy(policy.String()); err != nil || got != policy {
+			t.Errorf("%s: got %v, %v", policy, got, err)
+		}
+	}
+	if _, err := ParseOverflowPolicy("drop-all"); err == nil {
+		t.Errorf("drop-all: expected an error")
+	}
+}
@@ -0,0 +1,96 @@
+package usock
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrHijacked is returned by Hijack while the transport is already handed over
+var ErrHijacked = errors.New("usock: transport is in exclusive use")
+
+// hijackState tracks a transport handed over with Hijack
+type hijackState struct {
+	port   Transport
+	paused chan struct{} // Closed by the read loop once it stopped reading
+	resume chan struct{} // Closed when the transport is handed back
+}
+
+// Hijack hands the transport to fn for exclusive use, e.g. to talk to a
+// bootloader with a different protocol. While fn runs, the read loop is
+// paused, the link is reported as down and writes fail with ErrLinkDown.
+// Afterwards the decoder is reset and the link is reported as up again.
+// The transport must support read deadlines.
+func (u *USOCK) Hijack(fn func(Transport) error) error {
+	u.mu.Lock()
+	if u.hijack != nil {
+		u.mu.Unlock()
+		return ErrHijacked
+	}
+	port := u.port
+	if port == nil {
+		u.mu.Unlock()
+		return ErrLinkDown
+	}
+	rd, ok := port.(readDeadliner)
+	if !ok {
+		u.mu.Unlock()
+		return fmt.Errorf("transport %T does not support exclusive access", port)
+	}
+	h := &hijackState{
+		port:   port,
+		paused: make(chan struct{}),
+		resume: make(chan struct{}),
+	}
+	u.hijack = h
+	u.port = nil
+	u.mu.Unlock()
+
+	// Wait for a frame that is being written right now
+	u.writeMu.Lock()
+	u.writeMu.Unlock()
+
+	// Kick the read loop out of its pending Read
+	rd.SetReadDeadline(time.Now())
+	select {
+	case <-h.paused:
+	case <-u.stopChan:
+		return ErrClosed
+	}
+	rd.SetReadDeadline(time.Time{})
+
+	err := fn(port)
+
+	u.mu.Lock()
+	select {
+	case <-u.stopChan:
+		// Close already closed the transport
+	default:
+		u.port = port
+	}
+	u.hijack = nil
+	u.mu.Unlock()
+	close(h.resume)
+
+	return err
+}
+
+// pauseForHijack parks the read loop while port is handed over. It returns
+// false if the USOCK was closed in the meantime.
+func (u *USOCK) pauseForHijack(h *hijackState) bool {
+	log.Printf("Transport handed over for exclusive use, pausing read loop")
+	u.notifyLink(false)
+	close(h.paused)
+
+	select {
+	case <-u.stopChan:
+		return false
+	case <-h.resume:
+	}
+
+	log.Printf("Transport handed back, link is up")
+	u.decoder.Reset()
+	u.notifyLink(true)
+	return true
+}
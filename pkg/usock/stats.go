@@ -0,0 +1,76 @@
+package usock
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LinkStats is a snapshot of the link quality counters of a USOCK connection.
+// Counters start at zero when the USOCK is created and survive reconnects.
+type LinkStats struct {
+	TXFrames         uint64
+	TXBytes          uint64 // Including framing
+	RXFrames         uint64 // Frames that passed both CRC checks
+	RXBytes          uint64 // Everything read from the transport, including garbage
+	HeaderCRCErrors  uint64
+	PayloadCRCErrors uint64
+	OversizeErrors   uint64 // Headers announcing more than MaxPayloadLength bytes
+	Resyncs          uint64 // Times the decoder lost frame alignment
+	WriteErrors      uint64
+	CoalescedFrames  uint64    // Queued frames replaced by a newer value before they were sent
+	DroppedFrames    uint64    // Received frames discarded by the dispatch queue
+	ChunkErrors      uint64    // Chunked transfers discarded as incomplete or corrupt
+	LastRX           time.Time // Zero if no valid frame has been received yet
+}
+
+// linkCounters holds the live counters behind LinkStats
+type linkCounters struct {
+	txFrames         atomic.Uint64
+	txBytes          atomic.Uint64
+	rxFrames         atomic.Uint64
+	rxBytes          atomic.Uint64
+	headerCRCErrors  atomic.Uint64
+	payloadCRCErrors atomic.Uint64
+	oversizeErrors   atomic.Uint64
+	resyncs          atomic.Uint64
+	writeErrors      atomic.Uint64
+	chunkErrors      atomic.Uint64
+	lastRX           atomic.Int64 // Unix nanoseconds
+}
+
+// Stats returns the current link statistics
+func (u *USOCK) Stats() LinkStats {
+	c := &u.counters
+	stats := LinkStats{
+		TXFrames:         c.txFrames.Load(),
+		TXBytes:          c.txBytes.Load(),
+		RXFrames:         c.rxFrames.Load(),
+		RXBytes:          c.rxBytes.Load(),
+		HeaderCRCErrors:  c.headerCRCErrors.Load(),
+		PayloadCRCErrors: c.payloadCRCErrors.Load(),
+		OversizeErrors:   c.oversizeErrors.Load(),
+		Resyncs:          c.resyncs.Load(),
+		WriteErrors:      c.writeErrors.Load(),
+		DroppedFrames:    u.DroppedFrames(),
+		ChunkErrors:      c.chunkErrors.Load(),
+	}
+	u.scheduler.mu.Lock()
+	stats.CoalescedFrames = u.scheduler.coalesced
+	u.scheduler.mu.Unlock()
+	if ns := c.lastRX.Load(); ns != 0 {
+		stats.LastRX = time.Unix(0, ns)
+	}
+	return stats
+}
+
+// countDecodeError updates the counter matching a rejected frame candidate
+func (c *linkCounters) countDecodeError(kind DecodeErrorKind) {
+	switch kind {
+	case DecodeErrOversize:
+		c.oversizeErrors.Add(1)
+	case DecodeErrHeaderCRC:
+		c.headerCRCErrors.Add(1)
+	case DecodeErrPayloadCRC:
+		c.payloadCRCErrors.Add(1)
+	}
+}
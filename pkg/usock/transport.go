@@ -0,0 +1,117 @@
+package usock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// Transport is the byte stream USOCK frames are carried over.
+// Anything that can read, write and close can be used: a UART, a PTY,
+// a TCP connection to a remote board or an in-memory pipe.
+//
+// Transports that also implement SetWriteDeadline (all of the above on
+// Linux) get bounded writes, and only those implementing SetReadDeadline
+// can be handed over with Hijack. Close must make a pending Read return.
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// writeDeadliner is implemented by transports whose writes can time out
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// readDeadliner is implemented by transports whose reads can be interrupted
+// without closing them
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// Dialer opens the transport for a USOCK connection. It is called again
+// every time the link has been lost.
+type Dialer func() (Transport, error)
+
+// Reconnect backoff bounds
+const (
+	ReconnectMinBackoff = 500 * time.Millisecond
+	ReconnectMaxBackoff = 30 * time.Second
+)
+
+// ErrLinkDown is returned by writes while the transport is not open
+var ErrLinkDown = errors.New("usock: link is down")
+
+// SerialDialer returns a Dialer that opens the given serial device
+func SerialDialer(devicePath string, baudRate int) Dialer {
+	return func() (Transport, error) {
+		return OpenSerial(devicePath, baudRate)
+	}
+}
+
+// OpenSerial opens a serial device with 8N1 framing at the given baud rate
+func OpenSerial(devicePath string, baudRate int) (Transport, error) {
+	// First clear UART attributes to ensure a clean start
+	if err := clearUARTAttributes(devicePath); err != nil {
+		return nil, fmt.Errorf("failed to clear UART attributes: %v", err)
+	}
+
+	return openSerialPort(devicePath, baudRate)
+}
+
+// clearUARTAttributes clears the UART attributes to ensure a clean start
+func clearUARTAttributes(devicePath string) error {
+	// With tarm/serial, we can't directly manipulate the terminal attributes
+	// Instead, we'll open the port with default settings and then close it
+	config := &serial.Config{
+		Name:        devicePath,
+		Baud:        9600,
+		Size:        8,
+		Parity:      serial.ParityNone,
+		StopBits:    serial.Stop1,
+		ReadTimeout: 0,
+	}
+
+	port, err := serial.OpenPort(config)
+	if err != nil {
+		return fmt.Errorf("failed to open serial port for attribute clearing: %v", err)
+	}
+
+	// Close the port to release resources
+	err = port.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close serial port after attribute clearing: %v", err)
+	}
+
+	// Wait a moment for the port to fully close
+	time.Sleep(100 * time.Millisecond)
+
+	return nil
+}
+
+// DialTCP connects to a USOCK stream exposed over TCP, e.g. a ser2net
+// bridge on a remote board or a simulator
+func DialTCP(addr string, timeout time.Duration) (Transport, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+	return conn, nil
+}
+
+// TCPDialer returns a Dialer that connects to addr
+func TCPDialer(addr string, timeout time.Duration) Dialer {
+	return func() (Transport, error) {
+		return DialTCP(addr, timeout)
+	}
+}
+
+// NewPipe returns the two ends of a synchronous in-memory transport.
+// Bytes written to one end can be read from the other.
+func NewPipe() (Transport, Transport) {
+	a, b := net.Pipe()
+	return a, b
+}
@@ -0,0 +1,123 @@
+//go:build linux
+
+package usock
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// PTY is the master side of a pseudo-terminal pair. The slave side is
+// kept open in raw mode so that the master never sees EIO while no peer
+// has the slave device open.
+type PTY struct {
+	master *os.File
+	slave  *os.File
+	name   string
+}
+
+// OpenPTY creates a new pseudo-terminal pair and returns its master side.
+// Peers connect by opening the device returned by Name, e.g. /dev/pts/3.
+func OpenPTY() (*PTY, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /dev/ptmx: %v", err)
+	}
+
+	var ptyNum uint32
+	if err := fileIoctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(new(int32))); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to unlock pty: %v", err)
+	}
+	if err := fileIoctl(master, syscall.TIOCGPTN, unsafe.Pointer(&ptyNum)); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to get pty number: %v", err)
+	}
+	name := fmt.Sprintf("/dev/pts/%d", ptyNum)
+
+	slave, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to open pty slave %s: %v", name, err)
+	}
+
+	// Put the line discipline into raw mode so frames pass through untouched
+	var t syscall.Termios
+	if err := fileIoctl(slave, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		slave.Close()
+		master.Close()
+		return nil, fmt.Errorf("failed to get pty attributes: %v", err)
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := fileIoctl(slave, syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		slave.Close()
+		master.Close()
+		return nil, fmt.Errorf("failed to set pty attributes: %v", err)
+	}
+
+	return &PTY{
+		master: master,
+		slave:  slave,
+		name:   name,
+	}, nil
+}
+
+// Name returns the path of the slave device
+func (p *PTY) Name() string {
+	return p.name
+}
+
+// Read reads from the master side
+func (p *PTY) Read(b []byte) (int, error) {
+	return p.master.Read(b)
+}
+
+// Write writes to the master side
+func (p *PTY) Write(b []byte) (int, error) {
+	return p.master.Write(b)
+}
+
+// SetReadDeadline sets the deadline for reads from the master side
+func (p *PTY) SetReadDeadline(t time.Time) error {
+	return p.master.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writes to the master side
+func (p *PTY) SetWriteDeadline(t time.Time) error {
+	return p.master.SetWriteDeadline(t)
+}
+
+// Close closes both sides of the pseudo-terminal
+func (p *PTY) Close() error {
+	p.slave.Close()
+	return p.master.Close()
+}
+
+// fileIoctl issues an ioctl without switching the file into blocking mode,
+// which would prevent Close from interrupting a pending Read.
+func fileIoctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package usock
+
+import "fmt"
+
+// PTY is the master side of a pseudo-terminal pair
+type PTY struct{}
+
+// OpenPTY is only supported on Linux
+func OpenPTY() (*PTY, error) {
+	return nil, fmt.Errorf("pseudo-terminals are not supported on this platform")
+}
+
+// Name returns the path of the slave device
+func (p *PTY) Name() string { return "" }
+
+// Read reads from the master side
+func (p *PTY) Read(b []byte) (int, error) { return 0, fmt.Errorf("pty not supported") }
+
+// Write writes to the master side
+func (p *PTY) Write(b []byte) (int, error) { return 0, fmt.Errorf("pty not supported") }
+
+// Close closes both sides of the pseudo-terminal
+func (p *PTY) Close() error { return nil }
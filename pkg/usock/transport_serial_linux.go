@@ -0,0 +1,59 @@
+//go:build linux
+
+package usock
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var baudRates = map[int]uint32{
+	9600:    syscall.B9600,
+	19200:   syscall.B19200,
+	38400:   syscall.B38400,
+	57600:   syscall.B57600,
+	115200:  syscall.B115200,
+	230400:  syscall.B230400,
+	460800:  syscall.B460800,
+	500000:  syscall.B500000,
+	576000:  syscall.B576000,
+	921600:  syscall.B921600,
+	1000000: syscall.B1000000,
+	1152000: syscall.B1152000,
+	1500000: syscall.B1500000,
+	2000000: syscall.B2000000,
+}
+
+// openSerialPort opens the device in non-blocking mode so that reads and
+// writes go through the runtime poller. Unlike tarm/serial, which switches
+// the descriptor back to blocking mode, this lets Close interrupt a pending
+// Read and makes read and write deadlines work.
+func openSerialPort(devicePath string, baudRate int) (Transport, error) {
+	rate, ok := baudRates[baudRate]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate: %d", baudRate)
+	}
+
+	f, err := os.OpenFile(devicePath, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open serial port: %v", err)
+	}
+
+	// 8N1, raw, reads return as soon as one byte is available
+	t := syscall.Termios{
+		Iflag:  syscall.IGNPAR,
+		Cflag:  syscall.CREAD | syscall.CLOCAL | syscall.CS8 | rate,
+		Ispeed: rate,
+		Ospeed: rate,
+	}
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := fileIoctl(f, syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to set serial port attributes: %v", err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+package usock
+
+import (
+	"fmt"
+
+	"github.com/tarm/serial"
+)
+
+// openSerialPort opens the device through tarm/serial. Reads cannot be
+// interrupted and deadlines are not supported on these platforms.
+func openSerialPort(devicePath string, baudRate int) (Transport, error) {
+	config := &serial.Config{
+		Name:        devicePath,
+		Baud:        baudRate,
+		Size:        8,
+		Parity:      serial.ParityNone,
+		StopBits:    serial.Stop1,
+		ReadTimeout: 0,
+	}
+
+	port, err := serial.OpenPort(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open serial port: %v", err)
+	}
+
+	return port, nil
+}
@@ -1,15 +1,15 @@
 package usock
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/tarm/serial"
 )
 
 const (
@@ -18,6 +18,12 @@ const (
 	SyncByte2       = 0xD9
 )
 
+// readBufferSize is the chunk size of reads from the transport
+const readBufferSize = 512
+
+// CloseTimeout bounds how long Close waits for the read and write loops
+const CloseTimeout = 2 * time.Second
+
 // State machine states
 const (
 	StateSync1 = iota
@@ -53,14 +59,30 @@ type Payload struct {
 
 // USOCK represents a UART socket connection to the nRF52
 type USOCK struct {
-	port     *serial.Port
-	handler  func(*Payload)
-	stopChan chan struct{}
-	wg       sync.WaitGroup
-	state    State
-	frame    Frame
-	buffer   []byte
-	mu       sync.Mutex
+	port        Transport // nil while the link is down
+	dial        Dialer    // nil if the transport cannot be reopened
+	dispatcher  *dispatcher // nil without a handler
+	scheduler   *writeScheduler
+	linkHandler func(up bool)
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+	decoder     *Decoder     // Only touched by the read loop
+	chunks      *Reassembler // Only touched by the read loop
+	mu          sync.Mutex
+	writeMu     sync.Mutex   // Held while a frame is being written
+	hijack      *hijackState // Set while the transport is handed over, guarded by mu
+
+	ackConfig  AckConfig
+	frameLocks [256]sync.Mutex // Serialises acknowledged requests per frame ID
+	ackMu      sync.Mutex
+	acks       ackTracker // Frames waiting for their ACK, see deliverAck
+
+	recorder  *FlightRecorder
+	capture   atomic.Pointer[CaptureWriter]
+	crcErrors []time.Time // Recent CRC errors, only touched by the read loop
+
+	counters     linkCounters
+	nextTransfer atomic.Uint32 // Transfer ID of the last chunked write
 }
 
 // CRC-16/ARC lookup table
@@ -89,131 +111,130 @@ var crc16Table = []uint16{
 	0x4100, 0x81C1, 0x8081, 0x4040,
 }
 
-// New creates a new USOCK connection
+// New opens the serial device and creates a new USOCK connection on it.
+// If the device disappears later on, it is reopened automatically.
 func New(devicePath string, baudRate int, handler func(*Payload)) (*USOCK, error) {
-	// First clear UART attributes to ensure a clean start
-	if err := clearUARTAttributes(devicePath); err != nil {
-		return nil, fmt.Errorf("failed to clear UART attributes: %v", err)
+	dial := SerialDialer(devicePath, baudRate)
+	port, err := dial()
+	if err != nil {
+		return nil, err
 	}
 
-	config := &serial.Config{
-		Name:        devicePath,
-		Baud:        baudRate,
-		Size:        8,
-		Parity:      serial.ParityNone,
-		StopBits:    serial.Stop1,
-		ReadTimeout: 0,
-	}
+	return newUSOCK(port, dial, handler, nil), nil
+}
 
-	// Open the port
-	port, err := serial.OpenPort(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open serial port: %v", err)
-	}
+// NewWithTransport creates a new USOCK connection on an already opened transport.
+// The USOCK takes ownership of the transport and closes it on Close.
+// The transport is not reopened if it fails.
+func NewWithTransport(port Transport, handler func(*Payload)) *USOCK {
+	return newUSOCK(port, nil, handler, nil)
+}
+
+// NewWithDialer creates a new USOCK connection that opens its transport through dial.
+// It returns immediately: if the transport cannot be opened, or is lost later on,
+// it is (re)opened in the background with exponential backoff. linkHandler, if not
+// nil, is called from the read loop on every link state change and must not block.
+func NewWithDialer(dial Dialer, handler func(*Payload), linkHandler func(up bool)) *USOCK {
+	return newUSOCK(nil, dial, handler, linkHandler)
+}
 
+func newUSOCK(port Transport, dial Dialer, handler func(*Payload), linkHandler func(up bool)) *USOCK {
 	// Create USOCK instance
 	usock := &USOCK{
-		port:     port,
-		handler:  handler,
-		stopChan: make(chan struct{}),
-		state:    StateSync1,
-		buffer:   make([]byte, 0, 256),
+		port:        port,
+		dial:        dial,
+		linkHandler: linkHandler,
+		stopChan:    make(chan struct{}),
+		ackConfig:   DefaultAckConfig,
+		recorder:    NewFlightRecorder(FlightRecorderSize),
+		decoder:     NewDecoder(),
+		chunks:      NewReassembler(),
+		scheduler:   newWriteScheduler(),
+	}
+	usock.decoder.OnError = usock.handleDecodeError
+	usock.decoder.OnResync = func() { usock.counters.resyncs.Add(1) }
+
+	// Frames are handled one at a time in arrival order
+	if handler != nil {
+		usock.dispatcher = newDispatcher(handler, usock.stopChan)
+		usock.wg.Add(1)
+		go func() {
+			defer usock.wg.Done()
+			usock.dispatcher.run()
+		}()
 	}
 
-	// Start read loop
-	usock.wg.Add(1)
+	// Start read and write loops
+	usock.wg.Add(2)
 	go usock.readLoop()
+	go usock.writeLoop()
 
-	return usock, nil
+	return usock
 }
 
-// clearUARTAttributes clears the UART attributes to ensure a clean start
-func clearUARTAttributes(devicePath string) error {
-	// With tarm/serial, we can't directly manipulate the terminal attributes
-	// Instead, we'll open the port with default settings and then close it
-	config := &serial.Config{
-		Name:        devicePath,
-		Baud:        9600,
-		Size:        8,
-		Parity:      serial.ParityNone,
-		StopBits:    serial.Stop1,
-		ReadTimeout: 0,
-	}
-	
-	port, err := serial.OpenPort(config)
-	if err != nil {
-		return fmt.Errorf("failed to open serial port for attribute clearing: %v", err)
-	}
-	
-	// Close the port to release resources
-	err = port.Close()
-	if err != nil {
-		return fmt.Errorf("failed to close serial port after attribute clearing: %v", err)
-	}
-	
-	// Wait a moment for the port to fully close
-	time.Sleep(100 * time.Millisecond)
-	
-	return nil
+// WriteWithFrameID sends data to the nRF52 with a specific frame ID.
+// The frame is queued at PriorityState and the call returns once it has been written.
+func (u *USOCK) WriteWithFrameID(frameID byte, data []byte) error {
+	return u.WriteContext(context.Background(), frameID, data)
 }
 
-// WriteWithFrameID sends data to the nRF52 with a specific frame ID
-func (u *USOCK) WriteWithFrameID(frameID byte, data []byte) error {
-	u.mu.Lock()
-	defer u.mu.Unlock()
+// WriteContext is like WriteWithFrameID but gives up when ctx is done.
+// A frame that has not been written yet is then dropped from the queue.
+func (u *USOCK) WriteContext(ctx context.Context, frameID byte, data []byte) error {
+	return u.SendContext(ctx, PriorityState, frameID, data)
+}
 
+// writeFrame encodes and writes a frame to the transport. It is only called by the write loop.
+// u.mu is not held while writing, so a stalled transport cannot block Close.
+func (u *USOCK) writeFrame(frameID byte, data []byte, pending *pendingAck, timeout time.Duration) error {
 	if len(data) > MaxPayloadLength {
 		return fmt.Errorf("payload size exceeds maximum length of %d bytes", MaxPayloadLength)
 	}
 
-	// Create a frame with the specified ID
-	frame := Frame{
-		ID:         frameID,
-		PayloadLen: uint16(len(data)),
-		Payload:    data,
-	}
-
-	// Construct the header first (sync bytes + frame ID + payload length)
-	header := []byte{SyncByte1, SyncByte2, frame.ID}
-	lenBytes := make([]byte, 2)
-	binary.LittleEndian.PutUint16(lenBytes, frame.PayloadLen)
-	header = append(header, lenBytes...)
+	// writeMu is held until the frame is out so Hijack can wait for it
+	u.writeMu.Lock()
+	defer u.writeMu.Unlock()
 
-	// Calculate header CRC over the entire header at once
-	frame.HeaderCRC = calculateCRC16(header, 0)
+	u.mu.Lock()
+	port := u.port
+	u.mu.Unlock()
+	if port == nil {
+		return ErrLinkDown
+	}
 
-	// Calculate payload CRC over the entire payload at once
-	frame.PayloadCRC = calculateCRC16(frame.Payload, 0)
+	completeFrame, err := EncodeFrame(frameID, data)
+	if err != nil {
+		return err
+	}
+	headerCRC := binary.LittleEndian.Uint16(completeFrame[HeaderLength:])
+	payloadCRC := binary.LittleEndian.Uint16(completeFrame[len(completeFrame)-2:])
 
 	// Log detailed frame information
 	log.Printf("TX Frame: ID=0x%02x, Len=%d, HeaderCRC=0x%04x, PayloadCRC=0x%04x", 
-		frame.ID, frame.PayloadLen, frame.HeaderCRC, frame.PayloadCRC)
+		frameID, len(data), headerCRC, payloadCRC)
 	
 	// Log the payload in hex format for debugging
-	log.Printf("TX Payload: %s", hex.EncodeToString(frame.Payload))
+	log.Printf("TX Payload: %s", hex.EncodeToString(data))
 
-	// Construct the complete frame in a single buffer to send all at once
-	completeFrame := make([]byte, 0, 7+len(frame.Payload)+2) // 7 bytes header + payload + 2 bytes CRC
-	
-	// Add header (sync bytes + frame ID + payload length)
-	completeFrame = append(completeFrame, header...)
-	
-	// Add header CRC (little-endian)
-	completeFrame = append(completeFrame, byte(frame.HeaderCRC&0xFF), byte((frame.HeaderCRC>>8)&0xFF))
-	
-	// Add payload
-	completeFrame = append(completeFrame, frame.Payload...)
-	
-	// Add payload CRC (little-endian)
-	completeFrame = append(completeFrame, byte(frame.PayloadCRC&0xFF), byte((frame.PayloadCRC>>8)&0xFF))
-	
 	// Log the complete frame in hex format for debugging
 	log.Printf("TX Complete Frame: %s", hex.EncodeToString(completeFrame))
 	
+	// Record before writing so the capture keeps TX/RX order when the reply is fast
+	u.recordFrame(DirTX, frameID, data, "")
+	u.expectAck(frameID, pending)
+
+	if d, ok := port.(writeDeadliner); ok && timeout > 0 {
+		d.SetWriteDeadline(time.Now().Add(timeout))
+	}
+
 	// Write the complete frame in a single operation
-	if _, err := u.port.Write(completeFrame); err != nil {
+	if _, err := port.Write(completeFrame); err != nil {
+		u.cancelAck(frameID, pending)
+		u.counters.writeErrors.Add(1)
 		return fmt.Errorf("failed to write frame: %v", err)
 	}
+	u.counters.txFrames.Add(1)
+	u.counters.txBytes.Add(uint64(len(completeFrame)))
 
 	return nil
 }
@@ -232,153 +253,199 @@ func (u *USOCK) Write(data []byte) error {
 	return u.WriteWithFrameID(frameID, payload)
 }
 
-// Close closes the USOCK connection
+// Close closes the USOCK connection. It waits at most CloseTimeout for the
+// read and write loops to finish, so a wedged transport cannot block shutdown.
 func (u *USOCK) Close() error {
 	close(u.stopChan)
-	u.wg.Wait()
-	return u.port.Close()
+
+	// Close the transport first so a pending Read returns
+	u.mu.Lock()
+	port := u.port
+	u.port = nil
+	if u.hijack != nil {
+		port = u.hijack.port
+	}
+	u.mu.Unlock()
+
+	var err error
+	if port != nil {
+		err = port.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		u.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(CloseTimeout):
+		log.Printf("USOCK goroutines did not stop within %v, giving up", CloseTimeout)
+	}
+	return err
+}
+
+// IsConnected reports whether the transport is currently open
+func (u *USOCK) IsConnected() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.port != nil
 }
 
-// readLoop continuously reads from the serial port
+// readLoop reads from the transport and reopens it when it is lost
 func (u *USOCK) readLoop() {
 	defer u.wg.Done()
 
-	buf := make([]byte, 1) // Read one byte at a time for more precise control
-	log.Printf("Starting serial read loop")
+	backoff := ReconnectMinBackoff
+
+	u.mu.Lock()
+	port := u.port
+	u.mu.Unlock()
+	if port != nil {
+		u.notifyLink(true)
+	}
 
 	for {
-		select {
-		case <-u.stopChan:
-			return
-		default:
-			// Use blocking read with no timeout
-			n, err := u.port.Read(buf)
+		if port == nil {
+			if u.dial == nil {
+				log.Printf("Transport lost and cannot be reopened, stopping read loop")
+				return
+			}
+
+			var err error
+			port, err = u.dial()
 			if err != nil {
-				if err != io.EOF {
-					log.Printf("Error reading from serial port: %v", err)
-					time.Sleep(10 * time.Millisecond)
+				log.Printf("Failed to open transport: %v (retrying in %v)", err, backoff)
+				select {
+				case <-u.stopChan:
+					return
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				if backoff > ReconnectMaxBackoff {
+					backoff = ReconnectMaxBackoff
 				}
 				continue
 			}
 
-			if n == 0 {
-				continue
+			u.mu.Lock()
+			select {
+			case <-u.stopChan:
+				u.mu.Unlock()
+				port.Close()
+				return
+			default:
 			}
+			u.port = port
+			u.mu.Unlock()
 
-			// Process the received byte
-			b := buf[0]
-			u.processByte(b)
+			log.Printf("Transport opened, link is up")
+			backoff = ReconnectMinBackoff
+			u.decoder.Reset()
+			u.notifyLink(true)
 		}
-	}
-}
 
-// processByte processes a single byte through the state machine
-func (u *USOCK) processByte(b byte) {
-	// Process the byte based on the current state
-	switch u.state {
-	case StateSync1:
-		if b == SyncByte1 {
-			u.state = StateSync2
-			u.buffer = u.buffer[:0] // Clear buffer
-			u.buffer = append(u.buffer, b)
-		}
-	case StateSync2:
-		if b == SyncByte2 {
-			u.state = StateFrameID
-			u.buffer = append(u.buffer, b)
-		} else {
-			u.state = StateSync1
-		}
-	case StateFrameID:
-		u.frame.ID = b
-		u.buffer = append(u.buffer, b)
-		u.state = StatePayloadLen1
-	case StatePayloadLen1:
-		u.frame.PayloadLen = uint16(b)
-		u.buffer = append(u.buffer, b)
-		u.state = StatePayloadLen2
-	case StatePayloadLen2:
-		u.frame.PayloadLen |= uint16(b) << 8
-		u.buffer = append(u.buffer, b)
-		u.state = StateHeaderCRC1
-		
-		// Calculate header CRC over the entire header at once
-		u.frame.HeaderCRC = calculateCRC16(u.buffer, 0)
-		
-		// Validate payload length
-		if u.frame.PayloadLen > MaxPayloadLength {
-			log.Printf("RX Error: Invalid payload length: %d (max: %d)", 
-				u.frame.PayloadLen, MaxPayloadLength)
-			u.state = StateSync1
-		}
-	case StateHeaderCRC1:
-		u.frame.HeaderCRC = uint16(b)
-		u.state = StateHeaderCRC2
-	case StateHeaderCRC2:
-		u.frame.HeaderCRC |= uint16(b) << 8
-		
-		// Calculate CRC for the header (sync bytes + frame ID + payload length)
-		calculatedCRC := calculateCRC16(u.buffer, 0)
-		
-		// Validate header CRC
-		if calculatedCRC != u.frame.HeaderCRC {
-			log.Printf("RX Error: Invalid header CRC: calculated=0x%04x, received=0x%04x", 
-				calculatedCRC, u.frame.HeaderCRC)
-			u.state = StateSync1
+		err := u.readFrom(port)
+
+		select {
+		case <-u.stopChan:
 			return
+		default:
 		}
-		
-		// Prepare for payload
-		u.frame.Payload = make([]byte, 0, u.frame.PayloadLen)
-		u.buffer = u.buffer[:0] // Clear buffer for payload CRC calculation
-		u.state = StatePayload
-	case StatePayload:
-		u.frame.Payload = append(u.frame.Payload, b)
-		u.buffer = append(u.buffer, b)
-		
-		// Check if we've received the entire payload
-		if uint16(len(u.frame.Payload)) >= u.frame.PayloadLen {
-			u.state = StatePayloadCRC1
-			// Calculate payload CRC over the entire payload at once
-			u.frame.PayloadCRC = calculateCRC16(u.buffer, 0)
+
+		u.mu.Lock()
+		h := u.hijack
+		u.mu.Unlock()
+		if h != nil && h.port == port {
+			if !u.pauseForHijack(h) {
+				return
+			}
+			continue
 		}
-	case StatePayloadCRC1:
-		u.frame.PayloadCRC = uint16(b)
-		u.state = StatePayloadCRC2
-	case StatePayloadCRC2:
-		u.frame.PayloadCRC |= uint16(b) << 8
-		
-		// Calculate CRC for the payload
-		calculatedCRC := calculateCRC16(u.buffer, 0)
-		
-		// Validate payload CRC
-		if calculatedCRC != u.frame.PayloadCRC {
-			log.Printf("RX Error: Invalid payload CRC: calculated=0x%04x, received=0x%04x", 
-				calculatedCRC, u.frame.PayloadCRC)
-			u.state = StateSync1
-			return
+
+		log.Printf("Link lost: %v", err)
+		u.mu.Lock()
+		if u.port == port {
+			u.port = nil
 		}
-		
-		// Log successful frame reception with detailed information
-		log.Printf("RX Frame: ID=0x%02x, Len=%d, HeaderCRC=0x%04x, PayloadCRC=0x%04x", 
-			u.frame.ID, u.frame.PayloadLen, u.frame.HeaderCRC, u.frame.PayloadCRC)
-		log.Printf("RX Payload: %s", hex.EncodeToString(u.frame.Payload))
-		
-		// Create a copy of the payload to avoid data races
-		payload := make([]byte, len(u.frame.Payload))
-		copy(payload, u.frame.Payload)
-		
-		// Call the callback with the payload
-		if u.handler != nil {
-			go u.handler(&Payload{
-				ID:   u.frame.ID,
-				Data: payload,
-				Size: len(payload),
-			})
+		u.mu.Unlock()
+		port.Close()
+		port = nil
+		u.notifyLink(false)
+	}
+}
+
+// readFrom feeds bytes from port into the decoder until a read fails
+func (u *USOCK) readFrom(port Transport) error {
+	buf := make([]byte, readBufferSize)
+	log.Printf("Starting serial read loop")
+
+	for {
+		select {
+		case <-u.stopChan:
+			return nil
+		default:
+			// Use blocking read with no timeout, returns as soon as any bytes are available
+			n, err := port.Read(buf)
+			if n > 0 {
+				u.counters.rxBytes.Add(uint64(n))
+				for _, frame := range u.decoder.Feed(buf[:n]) {
+					u.handleFrame(frame)
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					return fmt.Errorf("transport closed by peer")
+				}
+				return err
+			}
 		}
-		
-		// Reset state machine
-		u.state = StateSync1
+	}
+}
+
+// notifyLink reports a link state change to the link handler
+func (u *USOCK) notifyLink(up bool) {
+	if u.linkHandler != nil {
+		u.linkHandler(up)
+	}
+}
+
+// handleFrame passes a complete, CRC checked frame on to the ACK tracker and the handler
+func (u *USOCK) handleFrame(frame Frame) {
+	// Log successful frame reception with detailed information
+	log.Printf("RX Frame: ID=0x%02x, Len=%d, HeaderCRC=0x%04x, PayloadCRC=0x%04x", 
+		frame.ID, frame.PayloadLen, frame.HeaderCRC, frame.PayloadCRC)
+	log.Printf("RX Payload: %s", hex.EncodeToString(frame.Payload))
+	u.recordFrame(DirRX, frame.ID, frame.Payload, "")
+	u.counters.rxFrames.Add(1)
+	u.counters.lastRX.Store(time.Now().UnixNano())
+
+	// Complete a pending WriteAndWaitAck, the frame is still passed on below
+	u.deliverAck(frame.ID, frame.Payload)
+
+	// Chunks are passed on once their transfer is complete
+	payload, err := u.chunks.Add(frame.ID, frame.Payload, time.Now())
+	if err != nil {
+		log.Printf("RX Chunk error: %v", err)
+		u.counters.chunkErrors.Add(1)
+		return
+	}
+	if payload == nil {
+		return
+	}
+
+	// Queue the payload for the handler
+	if u.dispatcher != nil {
+		u.dispatcher.enqueue(payload)
+	}
+}
+
+// handleDecodeError logs and counts a rejected frame candidate
+func (u *USOCK) handleDecodeError(err *DecodeError) {
+	log.Printf("RX Error: %v", err)
+	u.counters.countDecodeError(err.Kind)
+	if err.Kind == DecodeErrHeaderCRC || err.Kind == DecodeErrPayloadCRC {
+		u.noteCRCError(err.FrameID, err.Data, err.Kind.String())
 	}
 }
 
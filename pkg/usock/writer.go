@@ -0,0 +1,249 @@
+package usock
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Priority is the scheduling class of an outbound frame. Queued frames of a
+// higher class are always written before those of a lower one; frames of the
+// same class are written in the order they were queued.
+type Priority int
+
+const (
+	PriorityControl   Priority = iota // Commands, ACKs and anything the user is waiting for
+	PriorityState                     // Vehicle and power state changes
+	PriorityTelemetry                 // Battery levels, mileage and other informational values
+
+	numPriorities = 3
+)
+
+// String returns a short name of the priority class
+func (p Priority) String() string {
+	switch p {
+	case PriorityControl:
+		return "control"
+	case PriorityState:
+		return "state"
+	case PriorityTelemetry:
+		return "telemetry"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// WriteConfig controls the outbound scheduler
+type WriteConfig struct {
+	MinGap  time.Duration // Minimum time between two frames written to the transport
+	Timeout time.Duration // Maximum time to write one frame, 0 for no limit
+}
+
+// DefaultWriteConfig is used until SetWriteConfig is called
+var DefaultWriteConfig = WriteConfig{
+	MinGap:  50 * time.Millisecond,
+	Timeout: time.Second,
+}
+
+// outbound is a frame waiting in the write queue
+type outbound struct {
+	frameID byte
+	data    []byte
+	key     string          // Coalescing key of posted frames, empty for none
+	ctx     context.Context // Context of sent frames, nil for posted ones
+	done    chan error      // Receives the write result of sent frames, nil for posted ones
+	ack     chan []byte     // Receives the ACK of the frame, see WriteAndWaitAck
+	notify  func(error)     // Called with the outcome of a posted frame, see PostFunc
+}
+
+// writeScheduler orders outbound frames by priority and coalesces posted
+// frames that carry the same key
+type writeScheduler struct {
+	mu        sync.Mutex
+	cfg       WriteConfig
+	queues    [numPriorities][]*outbound
+	pending   map[string]*outbound // Queued posted frames by key
+	coalesced uint64
+	notEmpty  chan struct{}
+}
+
+func newWriteScheduler() *writeScheduler {
+	return &writeScheduler{
+		cfg:      DefaultWriteConfig,
+		pending:  make(map[string]*outbound),
+		notEmpty: make(chan struct{}, 1),
+	}
+}
+
+// push queues a frame, or replaces the data of a queued frame with the same key
+func (s *writeScheduler) push(p Priority, item *outbound) {
+	if p < PriorityControl || p >= numPriorities {
+		p = PriorityTelemetry
+	}
+
+	s.mu.Lock()
+	if item.key != "" {
+		if queued, ok := s.pending[item.key]; ok {
+			queued.frameID = item.frameID
+			queued.data = item.data
+			if prev, next := queued.notify, item.notify; prev != nil && next != nil {
+				// The replaced data never goes out on its own, so it shares the outcome
+				queued.notify = func(err error) { prev(err); next(err) }
+			} else if next != nil {
+				queued.notify = next
+			}
+			s.coalesced++
+			s.mu.Unlock()
+			return
+		}
+		s.pending[item.key] = item
+	}
+	s.queues[p] = append(s.queues[p], item)
+	s.mu.Unlock()
+
+	wake(s.notEmpty)
+}
+
+// pop returns the next frame to write, or nil if the queue is empty
+func (s *writeScheduler) pop() *outbound {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for p := range s.queues {
+		if len(s.queues[p]) == 0 {
+			continue
+		}
+		item := s.queues[p][0]
+		s.queues[p][0] = nil
+		s.queues[p] = s.queues[p][1:]
+		if item.key != "" {
+			delete(s.pending, item.key)
+		}
+		return item
+	}
+	return nil
+}
+
+func (s *writeScheduler) config() WriteConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg
+}
+
+// SetWriteConfig changes the minimum gap between outbound frames and the write timeout
+func (u *USOCK) SetWriteConfig(cfg WriteConfig) {
+	u.scheduler.mu.Lock()
+	defer u.scheduler.mu.Unlock()
+	u.scheduler.cfg = cfg
+}
+
+// Send queues data with the given frame ID at priority p and waits until it
+// has been written to the transport
+func (u *USOCK) Send(p Priority, frameID byte, data []byte) error {
+	return u.SendContext(context.Background(), p, frameID, data)
+}
+
+// SendContext is like Send but gives up when ctx is done. A frame that has
+// not been written yet is then dropped from the queue.
+func (u *USOCK) SendContext(ctx context.Context, p Priority, frameID byte, data []byte) error {
+	if len(data) > MaxPayloadLength {
+		return fmt.Errorf("payload size exceeds maximum length of %d bytes", MaxPayloadLength)
+	}
+	return u.send(ctx, p, &outbound{frameID: frameID, data: data})
+}
+
+// send queues a frame at priority p and waits until it has been written
+func (u *USOCK) send(ctx context.Context, p Priority, item *outbound) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	item.ctx, item.done = ctx, make(chan error, 1)
+	select {
+	case <-u.stopChan:
+		return ErrClosed
+	default:
+	}
+	u.scheduler.push(p, item)
+
+	select {
+	case err := <-item.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-u.stopChan:
+		return ErrClosed
+	}
+}
+
+// Post queues data with the given frame ID at priority p and returns without
+// waiting for it to be written; write errors are only logged. If a frame
+// posted with the same non-empty key is still queued, its data is replaced
+// so that only the newest value is sent.
+func (u *USOCK) Post(p Priority, key string, frameID byte, data []byte) error {
+	return u.PostFunc(p, key, frameID, data, nil)
+}
+
+// PostFunc is like Post but calls notify, if not nil, with the outcome of
+// the frame once it is known: nil once the nRF52 acknowledged it, otherwise
+// the write error, a NackError or an AckTimeoutError. Frames still queued
+// when the USOCK is closed are not reported. A coalesced frame reports the
+// outcome of the frame that replaced its data.
+func (u *USOCK) PostFunc(p Priority, key string, frameID byte, data []byte, notify func(error)) error {
+	if len(data) > MaxPayloadLength {
+		return fmt.Errorf("payload size exceeds maximum length of %d bytes", MaxPayloadLength)
+	}
+
+	select {
+	case <-u.stopChan:
+		return ErrClosed
+	default:
+	}
+	u.scheduler.push(p, &outbound{frameID: frameID, data: data, key: key, notify: notify})
+	return nil
+}
+
+// writeLoop writes queued frames, highest priority first, keeping at least
+// MinGap between two frames
+func (u *USOCK) writeLoop() {
+	defer u.wg.Done()
+
+	var last time.Time
+	for {
+		cfg := u.scheduler.config()
+		if wait := time.Until(last.Add(cfg.MinGap)); wait > 0 {
+			select {
+			case <-u.stopChan:
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		item := u.scheduler.pop()
+		if item == nil {
+			select {
+			case <-u.stopChan:
+				return
+			case <-u.scheduler.notEmpty:
+			}
+			continue
+		}
+		if item.ctx != nil && item.ctx.Err() != nil {
+			continue // The sender gave up
+		}
+
+		pending := &pendingAck{ch: item.ack, notify: item.notify}
+		err := u.writeFrame(item.frameID, item.data, pending, cfg.Timeout)
+		if err != ErrLinkDown {
+			last = time.Now()
+		}
+		if item.done != nil {
+			item.done <- err
+		} else if err != nil {
+			log.Printf("Failed to send queued frame ID 0x%02x: %v", item.frameID, err)
+			pending.finish(err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package usock
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// received is a frame and the time it arrived
+type received struct {
+	data []byte
+	at   time.Time
+}
+
+// newWriterPair returns a USOCK writing with cfg and a channel receiving
+// what it writes
+func newWriterPair(t *testing.T, cfg WriteConfig) (*USOCK, chan received) {
+	a, b := NewPipe()
+	sender := NewWithTransport(a, nil)
+	t.Cleanup(func() { sender.Close() })
+	sender.SetWriteConfig(cfg)
+	frames := make(chan received, 16)
+	receiver := NewWithTransport(b, func(p *Payload) { frames <- received{p.Data, time.Now()} })
+	t.Cleanup(func() { receiver.Close() })
+	return sender, frames
+}
+
+func receive(t *testing.T, frames chan received, n int) []received {
+	t.Helper()
+	var got []received
+	for len(got) < n {
+		select {
+		case f := <-frames:
+			got = append(got, f)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d frames, want %d", len(got), n)
+		}
+	}
+	select {
+	case f := <-frames:
+		t.Fatalf("received %d frames and then % x, want %d", len(got), f.data, n)
+	case <-time.After(50 * time.Millisecond):
+	}
+	return got
+}
+
+// TestWritePriority queues frames of every class while the first one is
+// written: they go out control first, then state, then telemetry, each in
+// the order queued, at least MinGap apart
+func TestWritePriority(t *testing.T) {
+	const gap = 40 * time.Millisecond
+	sender, frames := newWriterPair(t, WriteConfig{MinGap: gap})
+
+	queued := []struct {
+		p    Priority
+		data byte
+	}{
+		{PriorityControl, 0x00}, // Written right away
+		{PriorityTelemetry, 0x31},
+		{PriorityState, 0x21},
+		{PriorityTelemetry, 0x32},
+		{PriorityControl, 0x11},
+		{PriorityState, 0x22},
+		{PriorityControl, 0x12},
+	}
+	for _, q := range queued {
+		if err := sender.Post(q.p, "", 0x20, []byte{q.data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := receive(t, frames, len(queued))
+	want := []byte{0x00, 0x11, 0x12, 0x21, 0x22, 0x31, 0x32}
+	for i, f := range got {
+		if f.data[0] != want[i] {
+			t.Errorf("frame %d: got %02x, want %02x", i, f.data[0], want[i])
+		}
+		// Allow for the receiver being scheduled late on the previous frame
+		if i > 0 && f.at.Sub(got[i-1].at) < gap-10*time.Millisecond {
+			t.Errorf("frame %d: %v after the previous one, want at least %v", i, f.at.Sub(got[i-1].at), gap)
+		}
+	}
+}
+
+// TestWriteCoalesce posts newer values of a queued frame: only the newest
+// is written, in the place of the first one
+func TestWriteCoalesce(t *testing.T) {
+	sender, frames := newWriterPair(t, WriteConfig{MinGap: 40 * time.Millisecond})
+
+	for _, post := range []struct {
+		key  string
+		data byte
+	}{
+		{"", 0x00}, // Written right away
+		{"battery", 0x01},
+		{"vehicle", 0x02},
+		{"battery", 0x03},
+		{"", 0x04},
+		{"battery", 0x05},
+		{"vehicle", 0x06},
+	} {
+		if err := sender.Post(PriorityState, post.key, 0x20, []byte{post.data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := receive(t, frames, 4)
+	var data []byte
+	for _, f := range got {
+		data = append(data, f.data...)
+	}
+	if want := []byte{0x00, 0x05, 0x06, 0x04}; !bytes.Equal(data, want) {
+		t.Errorf("got frames % x, want % x", data, want)
+	}
+	if n := sender.Stats().CoalescedFrames; n != 3 {
+		t.Errorf("got %d coalesced frames, want 3", n)
+	}
+
+	// Once written, a key is queued anew
+	if err := sender.Post(PriorityState, "battery", 0x20, []byte{0x07}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, frames, 1); got[0].data[0] != 0x07 {
+		t.Errorf("got % x after the queue drained, want 07", got[0].data)
+	}
+}